@@ -0,0 +1,91 @@
+// package for the handlers every request goes through before reaching the router
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+)
+
+// Middleware wraps a handler with behaviour shared by every route
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in the middlewares, the first one is the outermost and sees the request first
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// serveWithContext serves the request with its context replaced by ctx
+// the mux sets the matched pattern on the request it's given, it is copied back to r so the middlewares around this one see it too
+func serveWithContext(next http.Handler, w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	req := r.WithContext(ctx)
+	next.ServeHTTP(w, req)
+	r.Pattern = req.Pattern
+}
+
+// recorder keeps the status and size of a response for the middlewares that report on it
+// flushing and hijacking are passed through so NDJSON, server-sent events and websockets still work
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	// beforeHeader runs once, just before the headers go out, for headers that depend on how the handler went
+	beforeHeader []func(http.Header)
+}
+
+// record wraps w, reusing the recorder when an outer middleware has already wrapped it
+func record(w http.ResponseWriter) *recorder {
+	if rec, ok := w.(*recorder); ok {
+		return rec
+	}
+	return &recorder{ResponseWriter: w}
+}
+
+// headersSent runs the beforeHeader hooks and records status, unless the headers have already gone out
+func (rec *recorder) headersSent(status int) {
+	if rec.status != 0 {
+		return
+	}
+	for _, f := range rec.beforeHeader {
+		f(rec.Header())
+	}
+	rec.status = status
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.headersSent(status)
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.headersSent(http.StatusOK)
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Status is the status sent, 0 until the headers have gone out
+func (rec *recorder) Status() int {
+	return rec.status
+}
+
+func (rec *recorder) Flush() {
+	rec.headersSent(http.StatusOK)
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil && rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"telem-api-server/openf1"
+)
+
+// traceID is the id of the trace the request is part of, empty when tracing is off
+func traceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// AccessLog writes a line to logger for every request once it has been served
+// upstream_calls and upstream_duration cover the openf1 requests the handler made, calls served from the store aren't counted
+func AccessLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			var calls, upstream atomic.Int64
+			ctx := openf1.WithObserver(r.Context(), func(call openf1.Call) {
+				calls.Add(1)
+				upstream.Add(int64(call.Duration))
+			})
+			rec := record(w)
+			serveWithContext(next, rec, r, ctx)
+
+			status := rec.Status()
+			if status == 0 {
+				// nothing was written, net/http sends a 200 with no body
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(ctx, level, "request",
+				slog.String("request_id", RequestIDFrom(ctx)),
+				slog.String("trace_id", traceID(ctx)),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				// unmatched requests have no pattern
+				slog.String("route", r.Pattern),
+				slog.Int("status", status),
+				slog.Int64("bytes", rec.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.Int64("upstream_calls", calls.Load()),
+				slog.Duration("upstream_duration", time.Duration(upstream.Load())),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"telem-api-server/api/middleware"
+	"telem-api-server/openf1"
+)
+
+// logged serves r through RequestID and AccessLog around mux and returns the access log line
+func logged(t *testing.T, mux http.Handler, r *http.Request) (map[string]any, *httptest.ResponseRecorder) {
+	t.Helper()
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	w := httptest.NewRecorder()
+	middleware.Chain(mux, middleware.RequestID, middleware.AccessLog(logger)).ServeHTTP(w, r)
+
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("access log %q isn't a single JSON line: %v", logs.String(), err)
+	}
+	return line, w
+}
+
+func TestAccessLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"session_key":9158}]`))
+	}))
+	t.Cleanup(upstream.Close)
+	client := openf1.NewClient(upstream.URL)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions/{key}", func(w http.ResponseWriter, r *http.Request) {
+		var sessions []openf1.Session
+		if err := client.Get(r.Context(), "sessions", nil, &sessions); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("GET /broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusBadGateway)
+	})
+
+	line, w := logged(t, mux, httptest.NewRequest(http.MethodGet, "/sessions/9158", nil))
+	want := map[string]any{
+		"level":          "INFO",
+		"msg":            "request",
+		"request_id":     w.Header().Get(middleware.RequestIDHeader),
+		"method":         "GET",
+		"path":           "/sessions/9158",
+		"route":          "GET /sessions/{key}",
+		"status":         float64(200),
+		"bytes":          float64(5),
+		"upstream_calls": float64(1),
+	}
+	for field, value := range want {
+		if line[field] != value {
+			t.Errorf("%s = %v, want %v", field, line[field], value)
+		}
+	}
+	for _, field := range []string{"duration", "upstream_duration", "remote_addr"} {
+		if _, ok := line[field]; !ok {
+			t.Errorf("no %s in %v", field, line)
+		}
+	}
+
+	// server errors are logged as errors, unmatched requests have no route
+	line, _ = logged(t, mux, httptest.NewRequest(http.MethodGet, "/broken", nil))
+	if line["level"] != "ERROR" || line["status"] != float64(502) || line["upstream_calls"] != float64(0) {
+		t.Errorf("log = %v, want an error for the 502 with no upstream calls", line)
+	}
+	line, _ = logged(t, mux, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	if line["route"] != "" || line["status"] != float64(404) {
+		t.Errorf("log = %v, want a 404 without a route", line)
+	}
+}
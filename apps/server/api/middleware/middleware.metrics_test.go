@@ -0,0 +1,62 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"telem-api-server/api/middleware"
+	"telem-api-server/metrics"
+)
+
+func requests(t *testing.T, route string, method string, status string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.HTTPRequests.WithLabelValues(route, method, status).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestMetricsLabels(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions/{key}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /graphql", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "query is required", http.StatusBadRequest)
+	})
+	handler := middleware.Metrics(mux)
+
+	tests := []struct {
+		method, path  string
+		route, status string
+	}{
+		// every session is one route, not a label each
+		{http.MethodGet, "/sessions/9158", "GET /sessions/{key}", "200"},
+		{http.MethodGet, "/sessions/9159", "GET /sessions/{key}", "200"},
+		{http.MethodPost, "/graphql", "POST /graphql", "400"},
+		{http.MethodGet, "/wp-admin.php", "unmatched", "404"},
+	}
+	before := map[[3]string]float64{}
+	for _, tt := range tests {
+		labels := [3]string{tt.route, tt.method, tt.status}
+		if _, ok := before[labels]; !ok {
+			before[labels] = requests(t, tt.route, tt.method, tt.status)
+		}
+	}
+	for _, tt := range tests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+	}
+
+	want := map[[3]string]float64{
+		{"GET /sessions/{key}", "GET", "200"}: 2,
+		{"POST /graphql", "POST", "400"}:      1,
+		{"unmatched", "GET", "404"}:           1,
+	}
+	for labels, n := range want {
+		if got := requests(t, labels[0], labels[1], labels[2]) - before[labels]; got != n {
+			t.Errorf("requests %v = %v, want %v", labels, got, n)
+		}
+	}
+}
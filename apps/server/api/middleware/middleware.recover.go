@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// ErrorResponse is the body of the JSON errors written by the middlewares
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Recover turns a panicking handler into a JSON 500 rather than a dropped connection, the panic is logged with its stack
+// if the response had already started there is nothing left to send and the connection is closed instead
+func Recover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := record(w)
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					// the handler asked for the connection to be dropped
+					panic(v)
+				}
+				id := RequestIDFrom(r.Context())
+				logger.ErrorContext(r.Context(), "panic serving request",
+					slog.String("request_id", id),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("panic", fmt.Sprint(v)),
+					slog.String("stack", string(debug.Stack())),
+				)
+				if rec.Status() != 0 {
+					panic(http.ErrAbortHandler)
+				}
+				rec.Header().Set("Content-Type", "application/json")
+				rec.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(rec).Encode(ErrorResponse{Error: "Internal server error", RequestID: id})
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"telem-api-server/api/middleware"
+)
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := middleware.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("lap index out of range")
+	}), middleware.RequestID, middleware.Recover(logger))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions/9158/laps", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type = %s, want application/json", got)
+	}
+	var body middleware.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body isn't JSON: %v", err)
+	}
+	if body.Error == "" || body.RequestID != w.Header().Get(middleware.RequestIDHeader) {
+		t.Errorf("body = %+v, want an error and the request's id", body)
+	}
+	// the panic itself only goes to the log
+	if strings.Contains(w.Body.String(), "lap index") {
+		t.Errorf("body %s gives away the panic", w.Body.String())
+	}
+	if !strings.Contains(logs.String(), "lap index out of range") || !strings.Contains(logs.String(), `"stack"`) {
+		t.Errorf("log = %s, want the panic and its stack", logs.String())
+	}
+}
+
+func TestRecoverAfterResponseStarted(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	srv := httptest.NewServer(middleware.Recover(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(`{"laps":[`))
+		w.(http.Flusher).Flush()
+		panic("halfway through")
+	})))
+	t.Cleanup(srv.Close)
+
+	response, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	// a 500 can't follow a 200, the connection is dropped so the client can tell the body is cut short
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want the 200 already sent", response.StatusCode)
+	}
+	if _, err := io.ReadAll(response.Body); err == nil {
+		t.Error("body read to the end, want the connection dropped")
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the request's id in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// ids from clients longer than this are replaced rather than logged
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID gives every request an id, the client's own X-Request-ID when it sent a usable one
+// the id is sent back in the response headers and is available to handlers through RequestIDFrom
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		serveWithContext(next, w, r, context.WithValue(r.Context(), requestIDKey{}, id))
+	})
+}
+
+// RequestIDFrom returns the id of the request ctx belongs to, empty outside of a request
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID keeps client ids to characters that are safe to echo in a header and write to logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
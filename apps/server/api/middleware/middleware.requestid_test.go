@@ -0,0 +1,63 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"telem-api-server/api/middleware"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		// keep is whether the client's id is used rather than a new one
+		keep bool
+	}{
+		{name: "none sent", header: ""},
+		{name: "client's id", header: "b1e9-4f2a_7.c:1", keep: true},
+		{name: "unsafe characters", header: "abc\r\nSet-Cookie: x=1"},
+		{name: "too long", header: strings.Repeat("a", 129)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = middleware.RequestIDFrom(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+			if tt.header != "" {
+				r.Header.Set(middleware.RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			id := w.Header().Get(middleware.RequestIDHeader)
+			if id == "" || id != seen {
+				t.Fatalf("response id = %q, handler saw %q, want the same id in both", id, seen)
+			}
+			if (id == tt.header) != tt.keep {
+				t.Errorf("id = %q for %q sent, want the client's id kept: %v", id, tt.header, tt.keep)
+			}
+			if !tt.keep && len(id) != 32 {
+				t.Errorf("generated id = %q, want 32 hex characters", id)
+			}
+		})
+	}
+}
+
+func TestRequestIDsAreUnique(t *testing.T) {
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	seen := map[string]bool{}
+	for range 100 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		id := w.Header().Get(middleware.RequestIDHeader)
+		if seen[id] {
+			t.Fatalf("id %s handed out twice", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"telem-api-server/store"
+)
+
+// StaleHeader is set on responses built from stored data because openf1 couldn't be reached
+const StaleHeader = "X-Data-Stale"
+
+// Stale flags responses that were served from the store in place of openf1, which is what happens while the breaker is open
+// clients get X-Data-Stale: true and the standard Warning: 110 so caches in between know too
+func Stale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// resources can be looked up from several goroutines for one request
+		var stale atomic.Bool
+		rec := record(w)
+		rec.beforeHeader = append(rec.beforeHeader, func(h http.Header) {
+			if stale.Load() {
+				h.Set(StaleHeader, "true")
+				h.Set("Warning", `110 - "Response is Stale"`)
+			}
+		})
+		ctx := store.WithStaleObserver(r.Context(), func(string) {
+			stale.Store(true)
+		})
+		serveWithContext(next, rec, r, ctx)
+	})
+}
@@ -0,0 +1,62 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"telem-api-server/api/middleware"
+	"telem-api-server/store"
+)
+
+func TestStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		stale   bool
+	}{
+		{
+			name:    "fresh",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("[]")) },
+		},
+		{
+			name: "served from the store",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				store.MarkStale(r.Context(), "laps")
+				w.Write([]byte("[]"))
+			},
+			stale: true,
+		},
+		{
+			// the status has to be set by the handler as well
+			name: "served from the store with a status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				store.MarkStale(r.Context(), "laps")
+				w.WriteHeader(http.StatusOK)
+			},
+			stale: true,
+		},
+		{
+			// once the headers are out it's too late to say
+			name: "marked after the headers",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("["))
+				store.MarkStale(r.Context(), "laps")
+				w.Write([]byte("]"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			// the access log and metrics wrap the writer first in the server, the stale header still has to get through
+			middleware.Chain(tt.handler, middleware.Metrics, middleware.Stale).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions/9158/laps", nil))
+			if got := w.Header().Get(middleware.StaleHeader) == "true"; got != tt.stale {
+				t.Errorf("%s = %q, want stale %v", middleware.StaleHeader, w.Header().Get(middleware.StaleHeader), tt.stale)
+			}
+			if got := w.Header().Get("Warning") != ""; got != tt.stale {
+				t.Errorf("Warning = %q, want one %v", w.Header().Get("Warning"), tt.stale)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// package for bulk exports of session data for offline analysis
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// fetcher loads a resource for a session and returns a function that writes it out as Parquet
+// fetching happens before anything is written so errors can still be reported with a status code
+type fetcher func(ctx context.Context, source *store.Source, sessionKey int) (func(w io.Writer) error, error)
+
+var fetchers = map[string]fetcher{
+	"car_data": fetchCarData,
+	"drivers":  fetchDrivers,
+	"laps":     fetchLaps,
+	"location": fetchLocation,
+	"position": fetchPosition,
+	"stints":   fetchStints,
+}
+
+// DefaultResources are exported when the resources param is left out
+var DefaultResources = []string{"car_data", "laps", "location"}
+
+// how long fetching and writing a single resource of an export may take
+const resourceWriteTimeout = 5 * time.Minute
+
+// Helper Functions
+func parseResources(value string) ([]string, error) {
+	if value == "" {
+		return DefaultResources, nil
+	}
+	var resources []string
+	seen := map[string]bool{}
+	for _, resource := range strings.Split(value, ",") {
+		resource = strings.TrimSpace(resource)
+		if resource == "" || seen[resource] {
+			continue
+		}
+		if _, ok := fetchers[resource]; !ok {
+			return nil, fmt.Errorf("unknown resource %q", resource)
+		}
+		seen[resource] = true
+		resources = append(resources, resource)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no resources requested")
+	}
+	return resources, nil
+}
+
+// fetchOptional treats openf1 having no data as an empty resource, the file is still written with its columns
+func fetchOptional[T any](rows []T, err error) ([]T, error) {
+	if errors.Is(err, openf1.ErrNoResults) {
+		return nil, nil
+	}
+	return rows, err
+}
+
+func fetchLaps(ctx context.Context, source *store.Source, sessionKey int) (func(w io.Writer) error, error) {
+	laps, err := fetchOptional(source.Laps(ctx, sessionKey))
+	if err != nil {
+		return nil, err
+	}
+	rows := convert(laps, NewLapRow)
+	return func(w io.Writer) error { return WriteParquet(w, rows) }, nil
+}
+
+// car data is fetched per driver, openf1 won't return a whole session of it in one response
+func fetchCarData(ctx context.Context, source *store.Source, sessionKey int) (func(w io.Writer) error, error) {
+	drivers, err := fetchOptional(source.Drivers(ctx, sessionKey))
+	if err != nil {
+		return nil, err
+	}
+	var rows []CarDataRow
+	for _, d := range drivers {
+		samples, err := fetchOptional(source.CarData(ctx, sessionKey, d.DriverNumber))
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, convert(samples, NewCarDataRow)...)
+	}
+	return func(w io.Writer) error { return WriteParquet(w, rows) }, nil
+}
+
+// location is sampled like car data, so it is fetched per driver too
+func fetchLocation(ctx context.Context, source *store.Source, sessionKey int) (func(w io.Writer) error, error) {
+	drivers, err := fetchOptional(source.Drivers(ctx, sessionKey))
+	if err != nil {
+		return nil, err
+	}
+	var rows []LocationRow
+	for _, d := range drivers {
+		samples, err := fetchOptional(source.Location(ctx, sessionKey, d.DriverNumber))
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, convert(samples, NewLocationRow)...)
+	}
+	return func(w io.Writer) error { return WriteParquet(w, rows) }, nil
+}
+
+func fetchPosition(ctx context.Context, source *store.Source, sessionKey int) (func(w io.Writer) error, error) {
+	positions, err := fetchOptional(source.Positions(ctx, sessionKey))
+	if err != nil {
+		return nil, err
+	}
+	rows := convert(positions, NewPositionRow)
+	return func(w io.Writer) error { return WriteParquet(w, rows) }, nil
+}
+
+func fetchStints(ctx context.Context, source *store.Source, sessionKey int) (func(w io.Writer) error, error) {
+	stints, err := fetchOptional(source.Stints(ctx, sessionKey))
+	if err != nil {
+		return nil, err
+	}
+	rows := convert(stints, NewStintRow)
+	return func(w io.Writer) error { return WriteParquet(w, rows) }, nil
+}
+
+func fetchDrivers(ctx context.Context, source *store.Source, sessionKey int) (func(w io.Writer) error, error) {
+	drivers, err := fetchOptional(source.Drivers(ctx, sessionKey))
+	if err != nil {
+		return nil, err
+	}
+	rows := convert(drivers, NewDriverRow)
+	return func(w io.Writer) error { return WriteParquet(w, rows) }, nil
+}
+
+// Handler exports sessions read through Source
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Logger *slog.Logger
+}
+
+// Export Handlers
+func (h *Handler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("key"))
+	if err != nil {
+		http.Error(w, "Invalid session Key Id", http.StatusBadRequest)
+		return
+	}
+	h.handleGetExport(w, r, id)
+}
+
+// business logic of the handler methods
+func (h *Handler) handleGetExport(w http.ResponseWriter, r *http.Request, id int) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "parquet" {
+		http.Error(w, "Unsupported export format, only parquet is available", http.StatusBadRequest)
+		return
+	}
+	resources, err := parseResources(r.URL.Query().Get("resources"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid resources: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := h.Source.Sessions(r.Context())
+	if err != nil {
+		h.Logger.Error("fetching sessions", "error", err)
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	if session.FindSessionById(sessions, id) == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// the first resource is fetched before the headers go out so an unreachable openf1 is a 500 rather than a broken zip
+	write, err := fetchers[resources[0]](r.Context(), h.Source, id)
+	if err != nil {
+		h.Logger.Error("fetching for export", "resource", resources[0], "error", err)
+		http.Error(w, fmt.Sprintf("Error fetching %s", resources[0]), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="session_%d.zip"`, id))
+	archive := zip.NewWriter(w)
+	rc := http.NewResponseController(w)
+	for i, resource := range resources {
+		// a whole session can take longer than the server's write timeout, each resource gets its own
+		rc.SetWriteDeadline(time.Now().Add(resourceWriteTimeout))
+		if i > 0 {
+			write, err = fetchers[resource](r.Context(), h.Source, id)
+			if err != nil {
+				// the status has already been sent, leaving the zip unfinished is how the client finds out
+				h.Logger.Error("fetching for export, aborting", "resource", resource, "error", err)
+				return
+			}
+		}
+		// Parquet pages are already compressed, deflating them again only costs time
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     resource + ".parquet",
+			Method:   zip.Store,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			h.Logger.Error("writing export", "error", err)
+			return
+		}
+		if err := write(entry); err != nil {
+			h.Logger.Error("writing export", "resource", resource, "error", err)
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	if err := archive.Close(); err != nil {
+		h.Logger.Error("writing export", "error", err)
+	}
+}
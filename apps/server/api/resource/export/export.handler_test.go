@@ -0,0 +1,143 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"telem-api-server/api/resource/export"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// fakeClient answers each resource with its rows, as openf1 would for any session key
+// resources it has no rows for are ErrNoResults, the way openf1 answers a 404
+type fakeClient map[string]any
+
+func (c fakeClient) Get(ctx context.Context, resource string, params url.Values, out any) error {
+	rows, ok := c[resource]
+	if !ok {
+		return openf1.ErrNoResults
+	}
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func newExportServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	at := time.Date(2023, 9, 17, 12, 0, 0, 0, time.UTC)
+	client := fakeClient{
+		"sessions": []openf1.Session{{SessionKey: 9158, SessionName: "Race", SessionType: "Race", DateStart: at}},
+		"drivers":  []openf1.Driver{{SessionKey: 9158, DriverNumber: 1}, {SessionKey: 9158, DriverNumber: 55}},
+		"laps":     []openf1.Lap{{SessionKey: 9158, DriverNumber: 1, LapNumber: 1, DateStart: at}},
+		"car_data": []openf1.CarData{{SessionKey: 9158, DriverNumber: 1, Date: at, Speed: 290}},
+		"stints":   []openf1.Stint{{SessionKey: 9158, DriverNumber: 1, StintNumber: 1, Compound: "SOFT"}},
+	}
+	handler := &export.Handler{Config: config.Default(), Source: &store.Source{Client: client, Clock: store.SystemClock{}}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions/{key}/export", handler.ExportHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExportArchive(t *testing.T) {
+	srv := newExportServer(t)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"car_data.parquet", "laps.parquet", "location.parquet"}},
+		{"?resources=stints,drivers,stints", []string{"drivers.parquet", "stints.parquet"}},
+		// openf1 having no positions still gets a file, with no rows
+		{"?resources=position", []string{"position.parquet"}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			response, err := http.Get(srv.URL + "/sessions/9158/export" + test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", response.StatusCode)
+			}
+			if got := response.Header.Get("Content-Type"); got != "application/zip" {
+				t.Errorf("content type = %s, want application/zip", got)
+			}
+			data, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, f := range archive.File {
+				names = append(names, f.Name)
+				// every entry is a Parquet file of its own
+				r, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				entry, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := parquet.OpenFile(bytes.NewReader(entry), int64(len(entry))); err != nil {
+					t.Errorf("%s is not a Parquet file: %v", f.Name, err)
+				}
+			}
+			sort.Strings(names)
+			if len(names) != len(test.want) {
+				t.Fatalf("entries = %v, want %v", names, test.want)
+			}
+			for i := range names {
+				if names[i] != test.want[i] {
+					t.Errorf("entries = %v, want %v", names, test.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	srv := newExportServer(t)
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/sessions/9158/export?resources=weather", http.StatusBadRequest},
+		{"/sessions/9158/export?format=csv", http.StatusBadRequest},
+		{"/sessions/1/export", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		response, err := http.Get(srv.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("%s status = %d, want %d", test.path, response.StatusCode, test.status)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"telem-api-server/openf1"
+)
+
+// Row types for the Parquet files, column names match the openf1 JSON fields
+// values openf1 sends as null are pointers so they become nullable columns rather than zeros
+// dates are written as UTC timestamps with nanosecond precision, which pandas and DuckDB read natively
+
+type LapRow struct {
+	MeetingKey      int        `parquet:"meeting_key"`
+	SessionKey      int        `parquet:"session_key"`
+	DriverNumber    int        `parquet:"driver_number"`
+	LapNumber       int        `parquet:"lap_number"`
+	DateStart       *time.Time `parquet:"date_start,optional"`
+	DurationSector1 *float64   `parquet:"duration_sector_1,optional"`
+	DurationSector2 *float64   `parquet:"duration_sector_2,optional"`
+	DurationSector3 *float64   `parquet:"duration_sector_3,optional"`
+	I1Speed         *int       `parquet:"i1_speed,optional"`
+	I2Speed         *int       `parquet:"i2_speed,optional"`
+	IsPitOutLap     bool       `parquet:"is_pit_out_lap"`
+	LapDuration     *float64   `parquet:"lap_duration,optional"`
+	SegmentsSector1 []int      `parquet:"segments_sector_1,optional,list"`
+	SegmentsSector2 []int      `parquet:"segments_sector_2,optional,list"`
+	SegmentsSector3 []int      `parquet:"segments_sector_3,optional,list"`
+	StSpeed         *int       `parquet:"st_speed,optional"`
+}
+
+type CarDataRow struct {
+	MeetingKey   int       `parquet:"meeting_key"`
+	SessionKey   int       `parquet:"session_key"`
+	DriverNumber int       `parquet:"driver_number"`
+	Date         time.Time `parquet:"date"`
+	Brake        int       `parquet:"brake"`
+	DRS          int       `parquet:"drs"`
+	NGear        int       `parquet:"n_gear"`
+	RPM          int       `parquet:"rpm"`
+	Speed        int       `parquet:"speed"`
+	Throttle     int       `parquet:"throttle"`
+}
+
+type LocationRow struct {
+	MeetingKey   int       `parquet:"meeting_key"`
+	SessionKey   int       `parquet:"session_key"`
+	DriverNumber int       `parquet:"driver_number"`
+	Date         time.Time `parquet:"date"`
+	X            int       `parquet:"x"`
+	Y            int       `parquet:"y"`
+	Z            int       `parquet:"z"`
+}
+
+type PositionRow struct {
+	MeetingKey   int       `parquet:"meeting_key"`
+	SessionKey   int       `parquet:"session_key"`
+	DriverNumber int       `parquet:"driver_number"`
+	Date         time.Time `parquet:"date"`
+	Position     int       `parquet:"position"`
+}
+
+type StintRow struct {
+	MeetingKey     int    `parquet:"meeting_key"`
+	SessionKey     int    `parquet:"session_key"`
+	DriverNumber   int    `parquet:"driver_number"`
+	StintNumber    int    `parquet:"stint_number"`
+	Compound       string `parquet:"compound,dict"`
+	LapStart       int    `parquet:"lap_start"`
+	LapEnd         int    `parquet:"lap_end"`
+	TyreAgeAtStart int    `parquet:"tyre_age_at_start"`
+}
+
+type DriverRow struct {
+	MeetingKey    int    `parquet:"meeting_key"`
+	SessionKey    int    `parquet:"session_key"`
+	DriverNumber  int    `parquet:"driver_number"`
+	BroadcastName string `parquet:"broadcast_name"`
+	CountryCode   string `parquet:"country_code"`
+	FirstName     string `parquet:"first_name"`
+	FullName      string `parquet:"full_name"`
+	HeadshotUrl   string `parquet:"headshot_url"`
+	LastName      string `parquet:"last_name"`
+	NameAcronym   string `parquet:"name_acronym"`
+	TeamColour    string `parquet:"team_colour"`
+	TeamName      string `parquet:"team_name"`
+}
+
+func NewLapRow(l openf1.Lap) LapRow {
+	row := LapRow{
+		MeetingKey:      l.MeetingKey,
+		SessionKey:      l.SessionKey,
+		DriverNumber:    l.DriverNumber,
+		LapNumber:       l.LapNumber,
+		DurationSector1: l.DurationS1,
+		DurationSector2: l.DurationS2,
+		DurationSector3: l.DurationS3,
+		I1Speed:         l.SpeedI1,
+		I2Speed:         l.SpeedI2,
+		IsPitOutLap:     l.IsPitOutLap,
+		LapDuration:     l.LapDuration,
+		SegmentsSector1: l.SegmentsS1,
+		SegmentsSector2: l.SegmentsS2,
+		SegmentsSector3: l.SegmentsS3,
+		StSpeed:         l.StSpeed,
+	}
+	// openf1 sends null for the start of laps it couldn't time
+	if !l.DateStart.IsZero() {
+		dateStart := l.DateStart
+		row.DateStart = &dateStart
+	}
+	return row
+}
+
+func NewCarDataRow(c openf1.CarData) CarDataRow {
+	return CarDataRow{
+		MeetingKey:   c.MeetingKey,
+		SessionKey:   c.SessionKey,
+		DriverNumber: c.DriverNumber,
+		Date:         c.Date,
+		Brake:        c.Brake,
+		DRS:          c.DRS,
+		NGear:        c.NGear,
+		RPM:          c.RPM,
+		Speed:        c.Speed,
+		Throttle:     c.Throttle,
+	}
+}
+
+func NewLocationRow(l openf1.Location) LocationRow {
+	return LocationRow{
+		MeetingKey:   l.MeetingKey,
+		SessionKey:   l.SessionKey,
+		DriverNumber: l.DriverNumber,
+		Date:         l.Date,
+		X:            l.X,
+		Y:            l.Y,
+		Z:            l.Z,
+	}
+}
+
+func NewPositionRow(p openf1.Position) PositionRow {
+	return PositionRow{
+		MeetingKey:   p.MeetingKey,
+		SessionKey:   p.SessionKey,
+		DriverNumber: p.DriverNumber,
+		Date:         p.Date,
+		Position:     p.Position,
+	}
+}
+
+func NewStintRow(s openf1.Stint) StintRow {
+	return StintRow{
+		MeetingKey:     s.MeetingKey,
+		SessionKey:     s.SessionKey,
+		DriverNumber:   s.DriverNumber,
+		StintNumber:    s.StintNumber,
+		Compound:       s.Compound,
+		LapStart:       s.LapStart,
+		LapEnd:         s.LapEnd,
+		TyreAgeAtStart: s.TyreAgeAtStart,
+	}
+}
+
+func NewDriverRow(d openf1.Driver) DriverRow {
+	return DriverRow{
+		MeetingKey:    d.MeetingKey,
+		SessionKey:    d.SessionKey,
+		DriverNumber:  d.DriverNumber,
+		BroadcastName: d.BroadcastName,
+		CountryCode:   d.CountryCode,
+		FirstName:     d.FirstName,
+		FullName:      d.FullName,
+		HeadshotUrl:   d.HeadshotUrl,
+		LastName:      d.LastName,
+		NameAcronym:   d.NameAcronym,
+		TeamColour:    d.TeamColour,
+		TeamName:      d.TeamName,
+	}
+}
+
+// convert maps openf1 rows to their Parquet rows
+func convert[T any, R any](rows []T, fn func(T) R) []R {
+	converted := make([]R, len(rows))
+	for i, row := range rows {
+		converted[i] = fn(row)
+	}
+	return converted
+}
+
+// WriteParquet writes rows as a single zstd compressed Parquet file, an empty slice still writes the schema
+func WriteParquet[R any](w io.Writer, rows []R) error {
+	writer := parquet.NewGenericWriter[R](w, parquet.Compression(&parquet.Zstd))
+	if _, err := writer.Write(rows); err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,81 @@
+package export_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"telem-api-server/api/resource/export"
+	"telem-api-server/openf1"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestLapRowNulls(t *testing.T) {
+	timed := openf1.Lap{
+		MeetingKey: 1219, SessionKey: 9158, DriverNumber: 1, LapNumber: 2,
+		DateStart:  time.Date(2023, 9, 17, 12, 3, 4, 123000000, time.UTC),
+		DurationS1: ptr(33.1), DurationS2: ptr(40.25), DurationS3: ptr(23.8),
+		SpeedI1: ptr(281), SpeedI2: ptr(254), LapDuration: ptr(97.15), StSpeed: ptr(301),
+		SegmentsS1: []int{2049, 2051}, SegmentsS2: []int{2048}, SegmentsS3: []int{2049},
+	}
+	// openf1 sends null for everything it couldn't time on an out lap
+	untimed := openf1.Lap{MeetingKey: 1219, SessionKey: 9158, DriverNumber: 1, LapNumber: 1, IsPitOutLap: true}
+	// and a lap can be missing a single sector, a zero there would read as a real time
+	partial := timed
+	partial.LapNumber = 3
+	partial.DurationS2 = nil
+	partial.LapDuration = nil
+	partial.SpeedI2 = nil
+
+	rows := []export.LapRow{export.NewLapRow(timed), export.NewLapRow(untimed), export.NewLapRow(partial)}
+	var buf bytes.Buffer
+	if err := export.WriteParquet(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	got, err := parquet.Read[export.LapRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(rows) {
+		t.Fatalf("read %d rows, want %d", len(got), len(rows))
+	}
+
+	if !reflect.DeepEqual(got[0], rows[0]) {
+		t.Errorf("timed lap = %+v, want %+v", got[0], rows[0])
+	}
+	out := got[1]
+	if out.DateStart != nil || out.DurationSector1 != nil || out.I1Speed != nil || out.LapDuration != nil || out.StSpeed != nil {
+		t.Errorf("untimed lap = %+v, want nulls", out)
+	}
+	if len(out.SegmentsSector1) != 0 || !out.IsPitOutLap {
+		t.Errorf("untimed lap = %+v, want no segments on a pit out lap", out)
+	}
+	p := got[2]
+	if p.DurationSector2 != nil || p.LapDuration != nil || p.I2Speed != nil {
+		t.Errorf("partial lap = %+v, want the missing sector, speed and duration null", p)
+	}
+	if p.DurationSector1 == nil || *p.DurationSector1 != 33.1 || p.I1Speed == nil || *p.I1Speed != 281 {
+		t.Errorf("partial lap = %+v, want the timed sector and speed kept", p)
+	}
+}
+
+func TestWriteParquetEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := export.WriteParquet[export.LapRow](&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the columns are still there to read an empty session against
+	if _, ok := file.Schema().Lookup("lap_duration"); !ok || file.NumRows() != 0 {
+		t.Errorf("empty file has %d rows and schema %v", file.NumRows(), file.Schema())
+	}
+}
@@ -0,0 +1,113 @@
+package gql
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+
+	"telem-api-server/config"
+	"telem-api-server/store"
+)
+
+// queries are small, anything bigger than this isn't one
+const maxRequestBytes = 1 << 20
+
+// Request is the body of a GraphQL request, GET requests send the same fields as query parameters
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Helper Functions
+func parseRequest(w http.ResponseWriter, r *http.Request) (Request, error) {
+	var req Request
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return req, err
+			}
+		}
+		return req, nil
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req)
+	return req, err
+}
+
+// Execute parses, validates and checks the query against the limits before running it
+// failures before execution come back with no data, which the handler answers with a 400
+func Execute(r *http.Request, req Request, src *store.Source) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	validation := graphql.ValidateDocument(&Schema, doc, nil)
+	if !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+	if err := CheckLimits(Schema, doc, req.OperationName); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	// loaders only live for the request so nothing is cached between clients beyond what the store keeps
+	ctx := WithLoaders(r.Context(), NewLoaders(r.Context(), src))
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        Schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+}
+
+// Handler runs GraphQL queries, the loaders read through Source
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Logger *slog.Logger
+}
+
+// GraphQL Handlers
+func (h *Handler) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	h.handleGraphQL(w, r)
+}
+
+// business logic of the handler methods
+func (h *Handler) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRequest(w, r)
+	if err != nil {
+		h.writeResult(w, http.StatusBadRequest, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
+	}
+	if req.Query == "" {
+		h.writeResult(w, http.StatusBadRequest, &graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError("query is required")},
+		})
+		return
+	}
+
+	result := Execute(r, req, h.Source)
+	status := http.StatusOK
+	if result.Data == nil && len(result.Errors) > 0 {
+		status = http.StatusBadRequest
+	}
+	h.writeResult(w, status, result)
+}
+
+func (h *Handler) writeResult(w http.ResponseWriter, status int, result *graphql.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.Logger.Error("encoding graphql response", "error", err)
+	}
+}
@@ -0,0 +1,179 @@
+package gql
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// MaxDepth is how many fields deep a query can nest, sessions { drivers { laps { lap_duration } } } is 4
+var MaxDepth = 6
+
+// MaxComplexity caps the estimated work of a query, every field costs 1 and whatever is selected under
+// a list field is counted once per expected element, see listSize
+// a season's sessions with every driver's laps comes to around 13000, every session openf1 has is well over
+var MaxComplexity = 20000
+
+// listFactor is the expected length of a list field with nothing better to go on
+const listFactor = 10
+
+// rough sizes of the lists that can't be bounded any other way, openf1 has had 20 to 24 meetings a season
+// from 2023 with 5 or 6 sessions each, allSessions leaves room for the seasons still to come
+const (
+	meetingsPerSeason  = 24
+	sessionsPerMeeting = 6
+	sessionsPerSeason  = meetingsPerSeason * sessionsPerMeeting
+	allSessions        = 5 * sessionsPerSeason
+)
+
+// CheckLimits rejects queries that nest deeper than MaxDepth or cost more than MaxComplexity
+// introspection fields are left out so tools like GraphiQL can load the schema
+func CheckLimits(schema graphql.Schema, doc *ast.Document, operationName string) error {
+	fragments := map[string]*ast.FragmentDefinition{}
+	var operations []*ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operations = append(operations, definition)
+			}
+		}
+	}
+
+	for _, operation := range operations {
+		m := measurer{fragments: fragments, visiting: map[string]bool{}}
+		depth, cost := m.selectionSet(schema.QueryType(), operation.SelectionSet)
+		if depth > MaxDepth {
+			return fmt.Errorf("query depth %d is over the limit of %d", depth, MaxDepth)
+		}
+		if cost > MaxComplexity {
+			return fmt.Errorf("query complexity %d is over the limit of %d", cost, MaxComplexity)
+		}
+	}
+	return nil
+}
+
+type measurer struct {
+	fragments map[string]*ast.FragmentDefinition
+	// fragments being expanded, a fragment spreading itself is left to validation to reject
+	visiting map[string]bool
+}
+
+// selectionSet returns the depth and cost of a selection set on parent
+func (m measurer) selectionSet(parent *graphql.Object, set *ast.SelectionSet) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+	depth, cost := 0, 0
+	for _, selection := range set.Selections {
+		var d, c int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			d, c = m.field(parent, selection)
+		case *ast.InlineFragment:
+			d, c = m.selectionSet(parent, selection.SelectionSet)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := m.fragments[name]
+			if !ok || m.visiting[name] {
+				continue
+			}
+			m.visiting[name] = true
+			d, c = m.selectionSet(parent, fragment.SelectionSet)
+			delete(m.visiting, name)
+		}
+		depth = max(depth, d)
+		cost += c
+	}
+	return depth, cost
+}
+
+func (m measurer) field(parent *graphql.Object, field *ast.Field) (int, int) {
+	name := field.Name.Value
+	if len(name) >= 2 && name[:2] == "__" {
+		return 0, 0
+	}
+	if parent == nil {
+		return 1, 1
+	}
+	definition, ok := parent.Fields()[name]
+	if !ok {
+		// unknown fields are reported by validation
+		return 1, 1
+	}
+
+	fieldType := definition.Type
+	isList := false
+	for {
+		if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+			fieldType = nonNull.OfType
+			continue
+		}
+		if list, ok := fieldType.(*graphql.List); ok {
+			isList = true
+			fieldType = list.OfType
+			continue
+		}
+		break
+	}
+	object, _ := fieldType.(*graphql.Object)
+
+	depth, cost := m.selectionSet(object, field.SelectionSet)
+	if isList {
+		cost *= listSize(parent, field)
+	}
+	return depth + 1, cost + 1
+}
+
+// listSize is the limit argument when it is given as a literal, variables aren't known until execution
+// without one the session and meeting lists are as long as what they are filtered to, so a query that
+// nests per-session fields under every session openf1 has is costed as loading all of them
+func listSize(parent *graphql.Object, field *ast.Field) int {
+	if n, ok := intArgument(field, "limit"); ok {
+		return n
+	}
+	switch parent.Name() + "." + field.Name.Value {
+	case "Query.sessions":
+		switch {
+		case hasArgument(field, "meeting_key"):
+			return sessionsPerMeeting
+		case hasArgument(field, "year"):
+			return sessionsPerSeason
+		}
+		return allSessions
+	case "Query.meetings":
+		return meetingsPerSeason
+	case "Meeting.sessions":
+		return sessionsPerMeeting
+	}
+	return listFactor
+}
+
+// intArgument is the value of a non-negative int argument given as a literal
+func intArgument(field *ast.Field, name string) (int, bool) {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != name {
+			continue
+		}
+		if value, ok := argument.Value.(*ast.IntValue); ok {
+			if n, err := strconv.Atoi(value.Value); err == nil && n >= 0 {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// hasArgument reports whether an argument is given at all, a variable counts as it filters the list all the same
+func hasArgument(field *ast.Field, name string) bool {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package gql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+func TestCheckLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		operation string
+		err       string
+	}{
+		{"flat list", `{ sessions { session_key } }`, "", ""},
+		{"one session in depth", `{ session(session_key: 9161) { drivers { laps { lap_duration } stints { compound } } } }`, "", ""},
+		{"a season's laps", `{ sessions(year: 2024) { drivers { laps { lap_number } } } }`, "", ""},
+		{"a meeting's laps and stints", `{ sessions(meeting_key: 1229) { drivers { laps { lap_number } stints { compound } } } }`, "", ""},
+		{"a season by meeting", `{ meetings(year: 2024) { sessions { drivers { laps { lap_number } } } } }`, "", ""},
+		{"limited", `{ sessions(limit: 5) { drivers { laps { lap_number } stints { compound } } } }`, "", ""},
+		{"year as a variable", `query ($year: Int) { sessions(year: $year) { drivers { laps { lap_number } } } }`, "", ""},
+		{"every session's laps", `{ sessions { drivers { laps { lap_number } } } }`, "", "complexity"},
+		{"filtered by type only", `{ sessions(session_type: "Race") { drivers { laps { lap_number } } } }`, "", "complexity"},
+		{"a season's laps and stints", `{ sessions(year: 2024) { drivers { laps { lap_number } stints { compound } } } }`, "", "complexity"},
+		{"a large limit", `{ sessions(limit: 1000) { drivers { laps { lap_number } } } }`, "", "complexity"},
+		{"every session's laps in a fragment", `
+			{ sessions { ...fanOut } }
+			fragment fanOut on Session { drivers { laps { lap_number } } }`, "", "complexity"},
+		{"too deep", `{ session(session_key: 9161) { meeting { sessions { meeting { sessions { drivers { full_name } } } } } } }`, "", "depth"},
+		{"too deep in an inline fragment", `{ session(session_key: 9161) { ... on Session { meeting { sessions { meeting { sessions { drivers { full_name } } } } } } } }`, "", "depth"},
+		{"introspection", `{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`, "", ""},
+		{"only the named operation", `
+			query small { sessions { session_key } }
+			query large { sessions { drivers { laps { lap_number } } } }`, "small", ""},
+		{"the named operation over", `
+			query small { sessions { session_key } }
+			query large { sessions { drivers { laps { lap_number } } } }`, "large", "complexity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parser.Parse(parser.ParseParams{Source: tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = CheckLimits(Schema, doc, tt.operation)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("err = %v, want nil", err)
+			case tt.err != "" && err == nil:
+				t.Errorf("err = nil, want a %s error", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("err = %v, want a %s error", err, tt.err)
+			}
+		})
+	}
+}
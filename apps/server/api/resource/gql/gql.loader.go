@@ -0,0 +1,139 @@
+package gql
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// loaderConcurrency caps the upstream requests a single batch makes at once
+const loaderConcurrency = 4
+
+type result[V any] struct {
+	value V
+	err   error
+}
+
+// loader batches and caches lookups for the life of one request, dataloader style
+// resolvers call Load for every key they need and get a thunk back, graphql-go resolves thunks
+// breadth first so every key of a level is queued before the first thunk runs the batch
+// openf1 can't filter on several keys in one request, so a batch fetches its distinct keys concurrently
+type loader[K comparable, V any] struct {
+	fetch func(key K) (V, error)
+
+	mu      sync.Mutex
+	pending []K
+	queued  map[K]bool
+	results map[K]result[V]
+}
+
+func newLoader[K comparable, V any](fetch func(key K) (V, error)) *loader[K, V] {
+	return &loader[K, V]{
+		fetch:   fetch,
+		queued:  map[K]bool{},
+		results: map[K]result[V]{},
+	}
+}
+
+// Load queues key for the next batch and returns a thunk that waits for its result
+func (l *loader[K, V]) Load(key K) func() (V, error) {
+	l.mu.Lock()
+	if _, done := l.results[key]; !done && !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (V, error) {
+		l.mu.Lock()
+		r, done := l.results[key]
+		l.mu.Unlock()
+		if !done {
+			l.dispatch()
+			l.mu.Lock()
+			r = l.results[key]
+			l.mu.Unlock()
+		}
+		return r.value, r.err
+	}
+}
+
+// dispatch fetches every pending key
+func (l *loader[K, V]) dispatch() {
+	l.mu.Lock()
+	keys := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	results := make([]result[V], len(keys))
+	sem := make(chan struct{}, loaderConcurrency)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			value, err := l.fetch(key)
+			results[i] = result[V]{value: value, err: err}
+		}()
+	}
+	wg.Wait()
+
+	l.mu.Lock()
+	for i, key := range keys {
+		l.results[key] = results[i]
+		delete(l.queued, key)
+	}
+	l.mu.Unlock()
+}
+
+// Loaders holds the loaders of one request
+type Loaders struct {
+	sessions *loader[int, []openf1.Session]
+	meetings *loader[int, []openf1.Meeting]
+	drivers  *loader[int, []openf1.Driver]
+	laps     *loader[int, []openf1.Lap]
+	stints   *loader[int, []openf1.Stint]
+}
+
+func NewLoaders(ctx context.Context, source *store.Source) *Loaders {
+	return &Loaders{
+		// the session list has a single key, the loader is only there so it is fetched once per request
+		sessions: newLoader(func(int) ([]openf1.Session, error) {
+			return source.Sessions(ctx)
+		}),
+		meetings: newLoader(func(year int) ([]openf1.Meeting, error) {
+			return noResultsAsEmpty(source.Meetings(ctx, year))
+		}),
+		drivers: newLoader(func(sessionKey int) ([]openf1.Driver, error) {
+			return noResultsAsEmpty(source.Drivers(ctx, sessionKey))
+		}),
+		laps: newLoader(func(sessionKey int) ([]openf1.Lap, error) {
+			return noResultsAsEmpty(source.Laps(ctx, sessionKey))
+		}),
+		stints: newLoader(func(sessionKey int) ([]openf1.Stint, error) {
+			return noResultsAsEmpty(source.Stints(ctx, sessionKey))
+		}),
+	}
+}
+
+func noResultsAsEmpty[T any](rows []T, err error) ([]T, error) {
+	if errors.Is(err, openf1.ErrNoResults) {
+		return []T{}, nil
+	}
+	return rows, err
+}
+
+type loadersKey struct{}
+
+func WithLoaders(ctx context.Context, loaders *Loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, loaders)
+}
+
+func loadersFrom(ctx context.Context) *Loaders {
+	return ctx.Value(loadersKey{}).(*Loaders)
+}
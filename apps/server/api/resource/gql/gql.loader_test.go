@@ -0,0 +1,110 @@
+package gql
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// countingFetch records the keys it is called with and returns the key doubled, or an error for negative keys
+type countingFetch struct {
+	mu    sync.Mutex
+	calls []int
+}
+
+func (c *countingFetch) fetch(key int) (int, error) {
+	c.mu.Lock()
+	c.calls = append(c.calls, key)
+	c.mu.Unlock()
+	if key < 0 {
+		return 0, errors.New("no such key")
+	}
+	return key * 2, nil
+}
+
+func (c *countingFetch) keys() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := append([]int(nil), c.calls...)
+	sort.Ints(keys)
+	return keys
+}
+
+func TestLoaderBatches(t *testing.T) {
+	c := &countingFetch{}
+	l := newLoader(c.fetch)
+	thunks := []func() (int, error){l.Load(1), l.Load(2), l.Load(3)}
+	if len(c.keys()) != 0 {
+		t.Fatal("Load fetched before a thunk was run")
+	}
+
+	// the first thunk runs the batch, the others find their results waiting
+	for i, thunk := range thunks {
+		value, err := thunk()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (i + 1) * 2; value != want {
+			t.Errorf("key %d = %d, want %d", i+1, value, want)
+		}
+		if got := c.keys(); !equalInts(got, []int{1, 2, 3}) {
+			t.Fatalf("fetched %v after thunk %d, want [1 2 3]", got, i)
+		}
+	}
+}
+
+func TestLoaderDedups(t *testing.T) {
+	c := &countingFetch{}
+	l := newLoader(c.fetch)
+	first, second := l.Load(9161), l.Load(9161)
+	first()
+	second()
+	// a key that has been fetched is answered from the results without another batch
+	l.Load(9161)()
+	if got := c.keys(); !equalInts(got, []int{9161}) {
+		t.Errorf("fetched %v, want 9161 once", got)
+	}
+}
+
+func TestLoaderLaterBatch(t *testing.T) {
+	c := &countingFetch{}
+	l := newLoader(c.fetch)
+	l.Load(1)()
+	value, err := l.Load(2)()
+	if err != nil || value != 4 {
+		t.Fatalf("got %d, %v, want 4", value, err)
+	}
+	if got := c.keys(); !equalInts(got, []int{1, 2}) {
+		t.Errorf("fetched %v, want [1 2]", got)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	c := &countingFetch{}
+	l := newLoader(c.fetch)
+	failed, ok := l.Load(-1), l.Load(1)
+	if _, err := failed(); err == nil {
+		t.Error("err = nil for a failed key")
+	}
+	if value, err := ok(); err != nil || value != 2 {
+		t.Errorf("got %d, %v for a key batched with a failed one, want 2", value, err)
+	}
+	// errors are kept for the request like results are
+	l.Load(-1)()
+	if got := c.keys(); !equalInts(got, []int{-1, 1}) {
+		t.Errorf("fetched %v, want [-1 1]", got)
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,346 @@
+// package for the GraphQL view over sessions, meetings, drivers, laps and stints
+package gql
+
+import (
+	"sort"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"telem-api-server/openf1"
+)
+
+// field names follow the json names of the REST responses so both read the same
+// scalar fields use graphql-go's default resolver, which matches them to the models' json tags
+
+// dateField serves a time, openf1 leaves some dates null which arrive here as the zero time
+func dateField(get func(source any) time.Time) *graphql.Field {
+	return &graphql.Field{
+		Type: graphql.DateTime,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			t := get(p.Source)
+			if t.IsZero() {
+				return nil, nil
+			}
+			return t, nil
+		},
+	}
+}
+
+// thunk adapts a loader thunk to the signature graphql-go resolves lazily
+func thunk[V any](load func() (V, error), fn func(V) (interface{}, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+		return fn(value)
+	}
+}
+
+func optionalInt(args map[string]interface{}, name string) (int, bool) {
+	value, ok := args[name].(int)
+	return value, ok
+}
+
+var lapType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Lap",
+	Fields: graphql.Fields{
+		"meeting_key":       &graphql.Field{Type: graphql.Int},
+		"session_key":       &graphql.Field{Type: graphql.Int},
+		"driver_number":     &graphql.Field{Type: graphql.Int},
+		"lap_number":        &graphql.Field{Type: graphql.Int},
+		"date_start":        dateField(func(s any) time.Time { return s.(openf1.Lap).DateStart }),
+		"duration_sector_1": &graphql.Field{Type: graphql.Float},
+		"duration_sector_2": &graphql.Field{Type: graphql.Float},
+		"duration_sector_3": &graphql.Field{Type: graphql.Float},
+		"i1_speed":          &graphql.Field{Type: graphql.Int},
+		"i2_speed":          &graphql.Field{Type: graphql.Int},
+		"st_speed":          &graphql.Field{Type: graphql.Int},
+		"is_pit_out_lap":    &graphql.Field{Type: graphql.Boolean},
+		"lap_duration":      &graphql.Field{Type: graphql.Float},
+		"segments_sector_1": &graphql.Field{Type: graphql.NewList(graphql.Int)},
+		"segments_sector_2": &graphql.Field{Type: graphql.NewList(graphql.Int)},
+		"segments_sector_3": &graphql.Field{Type: graphql.NewList(graphql.Int)},
+	},
+})
+
+var stintType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Stint",
+	Fields: graphql.Fields{
+		"meeting_key":       &graphql.Field{Type: graphql.Int},
+		"session_key":       &graphql.Field{Type: graphql.Int},
+		"driver_number":     &graphql.Field{Type: graphql.Int},
+		"stint_number":      &graphql.Field{Type: graphql.Int},
+		"compound":          &graphql.Field{Type: graphql.String},
+		"lap_start":         &graphql.Field{Type: graphql.Int},
+		"lap_end":           &graphql.Field{Type: graphql.Int},
+		"tyre_age_at_start": &graphql.Field{Type: graphql.Int},
+	},
+})
+
+var driverType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Driver",
+	Fields: graphql.Fields{
+		"meeting_key":    &graphql.Field{Type: graphql.Int},
+		"session_key":    &graphql.Field{Type: graphql.Int},
+		"driver_number":  &graphql.Field{Type: graphql.Int},
+		"broadcast_name": &graphql.Field{Type: graphql.String},
+		"country_code":   &graphql.Field{Type: graphql.String},
+		"first_name":     &graphql.Field{Type: graphql.String},
+		"last_name":      &graphql.Field{Type: graphql.String},
+		"full_name":      &graphql.Field{Type: graphql.String},
+		"name_acronym":   &graphql.Field{Type: graphql.String},
+		"headshot_url":   &graphql.Field{Type: graphql.String},
+		"team_colour":    &graphql.Field{Type: graphql.String},
+		"team_name":      &graphql.Field{Type: graphql.String},
+		"laps": &graphql.Field{
+			Type: graphql.NewList(lapType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				d := p.Source.(openf1.Driver)
+				return thunk(loadersFrom(p.Context).laps.Load(d.SessionKey), func(laps []openf1.Lap) (interface{}, error) {
+					return filterLaps(laps, d.DriverNumber), nil
+				}), nil
+			},
+		},
+		"stints": &graphql.Field{
+			Type: graphql.NewList(stintType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				d := p.Source.(openf1.Driver)
+				return thunk(loadersFrom(p.Context).stints.Load(d.SessionKey), func(stints []openf1.Stint) (interface{}, error) {
+					return filterStints(stints, d.DriverNumber), nil
+				}), nil
+			},
+		},
+	},
+})
+
+var queryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.FieldsThunk(func() graphql.Fields {
+		return graphql.Fields{
+			"sessions": &graphql.Field{
+				Type: graphql.NewList(sessionType),
+				Args: graphql.FieldConfigArgument{
+					"year":         &graphql.ArgumentConfig{Type: graphql.Int},
+					"meeting_key":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"session_type": &graphql.ArgumentConfig{Type: graphql.String},
+					"session_name": &graphql.ArgumentConfig{Type: graphql.String},
+					"skip":         &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"limit":        &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					filter := sessionFilter{}
+					filter.year, _ = optionalInt(p.Args, "year")
+					filter.meetingKey, _ = optionalInt(p.Args, "meeting_key")
+					filter.sessionType, _ = p.Args["session_type"].(string)
+					filter.sessionName, _ = p.Args["session_name"].(string)
+					skip, _ := optionalInt(p.Args, "skip")
+					limit, hasLimit := optionalInt(p.Args, "limit")
+					return thunk(loadersFrom(p.Context).sessions.Load(0), func(sessions []openf1.Session) (interface{}, error) {
+						selected := filterSessions(sessions, filter)
+						start := min(max(skip, 0), len(selected))
+						end := len(selected)
+						if hasLimit {
+							end = min(start+max(limit, 0), end)
+						}
+						return selected[start:end], nil
+					}), nil
+				},
+			},
+			"session": &graphql.Field{
+				Type: sessionType,
+				Args: graphql.FieldConfigArgument{
+					"session_key": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					sessionKey, _ := optionalInt(p.Args, "session_key")
+					return thunk(loadersFrom(p.Context).sessions.Load(0), func(sessions []openf1.Session) (interface{}, error) {
+						for _, s := range sessions {
+							if s.SessionKey == sessionKey {
+								return s, nil
+							}
+						}
+						return nil, nil
+					}), nil
+				},
+			},
+			"meetings": &graphql.Field{
+				Type: graphql.NewList(meetingType),
+				Args: graphql.FieldConfigArgument{
+					"year": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					year, _ := optionalInt(p.Args, "year")
+					return thunk(loadersFrom(p.Context).meetings.Load(year), func(meetings []openf1.Meeting) (interface{}, error) {
+						return meetings, nil
+					}), nil
+				},
+			},
+		}
+	}),
+})
+
+// sessions and meetings refer to each other so their fields are built lazily
+var sessionType, meetingType *graphql.Object
+
+var Schema graphql.Schema
+
+func init() {
+	meetingType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Meeting",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"meeting_key":           &graphql.Field{Type: graphql.Int},
+				"meeting_name":          &graphql.Field{Type: graphql.String},
+				"meeting_official_name": &graphql.Field{Type: graphql.String},
+				"circuit_key":           &graphql.Field{Type: graphql.Int},
+				"circuit_short_name":    &graphql.Field{Type: graphql.String},
+				"country_code":          &graphql.Field{Type: graphql.String},
+				"country_key":           &graphql.Field{Type: graphql.Int},
+				"country_name":          &graphql.Field{Type: graphql.String},
+				"location":              &graphql.Field{Type: graphql.String},
+				"gmt_offset":            &graphql.Field{Type: graphql.String},
+				"date_start":            &graphql.Field{Type: graphql.String},
+				"year":                  &graphql.Field{Type: graphql.Int},
+				"sessions": &graphql.Field{
+					Type: graphql.NewList(sessionType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						m := p.Source.(openf1.Meeting)
+						return thunk(loadersFrom(p.Context).sessions.Load(0), func(sessions []openf1.Session) (interface{}, error) {
+							return filterSessions(sessions, sessionFilter{meetingKey: m.MeetingKey}), nil
+						}), nil
+					},
+				},
+			}
+		}),
+	})
+
+	sessionType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Session",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"session_key":        &graphql.Field{Type: graphql.Int},
+				"session_name":       &graphql.Field{Type: graphql.String},
+				"session_type":       &graphql.Field{Type: graphql.String},
+				"meeting_key":        &graphql.Field{Type: graphql.Int},
+				"circuit_key":        &graphql.Field{Type: graphql.Int},
+				"circuit_short_name": &graphql.Field{Type: graphql.String},
+				"country_code":       &graphql.Field{Type: graphql.String},
+				"country_key":        &graphql.Field{Type: graphql.Int},
+				"country_name":       &graphql.Field{Type: graphql.String},
+				"location":           &graphql.Field{Type: graphql.String},
+				"gmt_offset":         &graphql.Field{Type: graphql.String},
+				"year":               &graphql.Field{Type: graphql.Int},
+				"date_start":         dateField(func(s any) time.Time { return s.(openf1.Session).DateStart }),
+				"date_end":           dateField(func(s any) time.Time { return s.(openf1.Session).DateEnd }),
+				"meeting": &graphql.Field{
+					Type: meetingType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						s := p.Source.(openf1.Session)
+						return thunk(loadersFrom(p.Context).meetings.Load(s.Year), func(meetings []openf1.Meeting) (interface{}, error) {
+							for _, m := range meetings {
+								if m.MeetingKey == s.MeetingKey {
+									return m, nil
+								}
+							}
+							return nil, nil
+						}), nil
+					},
+				},
+				"drivers": &graphql.Field{
+					Type: graphql.NewList(driverType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						s := p.Source.(openf1.Session)
+						return thunk(loadersFrom(p.Context).drivers.Load(s.SessionKey), func(drivers []openf1.Driver) (interface{}, error) {
+							return drivers, nil
+						}), nil
+					},
+				},
+				"laps": &graphql.Field{
+					Type: graphql.NewList(lapType),
+					Args: graphql.FieldConfigArgument{
+						"driver_number": &graphql.ArgumentConfig{Type: graphql.Int},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						s := p.Source.(openf1.Session)
+						driverNumber, _ := optionalInt(p.Args, "driver_number")
+						return thunk(loadersFrom(p.Context).laps.Load(s.SessionKey), func(laps []openf1.Lap) (interface{}, error) {
+							return filterLaps(laps, driverNumber), nil
+						}), nil
+					},
+				},
+				"stints": &graphql.Field{
+					Type: graphql.NewList(stintType),
+					Args: graphql.FieldConfigArgument{
+						"driver_number": &graphql.ArgumentConfig{Type: graphql.Int},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						s := p.Source.(openf1.Session)
+						driverNumber, _ := optionalInt(p.Args, "driver_number")
+						return thunk(loadersFrom(p.Context).stints.Load(s.SessionKey), func(stints []openf1.Stint) (interface{}, error) {
+							return filterStints(stints, driverNumber), nil
+						}), nil
+					},
+				},
+			}
+		}),
+	})
+
+	var err error
+	Schema, err = graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+	if err != nil {
+		panic(err)
+	}
+}
+
+type sessionFilter struct {
+	year        int
+	meetingKey  int
+	sessionType string
+	sessionName string
+}
+
+// filterSessions returns the matching sessions in date order, zero values match everything
+func filterSessions(sessions []openf1.Session, filter sessionFilter) []openf1.Session {
+	selected := []openf1.Session{}
+	for _, s := range sessions {
+		if filter.year != 0 && s.Year != filter.year {
+			continue
+		}
+		if filter.meetingKey != 0 && s.MeetingKey != filter.meetingKey {
+			continue
+		}
+		if filter.sessionType != "" && s.SessionType != filter.sessionType {
+			continue
+		}
+		if filter.sessionName != "" && s.SessionName != filter.sessionName {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].DateStart.Before(selected[j].DateStart)
+	})
+	return selected
+}
+
+func filterLaps(laps []openf1.Lap, driverNumber int) []openf1.Lap {
+	selected := []openf1.Lap{}
+	for _, l := range laps {
+		if driverNumber == 0 || l.DriverNumber == driverNumber {
+			selected = append(selected, l)
+		}
+	}
+	return selected
+}
+
+func filterStints(stints []openf1.Stint, driverNumber int) []openf1.Stint {
+	selected := []openf1.Stint{}
+	for _, s := range stints {
+		if driverNumber == 0 || s.DriverNumber == driverNumber {
+			selected = append(selected, s)
+		}
+	}
+	return selected
+}
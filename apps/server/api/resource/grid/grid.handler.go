@@ -0,0 +1,134 @@
+package grid
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/api/response"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// Grid is the starting grid for a race or sprint session
+// SessionKey is the qualifying/sprint shootout session the grid was derived from
+type Grid struct {
+	RaceSessionKey int            `json:"race_session_key"`
+	SessionKey     int            `json:"session_key"`
+	SessionName    string         `json:"session_name"`
+	MeetingKey     int            `json:"meeting_key"`
+	Positions      []GridPosition `json:"positions"`
+}
+
+type GridPosition struct {
+	Position     int      `json:"position"`
+	DriverNumber int      `json:"driver_number"`
+	LapDuration  *float64 `json:"lap_duration"`
+}
+
+// qualifying session names that set the grid for each type of race session
+var gridSourceNames = map[string][]string{
+	"Race":   {"Qualifying"},
+	"Sprint": {"Sprint Qualifying", "Sprint Shootout"},
+}
+
+// Helper Functions
+func FindGridSourceSession(sessions []session.Session, race session.Session) (*session.Session, error) {
+	if race.SessionType != "Race" {
+		return nil, fmt.Errorf("session %d is not a race or sprint", race.SessionKey)
+	}
+	names, ok := gridSourceNames[race.SessionName]
+	if !ok {
+		return nil, fmt.Errorf("no grid source for session %s", race.SessionName)
+	}
+	for _, s := range sessions {
+		if s.MeetingKey != race.MeetingKey || s.SessionType != "Qualifying" {
+			continue
+		}
+		for _, name := range names {
+			if s.SessionName == name {
+				return &s, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Handler serves starting grids
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Logger *slog.Logger
+}
+
+// Grid Handlers
+func (h *Handler) GridHandler(w http.ResponseWriter, r *http.Request) {
+	// extract the sessionKey of the race from the URL path
+	id, err := strconv.Atoi(r.PathValue("key"))
+	if err != nil {
+		http.Error(w, "Invalid session Key Id", http.StatusBadRequest)
+		return
+	}
+	h.handleGetGrid(w, r, id)
+}
+
+// business logic of the handler methods
+func (h *Handler) handleGetGrid(w http.ResponseWriter, r *http.Request, id int) {
+	sessions, err := h.Source.Sessions(r.Context())
+	if err != nil {
+		h.Logger.Error("fetching sessions", "error", err)
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	race := session.FindSessionById(sessions, id)
+	if race == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// the grid is set in the qualifying session of the same meeting
+	source, err := FindGridSourceSession(sessions, *race)
+	if err != nil {
+		http.Error(w, "Starting grid is only available for race and sprint sessions", http.StatusBadRequest)
+		return
+	}
+	if source == nil {
+		http.Error(w, "Qualifying session not found for this meeting", http.StatusNotFound)
+		return
+	}
+
+	startingGrid, err := h.Source.StartingGrid(r.Context(), source.SessionKey)
+	if errors.Is(err, openf1.ErrNoResults) {
+		http.Error(w, "Starting grid not available yet", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error fetching starting grid", http.StatusInternalServerError)
+		return
+	}
+
+	grid := Grid{
+		RaceSessionKey: race.SessionKey,
+		SessionKey:     source.SessionKey,
+		SessionName:    source.SessionName,
+		MeetingKey:     race.MeetingKey,
+		Positions:      []GridPosition{},
+	}
+	for _, g := range startingGrid {
+		grid.Positions = append(grid.Positions, GridPosition{
+			Position:     g.Position,
+			DriverNumber: g.DriverNumber,
+			LapDuration:  g.LapDuration,
+		})
+	}
+	sort.Slice(grid.Positions, func(i, j int) bool {
+		return grid.Positions[i].Position < grid.Positions[j].Position
+	})
+
+	response.Write(w, r, grid)
+}
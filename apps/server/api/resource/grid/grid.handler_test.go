@@ -0,0 +1,136 @@
+package grid_test
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"telem-api-server/api/resource/grid"
+	"telem-api-server/api/resource/session"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+var sessions = []session.Session{
+	// Singapore, a race weekend
+	{SessionKey: 9155, MeetingKey: 1219, SessionName: "Practice 3", SessionType: "Practice"},
+	{SessionKey: 9157, MeetingKey: 1219, SessionName: "Qualifying", SessionType: "Qualifying"},
+	{SessionKey: 9158, MeetingKey: 1219, SessionName: "Race", SessionType: "Race"},
+	// Qatar in 2023 had a sprint shootout
+	{SessionKey: 9161, MeetingKey: 1221, SessionName: "Qualifying", SessionType: "Qualifying"},
+	{SessionKey: 9164, MeetingKey: 1221, SessionName: "Sprint Shootout", SessionType: "Qualifying"},
+	{SessionKey: 9165, MeetingKey: 1221, SessionName: "Sprint", SessionType: "Race"},
+	{SessionKey: 9166, MeetingKey: 1221, SessionName: "Race", SessionType: "Race"},
+	// China in 2024 called it sprint qualifying
+	{SessionKey: 9668, MeetingKey: 1230, SessionName: "Sprint Qualifying", SessionType: "Qualifying"},
+	{SessionKey: 9672, MeetingKey: 1230, SessionName: "Sprint", SessionType: "Race"},
+	// a meeting whose qualifying isn't listed yet
+	{SessionKey: 9999, MeetingKey: 1240, SessionName: "Race", SessionType: "Race"},
+}
+
+func TestFindGridSourceSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		race    int
+		want    int
+		wantErr bool
+	}{
+		{name: "race from qualifying", race: 9158, want: 9157},
+		{name: "race on a sprint weekend", race: 9166, want: 9161},
+		{name: "sprint from sprint shootout", race: 9165, want: 9164},
+		{name: "sprint from sprint qualifying", race: 9672, want: 9668},
+		{name: "no qualifying in the meeting", race: 9999},
+		{name: "not a race", race: 9157, wantErr: true},
+		{name: "practice", race: 9155, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := grid.FindGridSourceSession(sessions, *session.FindSessionById(sessions, tt.race))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			got := 0
+			if source != nil {
+				got = source.SessionKey
+			}
+			if got != tt.want {
+				t.Errorf("source session = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func newGridServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sessions":
+			json.NewEncoder(w).Encode(sessions)
+		case "/starting_grid":
+			if r.URL.Query().Get("session_key") != "9157" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode([]openf1.StartingGrid{
+				{SessionKey: 9157, MeetingKey: 1219, DriverNumber: 55, Position: 1},
+				{SessionKey: 9157, MeetingKey: 1219, DriverNumber: 63, Position: 2},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	client := openf1.NewClient(upstream.URL)
+	client.Retry.MaxAttempts = 1
+	handler := &grid.Handler{Config: config.Default(), Source: store.NewSource(client, nil, time.Minute), Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions/{key}/grid", handler.GridHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGridHandler(t *testing.T) {
+	srv := newGridServer(t)
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/sessions/9158/grid", http.StatusOK},
+		// the meeting has no qualifying session to take the grid from
+		{"/sessions/9999/grid", http.StatusNotFound},
+		// openf1 hasn't published the grid yet
+		{"/sessions/9165/grid", http.StatusNotFound},
+		{"/sessions/9157/grid", http.StatusBadRequest},
+		{"/sessions/1/grid", http.StatusNotFound},
+		{"/sessions/abc/grid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		response, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tt.status {
+			t.Errorf("%s status = %d (%s), want %d", tt.path, response.StatusCode, body, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var g grid.Grid
+		if err := json.Unmarshal(body, &g); err != nil {
+			t.Fatal(err)
+		}
+		if g.RaceSessionKey != 9158 || g.SessionKey != 9157 || g.SessionName != "Qualifying" || len(g.Positions) != 2 || g.Positions[0].DriverNumber != 55 {
+			t.Errorf("grid = %+v, want Singapore's from qualifying", g)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// package for the probes the orchestrator and on-call use to tell our outages apart from openf1's
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// how long the store gets to answer a readiness check before it counts as unreachable
+const pingTimeout = 2 * time.Second
+
+// how long to wait between attempts to warm the cache while openf1 can't be reached
+const warmRetry = 10 * time.Second
+
+// Check is the result of one readiness check
+type Check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Readiness is the response of /readyz, Ready is only set when every check passes
+type Readiness struct {
+	Ready  bool             `json:"ready"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Warmer fetches the session list through Source once at startup, every resource below a session starts from it
+type Warmer struct {
+	Source *store.Source
+	warmed atomic.Bool
+}
+
+// Warm fetches the session list until it succeeds or ctx is done, /readyz fails until it has
+func (w *Warmer) Warm(ctx context.Context) {
+	ctx = openf1.WithPriority(ctx, openf1.PriorityBackground)
+	for {
+		_, err := w.Source.Sessions(ctx)
+		if err == nil {
+			w.warmed.Store(true)
+			log.Print("session cache warmed")
+			return
+		}
+		log.Printf("Error warming session cache, retrying in %s: %v", warmRetry, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(warmRetry):
+		}
+	}
+}
+
+// LiveHandler answers as long as the process can serve requests at all
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Warmed is whether Warm has fetched the session list yet
+func (w *Warmer) Warmed() bool {
+	return w.warmed.Load()
+}
+
+// Handler answers readiness with the configuration the server was started with and the store it reads through
+// Upstream is the server's openf1 client, whose calls and breaker the upstream status reports
+type Handler struct {
+	Config   *config.Config
+	Source   *store.Source
+	Upstream *openf1.Client
+	Warmer   *Warmer
+}
+
+// ReadyHandler reports whether we can serve traffic, 503 when any check fails so we're taken out of rotation
+func (h *Handler) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := Readiness{
+		Checks: map[string]Check{
+			"config": h.checkConfig(),
+			"store":  h.checkStore(r.Context()),
+			"cache":  h.checkCache(),
+		},
+	}
+	readiness.Ready = true
+	for _, check := range readiness.Checks {
+		readiness.Ready = readiness.Ready && check.OK
+	}
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness)
+}
+
+// UpstreamHandler reports how openf1 has been answering, it is always a 200 as openf1 being down isn't our outage
+func (h *Handler) UpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Upstream.Status())
+}
+
+// checkConfig is whether the configuration the server was given still passes validation
+func (h *Handler) checkConfig() Check {
+	if h.Config == nil {
+		return Check{Detail: "no configuration loaded"}
+	}
+	if err := h.Config.Validate(); err != nil {
+		return Check{Detail: err.Error()}
+	}
+	return Check{OK: true}
+}
+
+// checkStore pings the store, running without one is fine as every request goes to openf1
+func (h *Handler) checkStore(ctx context.Context) Check {
+	if h.Source == nil || h.Source.Store == nil {
+		return Check{OK: true, Detail: "no store configured"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := h.Source.Store.Ping(ctx); err != nil {
+		return Check{Detail: err.Error()}
+	}
+	return Check{OK: true}
+}
+
+func (h *Handler) checkCache() Check {
+	if h.Warmer == nil || !h.Warmer.Warmed() {
+		return Check{Detail: "session list not fetched yet"}
+	}
+	return Check{OK: true}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	// probes should always see the current state
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding health response: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"telem-api-server/api/resource/health"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// pingStore is a store that only answers pings, with err
+type pingStore struct {
+	store.Store
+	err error
+}
+
+func (s *pingStore) Ping(ctx context.Context) error {
+	return s.err
+}
+
+// newUpstream is a fake openf1 with one session, and nothing but errors for everything else
+func newUpstream(t *testing.T) *openf1.Client {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sessions" {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode([]openf1.Session{{SessionKey: 9158}})
+	}))
+	t.Cleanup(upstream.Close)
+	client := openf1.NewClient(upstream.URL)
+	client.Retry.MaxAttempts = 1
+	return client
+}
+
+func TestReady(t *testing.T) {
+	client := newUpstream(t)
+	tests := []struct {
+		name   string
+		store  store.Store
+		warm   bool
+		status int
+		failed string
+	}{
+		{name: "ready", store: &pingStore{}, warm: true, status: http.StatusOK},
+		// every request goes to openf1 without a store
+		{name: "no store", warm: true, status: http.StatusOK},
+		{name: "store down", store: &pingStore{err: errors.New("database is locked")}, warm: true, status: http.StatusServiceUnavailable, failed: "store"},
+		{name: "cache not warmed", store: &pingStore{}, status: http.StatusServiceUnavailable, failed: "cache"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &store.Source{Client: client, Clock: store.SystemClock{}}
+			if tt.store != nil {
+				source.Store = tt.store
+			}
+			warmer := &health.Warmer{Source: source}
+			if tt.warm {
+				warmer.Warm(context.Background())
+			}
+			h := &health.Handler{Config: config.Default(), Source: source, Upstream: client, Warmer: warmer}
+
+			w := httptest.NewRecorder()
+			h.ReadyHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			var readiness health.Readiness
+			if err := json.NewDecoder(w.Body).Decode(&readiness); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || readiness.Ready != (tt.status == http.StatusOK) {
+				t.Errorf("status = %d, ready = %v, want %d", w.Code, readiness.Ready, tt.status)
+			}
+			for name, check := range readiness.Checks {
+				if check.OK == (name == tt.failed) {
+					t.Errorf("%s check = %+v", name, check)
+				}
+			}
+			if tt.failed != "" && readiness.Checks[tt.failed].Detail == "" {
+				t.Errorf("%s check failed without saying why", tt.failed)
+			}
+		})
+	}
+}
+
+func TestUpstreamStatus(t *testing.T) {
+	client := newUpstream(t)
+	var laps []openf1.Lap
+	client.Get(context.Background(), "laps", nil, &laps)
+	var sessions []openf1.Session
+	client.Get(context.Background(), "sessions", nil, &sessions)
+	h := &health.Handler{Config: config.Default(), Upstream: client}
+
+	w := httptest.NewRecorder()
+	h.UpstreamHandler(w, httptest.NewRequest(http.MethodGet, "/status/upstream", nil))
+	var status openf1.UpstreamStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	// openf1 failing is reported, not our outage
+	if w.Code != http.StatusOK {
+		t.Errorf("status code = %d, want 200", w.Code)
+	}
+	if status.Calls != 2 || status.Errors != 1 || status.LastError == nil || status.LastSuccess == nil || status.Breaker != openf1.BreakerClosed {
+		t.Errorf("upstream status = %+v, want a failed and a successful call with the breaker closed", status)
+	}
+	if time.Since(*status.LastError) > time.Minute {
+		t.Errorf("last error at %s", status.LastError)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", w.Header().Get("Cache-Control"))
+	}
+}
@@ -0,0 +1,75 @@
+// package for the lap by lap timing of a session
+package lap
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/api/response"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// Helper Functions
+func FilterByDriver(laps []openf1.Lap, driverNumber int) []openf1.Lap {
+	filtered := []openf1.Lap{}
+	for _, l := range laps {
+		if driverNumber == 0 || l.DriverNumber == driverNumber {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered
+}
+
+// Handler serves the laps of a session
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Logger *slog.Logger
+}
+
+// Lap Handlers
+func (h *Handler) LapsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("key"))
+	if err != nil {
+		http.Error(w, "Invalid session Key Id", http.StatusBadRequest)
+		return
+	}
+	h.handleGetLaps(w, r, id)
+}
+
+// business logic of the handler methods
+func (h *Handler) handleGetLaps(w http.ResponseWriter, r *http.Request, id int) {
+	// driver_number is optional, leaving it out returns every driver's laps
+	driverNumber := 0
+	if param := r.URL.Query().Get("driver_number"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid driver_number", http.StatusBadRequest)
+			return
+		}
+		driverNumber = n
+	}
+
+	sessions, err := h.Source.Sessions(r.Context())
+	if err != nil {
+		h.Logger.Error("fetching sessions", "error", err)
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	if session.FindSessionById(sessions, id) == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	laps, err := h.Source.Laps(r.Context(), id)
+	if err != nil && !errors.Is(err, openf1.ErrNoResults) {
+		http.Error(w, "Error fetching laps", http.StatusInternalServerError)
+		return
+	}
+	response.Write(w, r, FilterByDriver(laps, driverNumber))
+}
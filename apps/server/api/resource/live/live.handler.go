@@ -0,0 +1,134 @@
+package live
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/config"
+	"telem-api-server/ingest"
+	"telem-api-server/store"
+)
+
+// how often a comment is sent to keep idle connections and proxies from timing out
+const heartbeatInterval = 15 * time.Second
+
+// Helper Functions
+// IsLive reports whether the session is running right now
+func IsLive(s session.Session, now time.Time) bool {
+	return !now.Before(s.DateStart) && !now.After(s.DateEnd)
+}
+
+// parseLastEventID reads the id a reconnecting client last saw, browsers send it as a header
+// the query parameter is for clients that can't set headers on an EventSource
+func parseLastEventID(r *http.Request) (int64, error) {
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	if lastID == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(lastID, 10, 64)
+}
+
+func writeEvent(w http.ResponseWriter, event ingest.Event) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
+
+// Handler streams live sessions, looked up in Source and polled by Feeds
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Feeds  *ingest.Manager
+	Logger *slog.Logger
+}
+
+// Live Handlers
+func (h *Handler) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	// extract the sessionKey from the URL path
+	id, err := strconv.Atoi(r.PathValue("key"))
+	if err != nil {
+		http.Error(w, "Invalid session Key Id", http.StatusBadRequest)
+		return
+	}
+	h.handleGetLive(w, r, id)
+}
+
+// business logic of the handler methods
+func (h *Handler) handleGetLive(w http.ResponseWriter, r *http.Request, id int) {
+	lastEventID, err := parseLastEventID(r)
+	if err != nil {
+		http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := h.Source.Sessions(r.Context())
+	if err != nil {
+		h.Logger.Error("fetching sessions", "error", err)
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	s := session.FindSessionById(sessions, id)
+	if s == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if !IsLive(*s, time.Now()) {
+		http.Error(w, "Session is not live", http.StatusConflict)
+		return
+	}
+
+	sub, backlog := h.Feeds.Subscribe(s.SessionKey, s.DateEnd, lastEventID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// stop nginx and friends from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	// the stream lasts as long as the session, well past the server's write timeout
+	rc.SetWriteDeadline(time.Time{})
+	// tell the browser how long to wait before reconnecting
+	fmt.Fprint(w, "retry: 2000\n\n")
+	for _, event := range backlog {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		h.Logger.Error("flushing live stream", "session_key", id, "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// the feed ended or dropped us, the client reconnects with its Last-Event-ID
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
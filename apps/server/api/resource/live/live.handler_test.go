@@ -0,0 +1,196 @@
+package live_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"telem-api-server/api/resource/live"
+	"telem-api-server/config"
+	"telem-api-server/ingest"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// newLiveServer serves the live route against a fake openf1 with one session running now and one that has finished
+// openf1 has a single car data row for the running session
+func newLiveServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	now := time.Now().UTC()
+	sessions := []openf1.Session{
+		{SessionKey: 9158, SessionName: "Race", SessionType: "Race", DateStart: now.Add(-time.Hour), DateEnd: now.Add(time.Hour)},
+		{SessionKey: 9157, SessionName: "Qualifying", SessionType: "Qualifying", DateStart: now.Add(-26 * time.Hour), DateEnd: now.Add(-25 * time.Hour)},
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sessions":
+			json.NewEncoder(w).Encode(sessions)
+		case "/car_data":
+			json.NewEncoder(w).Encode([]openf1.CarData{{SessionKey: 9158, DriverNumber: 1, Date: now.Add(-time.Second), Speed: 301}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	client := openf1.NewClient(upstream.URL)
+	feeds := ingest.NewManager(client, nil)
+	feeds.PollInterval = 10 * time.Millisecond
+	t.Cleanup(feeds.Stop)
+	handler := &live.Handler{Config: config.Default(), Source: store.NewSource(client, nil, time.Minute), Feeds: feeds, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions/{key}/live", handler.LiveHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// frame is a single server-sent event, or the retry field
+type frame map[string]string
+
+// readFrame waits for the next frame
+func readFrame(t *testing.T, r *bufio.Reader) frame {
+	t.Helper()
+	select {
+	case f, ok := <-frames(r):
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return f
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame")
+	}
+	return nil
+}
+
+// frames reads lines up to the blank line that ends a frame in the background, skipping comments such as heartbeats
+// the channel is closed if the stream ends first
+func frames(r *bufio.Reader) <-chan frame {
+	ch := make(chan frame, 1)
+	go func() {
+		defer close(ch)
+		f := frame{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" && len(f) > 0 {
+				ch <- f
+				return
+			}
+			if field, value, ok := strings.Cut(line, ": "); ok && !strings.HasPrefix(line, ":") {
+				f[field] = value
+			}
+		}
+	}()
+	return ch
+}
+
+func stream(t *testing.T, srv *httptest.Server, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, srv.URL+"/sessions/9158/live", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", response.StatusCode)
+	}
+	return response, bufio.NewReader(response.Body)
+}
+
+func TestLiveStream(t *testing.T) {
+	srv := newLiveServer(t)
+	response, r := stream(t, srv, "")
+	if got := response.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("content type = %s, want text/event-stream", got)
+	}
+	if retry := readFrame(t, r); retry["retry"] != "2000" {
+		t.Errorf("first frame = %v, want the retry interval", retry)
+	}
+
+	event := readFrame(t, r)
+	if event["id"] != "1" || event["event"] != "car_data" {
+		t.Fatalf("event = %v, want car_data with id 1", event)
+	}
+	var rows []openf1.CarData
+	if err := json.Unmarshal([]byte(event["data"]), &rows); err != nil {
+		t.Fatalf("data isn't a single line of JSON: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Speed != 301 {
+		t.Errorf("rows = %+v, want car 1's sample", rows)
+	}
+}
+
+func TestLiveResume(t *testing.T) {
+	srv := newLiveServer(t)
+	_, r := stream(t, srv, "")
+	readFrame(t, r)
+	first := readFrame(t, r)
+
+	// a client that saw everything picks up where it was with nothing sent again
+	_, r = stream(t, srv, first["id"])
+	readFrame(t, r)
+	select {
+	case f := <-frames(r):
+		t.Fatalf("resumed stream sent %v with nothing new", f)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// an id the server never sent is reset to the latest
+	_, r = stream(t, srv, "500")
+	readFrame(t, r)
+	reset := readFrame(t, r)
+	if reset["event"] != ingest.EventReset || reset["id"] != first["id"] {
+		t.Errorf("event = %v, want a reset to id %s", reset, first["id"])
+	}
+}
+
+func TestLiveErrors(t *testing.T) {
+	srv := newLiveServer(t)
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/sessions/9157/live", http.StatusConflict},
+		{"/sessions/1/live", http.StatusNotFound},
+		{"/sessions/abc/live", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		response, err := http.Get(srv.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("%s status = %d, want %d", test.path, response.StatusCode, test.status)
+		}
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, srv.URL+"/sessions/9158/live", nil)
+	request.Header.Set("Last-Event-ID", "abc")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d for a bad Last-Event-ID, want 400", response.StatusCode)
+	}
+}
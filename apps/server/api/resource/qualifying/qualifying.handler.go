@@ -0,0 +1,391 @@
+package qualifying
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/api/response"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// Breakdown is a qualifying session split into its Q1/Q2/Q3 segments
+type Breakdown struct {
+	SessionKey     int       `json:"session_key"`
+	SessionName    string    `json:"session_name"`
+	Segments       []Segment `json:"segments"`
+	Classification []Result  `json:"classification"`
+}
+
+type Segment struct {
+	Name  string     `json:"name"`
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end"`
+	// the last position that made it through to the next segment, 0 for the final segment
+	CutoffPosition int           `json:"cutoff_position"`
+	CutoffTime     *float64      `json:"cutoff_time"`
+	Times          []SegmentTime `json:"times"`
+}
+
+type SegmentTime struct {
+	Position     int      `json:"position"`
+	DriverNumber int      `json:"driver_number"`
+	BestLap      *float64 `json:"best_lap"`
+	LapNumber    int      `json:"lap_number"`
+	GapToCutoff  *float64 `json:"gap_to_cutoff"`
+	Eliminated   bool     `json:"eliminated"`
+	// setAt is when the best lap started, for breaking ties
+	setAt time.Time
+}
+
+// Result is a driver's final qualifying position along with their best time in each segment
+type Result struct {
+	Position     int        `json:"position"`
+	DriverNumber int        `json:"driver_number"`
+	EliminatedIn string     `json:"eliminated_in,omitempty"`
+	BestLaps     []*float64 `json:"best_laps"`
+}
+
+// segment start messages, "Q2 STARTED" or "SQ1 STARTED", anchored so that announcements such as
+// "Q2 WILL START AT 16:23" or "Q1 WILL RESUME AT 15:40" and stewards' notes naming a segment aren't taken for one
+var segmentMessage = regexp.MustCompile(`^S?Q([123]) STARTED$`)
+
+// deleted lap messages e.g. "CAR 44 (HAM) TIME 1:27.123 DELETED - TRACK LIMITS AT TURN 4 LAP 7 14:23:45"
+var deletedLapMessage = regexp.MustCompile(`CAR (\d+).* DELETED.* LAP (\d+)`)
+var reinstatedLapMessage = regexp.MustCompile(`CAR (\d+).* REINSTATED.* LAP (\d+)`)
+
+// finalSegmentCars is how many cars take part in Q3, the drop-outs are split evenly between Q1 and Q2
+const finalSegmentCars = 10
+
+type lapKey struct {
+	DriverNumber int
+	LapNumber    int
+}
+
+// Helper Functions
+// FindSegmentStarts returns the start time of each segment, in order, from the race control messages
+func FindSegmentStarts(messages []openf1.RaceControl) []time.Time {
+	starts := map[int]time.Time{}
+	for _, m := range messages {
+		phase := 0
+		if m.QualifyingPhase != nil {
+			phase = *m.QualifyingPhase
+		} else if match := segmentMessage.FindStringSubmatch(strings.TrimSpace(m.Message)); match != nil {
+			phase, _ = strconv.Atoi(match[1])
+		}
+		if phase < 1 || phase > 3 {
+			continue
+		}
+		// the earliest message of a segment marks its start
+		if start, ok := starts[phase]; !ok || m.Date.Before(start) {
+			starts[phase] = m.Date
+		}
+	}
+
+	var segmentStarts []time.Time
+	for phase := 1; phase <= 3; phase++ {
+		start, ok := starts[phase]
+		if !ok {
+			break
+		}
+		// segments have to be in order, anything else is a stray message
+		if len(segmentStarts) > 0 && !start.After(segmentStarts[len(segmentStarts)-1]) {
+			break
+		}
+		segmentStarts = append(segmentStarts, start)
+	}
+	return segmentStarts
+}
+
+// findDeletedLaps returns the laps that race control deleted and did not later reinstate
+func findDeletedLaps(messages []openf1.RaceControl) map[lapKey]bool {
+	sorted := append([]openf1.RaceControl{}, messages...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+
+	deleted := map[lapKey]bool{}
+	for _, m := range sorted {
+		if match := deletedLapMessage.FindStringSubmatch(m.Message); match != nil {
+			deleted[parseLapKey(match)] = true
+		} else if match := reinstatedLapMessage.FindStringSubmatch(m.Message); match != nil {
+			delete(deleted, parseLapKey(match))
+		}
+	}
+	return deleted
+}
+
+func parseLapKey(match []string) lapKey {
+	driver, _ := strconv.Atoi(match[1])
+	lap, _ := strconv.Atoi(match[2])
+	return lapKey{DriverNumber: driver, LapNumber: lap}
+}
+
+// advancing is how many of entrants cars go through from Q1 and from Q2, Q1 drops the odd car when there is one
+// nil when there are too few cars to tell, the cut-offs are then taken from who ran in the next segment
+func advancing(entrants int) []int {
+	if entrants <= finalSegmentCars {
+		return nil
+	}
+	dropped := entrants - finalSegmentCars
+	return []int{entrants - (dropped+1)/2, finalSegmentCars}
+}
+
+// BuildBreakdown assigns each lap to the segment it was started in and ranks the drivers per segment
+// entrants is the size of the entry list, which sets how many cars go through from each segment
+// a driver who goes through but doesn't run in the next segment is classified at the back of it, 0 takes the entry list from Q1
+func BuildBreakdown(laps []openf1.Lap, messages []openf1.RaceControl, prefix string, entrants int) ([]Segment, []Result) {
+	starts := FindSegmentStarts(messages)
+	deleted := findDeletedLaps(messages)
+
+	segments := make([]Segment, len(starts))
+	best := make([]map[int]openf1.Lap, len(starts))
+	for i, start := range starts {
+		segments[i] = Segment{Name: fmt.Sprintf("%s%d", prefix, i+1), Start: start}
+		if i+1 < len(starts) {
+			end := starts[i+1]
+			segments[i].End = &end
+		}
+		best[i] = map[int]openf1.Lap{}
+	}
+
+	// drivers that set a lap in a segment took part in it, even without a valid time
+	participants := make([]map[int]bool, len(starts))
+	for i := range participants {
+		participants[i] = map[int]bool{}
+	}
+	for _, lap := range laps {
+		i := segmentIndex(starts, lap.DateStart)
+		if i < 0 {
+			continue
+		}
+		participants[i][lap.DriverNumber] = true
+		if lap.LapDuration == nil || lap.IsPitOutLap || deleted[lapKey{lap.DriverNumber, lap.LapNumber}] {
+			continue
+		}
+		current, ok := best[i][lap.DriverNumber]
+		if !ok || current.LapDuration == nil || *lap.LapDuration < *current.LapDuration {
+			best[i][lap.DriverNumber] = lap
+		}
+	}
+
+	// running in a segment means having gone through every one before it
+	for i := len(participants) - 1; i > 0; i-- {
+		for driver := range participants[i] {
+			participants[i-1][driver] = true
+		}
+	}
+	if entrants == 0 && len(participants) > 0 {
+		entrants = len(participants[0])
+	}
+	quotas := advancing(entrants)
+
+	for i := range segments {
+		times := []SegmentTime{}
+		for driver := range participants[i] {
+			entry := SegmentTime{DriverNumber: driver}
+			if lap, ok := best[i][driver]; ok {
+				entry.BestLap = lap.LapDuration
+				entry.LapNumber = lap.LapNumber
+				entry.setAt = lap.DateStart
+			}
+			times = append(times, entry)
+		}
+		sortByTime(times)
+
+		// the cut-off is the slowest driver who went through to the next segment
+		cutoff := 0
+		if i+1 < len(segments) {
+			cutoff = len(participants[i+1])
+			if i < len(quotas) {
+				cutoff = quotas[i]
+			}
+			// drivers who went through without setting a lap in the next segment are the fastest of the rest
+			for j := 0; j < len(times) && len(participants[i+1]) < cutoff; j++ {
+				participants[i+1][times[j].DriverNumber] = true
+			}
+		}
+		for j := range times {
+			times[j].Position = j + 1
+			times[j].Eliminated = cutoff > 0 && !participants[i+1][times[j].DriverNumber]
+		}
+		if cutoff > 0 && cutoff <= len(times) {
+			segments[i].CutoffPosition = cutoff
+			segments[i].CutoffTime = times[cutoff-1].BestLap
+		}
+		if segments[i].CutoffTime != nil {
+			for j := range times {
+				if times[j].BestLap != nil {
+					// lap times are to the millisecond
+					gap := math.Round((*times[j].BestLap-*segments[i].CutoffTime)*1000) / 1000
+					times[j].GapToCutoff = &gap
+				}
+			}
+		}
+		segments[i].Times = times
+	}
+
+	return segments, classify(segments)
+}
+
+// classify orders drivers by the last segment they took part in, then by their time in it
+func classify(segments []Segment) []Result {
+	results := []Result{}
+	placed := map[int]bool{}
+	for i := len(segments) - 1; i >= 0; i-- {
+		for _, t := range segments[i].Times {
+			if placed[t.DriverNumber] {
+				continue
+			}
+			placed[t.DriverNumber] = true
+			result := Result{
+				Position:     len(results) + 1,
+				DriverNumber: t.DriverNumber,
+				BestLaps:     make([]*float64, len(segments)),
+			}
+			if i < len(segments)-1 {
+				result.EliminatedIn = segments[i].Name
+			}
+			results = append(results, result)
+		}
+	}
+	// fill in each driver's best time for every segment they took part in
+	positions := map[int]int{}
+	for i, r := range results {
+		positions[r.DriverNumber] = i
+	}
+	for i, segment := range segments {
+		for _, t := range segment.Times {
+			results[positions[t.DriverNumber]].BestLaps[i] = t.BestLap
+		}
+	}
+	return results
+}
+
+func segmentIndex(starts []time.Time, date time.Time) int {
+	index := -1
+	for i, start := range starts {
+		if !date.Before(start) {
+			index = i
+		}
+	}
+	return index
+}
+
+// sortByTime orders the fastest first, drivers without a time go to the back
+func sortByTime(times []SegmentTime) {
+	sort.Slice(times, func(i, j int) bool {
+		a, b := times[i].BestLap, times[j].BestLap
+		if a == nil || b == nil {
+			if a == nil && b == nil {
+				return times[i].DriverNumber < times[j].DriverNumber
+			}
+			return b == nil
+		}
+		if *a == *b {
+			// the driver that set the time first keeps the position, lap numbers are each driver's own so can't tell
+			x, y := times[i].setAt, times[j].setAt
+			if !x.IsZero() && !y.IsZero() && !x.Equal(y) {
+				return x.Before(y)
+			}
+			return times[i].DriverNumber < times[j].DriverNumber
+		}
+		return *a < *b
+	})
+}
+
+// Handler serves qualifying breakdowns
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Logger *slog.Logger
+}
+
+// Qualifying Handlers
+func (h *Handler) QualifyingHandler(w http.ResponseWriter, r *http.Request) {
+	// extract the sessionKey of the qualifying session from the URL path
+	id, err := strconv.Atoi(r.PathValue("key"))
+	if err != nil {
+		http.Error(w, "Invalid session Key Id", http.StatusBadRequest)
+		return
+	}
+	TzConfig, err := session.ParseTimezoneFromRequest(r)
+	if err != nil {
+		http.Error(w, "invalid tz parameter", http.StatusBadRequest)
+		return
+	}
+	h.handleGetQualifying(w, r, id, TzConfig)
+}
+
+// business logic of the handler methods
+func (h *Handler) handleGetQualifying(w http.ResponseWriter, r *http.Request, id int, tz session.TimezoneConfig) {
+	sessions, err := h.Source.Sessions(r.Context())
+	if err != nil {
+		h.Logger.Error("fetching sessions", "error", err)
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	s := session.FindSessionById(sessions, id)
+	if s == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if s.SessionType != "Qualifying" {
+		http.Error(w, "Breakdown is only available for qualifying sessions", http.StatusBadRequest)
+		return
+	}
+
+	laps, err := h.Source.Laps(r.Context(), id)
+	if err != nil && !errors.Is(err, openf1.ErrNoResults) {
+		http.Error(w, "Error fetching laps", http.StatusInternalServerError)
+		return
+	}
+	messages, err := h.Source.RaceControl(r.Context(), id)
+	if err != nil && !errors.Is(err, openf1.ErrNoResults) {
+		http.Error(w, "Error fetching race control messages", http.StatusInternalServerError)
+		return
+	}
+
+	// sprint shootouts use SQ1/SQ2/SQ3
+	prefix := "Q"
+	if s.SessionName != "Qualifying" {
+		prefix = "SQ"
+	}
+	// the entry list sets how many cars go through each segment, without it the cars that ran in Q1 are taken for it
+	drivers, err := h.Source.Drivers(r.Context(), id)
+	if err != nil && !errors.Is(err, openf1.ErrNoResults) {
+		h.Logger.Error("fetching drivers for qualifying", "session_key", id, "error", err)
+	}
+	segments, classification := BuildBreakdown(laps, messages, prefix, len(drivers))
+	if len(segments) == 0 {
+		http.Error(w, "Unable to find qualifying segments for this session", http.StatusUnprocessableEntity)
+		return
+	}
+
+	location := tz.LocationFor(*s)
+	for i := range segments {
+		segments[i].Start = session.Convert(segments[i].Start, location)
+		if segments[i].End != nil {
+			end := session.Convert(*segments[i].End, location)
+			segments[i].End = &end
+		}
+	}
+
+	breakdown := Breakdown{
+		SessionKey:     s.SessionKey,
+		SessionName:    s.SessionName,
+		Segments:       segments,
+		Classification: classification,
+	}
+	response.Write(w, r, breakdown)
+}
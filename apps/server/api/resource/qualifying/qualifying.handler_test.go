@@ -0,0 +1,269 @@
+package qualifying_test
+
+import (
+	"testing"
+	"time"
+
+	"telem-api-server/api/resource/qualifying"
+	"telem-api-server/openf1"
+)
+
+// at returns a time on the afternoon of a qualifying session, e.g. at("15:04:05")
+func at(clock string) time.Time {
+	t, err := time.Parse("15:04:05", clock)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2023, 7, 22, t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+func message(clock string, text string) openf1.RaceControl {
+	return openf1.RaceControl{Category: "Other", Date: at(clock), Message: text, SessionKey: 9139}
+}
+
+// phased is a message openf1 tagged with the segment it was sent in
+func phased(clock string, text string, phase int) openf1.RaceControl {
+	m := message(clock, text)
+	m.QualifyingPhase = &phase
+	return m
+}
+
+func TestFindSegmentStarts(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []openf1.RaceControl
+		starts   []time.Time
+	}{
+		{
+			name: "start messages",
+			messages: []openf1.RaceControl{
+				message("14:00:00", "GREEN LIGHT - PIT EXIT OPEN"),
+				message("14:00:00", "Q1 STARTED"),
+				message("14:18:00", "CHEQUERED FLAG"),
+				message("14:25:00", "Q2 STARTED"),
+				message("14:40:00", "CHEQUERED FLAG"),
+				message("14:48:00", "Q3 STARTED"),
+			},
+			starts: []time.Time{at("14:00:00"), at("14:25:00"), at("14:48:00")},
+		},
+		{
+			name: "announced starts are not starts",
+			messages: []openf1.RaceControl{
+				message("14:00:00", "Q1 STARTED"),
+				message("14:19:02", "Q2 WILL START AT 14:25"),
+				message("14:25:00", "Q2 STARTED"),
+				message("14:41:30", "Q3 WILL START AT 14:48"),
+				message("14:48:00", "Q3 STARTED"),
+			},
+			starts: []time.Time{at("14:00:00"), at("14:25:00"), at("14:48:00")},
+		},
+		{
+			name: "stewards naming a later segment",
+			messages: []openf1.RaceControl{
+				message("14:00:00", "Q1 STARTED"),
+				message("14:12:40", "FIA STEWARDS: CAR 2 (SAR) WILL START Q2 FROM THE BACK OF THE QUEUE"),
+				message("14:25:00", "Q2 STARTED"),
+				message("14:33:15", "FIA STEWARDS: INCIDENT INVOLVING CARS 22 (TSU) AND 31 (OCO) IN Q3 NOT INVESTIGATED"),
+				message("14:48:00", "Q3 STARTED"),
+			},
+			starts: []time.Time{at("14:00:00"), at("14:25:00"), at("14:48:00")},
+		},
+		{
+			name: "red flag and resumption",
+			messages: []openf1.RaceControl{
+				message("14:00:00", "Q1 STARTED"),
+				message("14:07:12", "RED FLAG"),
+				message("14:09:30", "Q1 WILL RESUME AT 14:20"),
+				message("14:20:00", "GREEN LIGHT - PIT EXIT OPEN"),
+				message("14:38:00", "Q2 STARTED"),
+			},
+			starts: []time.Time{at("14:00:00"), at("14:38:00")},
+		},
+		{
+			name: "sprint shootout",
+			messages: []openf1.RaceControl{
+				message("16:30:00", "SQ1 STARTED"),
+				message("16:42:00", "SQ2 WILL START AT 16:49"),
+				message("16:49:00", "SQ2 STARTED"),
+				message("17:05:00", "SQ3 STARTED"),
+			},
+			starts: []time.Time{at("16:30:00"), at("16:49:00"), at("17:05:00")},
+		},
+		{
+			name: "tagged with their segment",
+			messages: []openf1.RaceControl{
+				phased("14:00:00", "GREEN LIGHT - PIT EXIT OPEN", 1),
+				phased("14:19:02", "Q2 WILL START AT 14:25", 1),
+				phased("14:25:00", "GREEN LIGHT - PIT EXIT OPEN", 2),
+				phased("14:48:00", "GREEN LIGHT - PIT EXIT OPEN", 3),
+				phased("14:52:31", "CAR 44 (HAM) TIME 1:16.913 DELETED - TRACK LIMITS AT TURN 4 LAP 14 14:52:28", 3),
+			},
+			starts: []time.Time{at("14:00:00"), at("14:25:00"), at("14:48:00")},
+		},
+		{
+			name: "no start for the first segment",
+			messages: []openf1.RaceControl{
+				message("14:19:02", "Q2 WILL START AT 14:25"),
+				message("14:25:00", "Q2 STARTED"),
+			},
+			starts: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			starts := qualifying.FindSegmentStarts(tt.messages)
+			if len(starts) != len(tt.starts) {
+				t.Fatalf("starts = %v, want %v", starts, tt.starts)
+			}
+			for i := range starts {
+				if !starts[i].Equal(tt.starts[i]) {
+					t.Errorf("segment %d starts at %v, want %v", i+1, starts[i].Format("15:04:05"), tt.starts[i].Format("15:04:05"))
+				}
+			}
+		})
+	}
+}
+
+func lap(driver int, number int, clock string, duration float64) openf1.Lap {
+	return openf1.Lap{SessionKey: 9139, DriverNumber: driver, LapNumber: number, DateStart: at(clock), LapDuration: &duration}
+}
+
+func TestBuildBreakdown(t *testing.T) {
+	messages := []openf1.RaceControl{
+		message("14:00:00", "Q1 STARTED"),
+		// sent while the last Q1 laps are still being set
+		message("14:17:30", "Q2 WILL START AT 14:25"),
+		message("14:23:05", "CAR 1 (VER) TIME 1:16.900 DELETED - TRACK LIMITS AT TURN 4 LAP 6 14:23:01"),
+		message("14:25:00", "Q2 STARTED"),
+	}
+	laps := []openf1.Lap{
+		lap(1, 2, "14:05:00", 77.8),
+		lap(4, 2, "14:05:30", 77.6),
+		lap(16, 2, "14:06:00", 77.9),
+		// the last lap of Q1 is started after Q2 is announced
+		lap(16, 4, "14:18:10", 77.5),
+		lap(1, 6, "14:26:00", 76.9),
+		lap(1, 8, "14:30:00", 77.2),
+		lap(16, 6, "14:26:30", 77.0),
+	}
+
+	segments, classification := qualifying.BuildBreakdown(laps, messages, "Q", 0)
+	if len(segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(segments))
+	}
+	q1 := segments[0]
+	if got := len(q1.Times); got != 3 {
+		t.Fatalf("Q1 has %d drivers, want 3", got)
+	}
+	if first := q1.Times[0]; first.DriverNumber != 16 || *first.BestLap != 77.5 {
+		t.Errorf("Q1 fastest = car %d in %v, want car 16's lap after the Q2 announcement", first.DriverNumber, *first.BestLap)
+	}
+	if q1.CutoffPosition != 2 {
+		t.Errorf("Q1 cut-off position = %d, want 2", q1.CutoffPosition)
+	}
+
+	q2 := segments[1]
+	// car 1's quicker lap was deleted
+	if first := q2.Times[0]; first.DriverNumber != 16 {
+		t.Errorf("Q2 fastest = car %d, want 16", first.DriverNumber)
+	}
+	if second := q2.Times[1]; second.DriverNumber != 1 || *second.BestLap != 77.2 {
+		t.Errorf("Q2 second = car %d in %v, want car 1 in 77.2", second.DriverNumber, *second.BestLap)
+	}
+
+	want := []qualifying.Result{
+		{Position: 1, DriverNumber: 16},
+		{Position: 2, DriverNumber: 1},
+		{Position: 3, DriverNumber: 4, EliminatedIn: "Q1"},
+	}
+	if len(classification) != len(want) {
+		t.Fatalf("classification has %d drivers, want %d", len(classification), len(want))
+	}
+	for i, w := range want {
+		got := classification[i]
+		if got.Position != w.Position || got.DriverNumber != w.DriverNumber || got.EliminatedIn != w.EliminatedIn {
+			t.Errorf("P%d = car %d eliminated in %q, want car %d eliminated in %q",
+				i+1, got.DriverNumber, got.EliminatedIn, w.DriverNumber, w.EliminatedIn)
+		}
+	}
+}
+
+func TestBuildBreakdownDriverWithoutQ3Lap(t *testing.T) {
+	messages := []openf1.RaceControl{
+		message("14:00:00", "Q1 STARTED"),
+		message("14:25:00", "Q2 STARTED"),
+		message("14:45:00", "Q3 STARTED"),
+	}
+	// 14 entrants, 2 drop out in Q1 and 2 in Q2, in car number order
+	var laps []openf1.Lap
+	for driver := 1; driver <= 14; driver++ {
+		laps = append(laps, lap(driver, 2, "14:05:00", 80+float64(driver)/10))
+		if driver <= 12 {
+			laps = append(laps, lap(driver, 6, "14:30:00", 79+float64(driver)/10))
+		}
+		// car 1 went through to Q3 but didn't run in it
+		if driver >= 2 && driver <= 10 {
+			laps = append(laps, lap(driver, 10, "14:50:00", 78+float64(driver)/10))
+		}
+	}
+
+	segments, classification := qualifying.BuildBreakdown(laps, messages, "Q", 14)
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	for i, want := range []int{12, 10, 0} {
+		if got := segments[i].CutoffPosition; got != want {
+			t.Errorf("%s cut-off position = %d, want %d", segments[i].Name, got, want)
+		}
+	}
+	for _, entry := range segments[1].Times {
+		if entry.DriverNumber == 1 && entry.Eliminated {
+			t.Error("car 1 is eliminated in Q2 without having run in Q3")
+		}
+	}
+	q3 := segments[2].Times
+	if len(q3) != 10 {
+		t.Fatalf("Q3 has %d drivers, want 10", len(q3))
+	}
+	if last := q3[9]; last.DriverNumber != 1 || last.BestLap != nil {
+		t.Errorf("Q3 last = car %d, want car 1 without a time", last.DriverNumber)
+	}
+
+	want := map[int]qualifying.Result{
+		10: {Position: 10, DriverNumber: 1},
+		11: {Position: 11, DriverNumber: 11, EliminatedIn: "Q2"},
+		13: {Position: 13, DriverNumber: 13, EliminatedIn: "Q1"},
+	}
+	if len(classification) != 14 {
+		t.Fatalf("classification has %d drivers, want 14", len(classification))
+	}
+	for position, w := range want {
+		got := classification[position-1]
+		if got.DriverNumber != w.DriverNumber || got.EliminatedIn != w.EliminatedIn {
+			t.Errorf("P%d = car %d eliminated in %q, want car %d eliminated in %q",
+				position, got.DriverNumber, got.EliminatedIn, w.DriverNumber, w.EliminatedIn)
+		}
+	}
+}
+
+func TestBuildBreakdownEqualTimes(t *testing.T) {
+	messages := []openf1.RaceControl{message("14:00:00", "Q1 STARTED")}
+	// car 4 sets the time on its 5th lap before car 1 matches it on its 3rd
+	laps := []openf1.Lap{
+		lap(1, 3, "14:10:00", 77.5),
+		lap(4, 5, "14:08:00", 77.5),
+		lap(16, 2, "14:05:00", 77.5),
+		lap(44, 4, "14:12:00", 77.4),
+	}
+
+	segments, _ := qualifying.BuildBreakdown(laps, messages, "Q", 0)
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(segments))
+	}
+	want := []int{44, 16, 4, 1}
+	for i, driver := range want {
+		if got := segments[0].Times[i].DriverNumber; got != driver {
+			t.Errorf("P%d = car %d, want car %d", i+1, got, driver)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package season
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/openf1"
+)
+
+// Calendar is a season's meetings with their sessions, in running order
+type Calendar struct {
+	Year     int               `json:"year"`
+	Meetings []CalendarMeeting `json:"meetings"`
+}
+
+type CalendarMeeting struct {
+	MeetingKey       int               `json:"meeting_key"`
+	MeetingName      string            `json:"meeting_name"`
+	CircuitShortName string            `json:"circuit_short_name"`
+	Location         string            `json:"location"`
+	CountryName      string            `json:"country_name"`
+	GmtOffset        string            `json:"gmt_offset"`
+	Sessions         []CalendarSession `json:"sessions"`
+}
+
+type CalendarSession struct {
+	SessionKey  int       `json:"session_key"`
+	SessionName string    `json:"session_name"`
+	SessionType string    `json:"session_type"`
+	DateStart   time.Time `json:"date_start"`
+	DateEnd     time.Time `json:"date_end"`
+}
+
+// BuildCalendar groups a season's sessions by meeting, meetings are used for their names where available
+func BuildCalendar(year int, sessions []session.Session, meetings []openf1.Meeting) Calendar {
+	names := map[int]string{}
+	for _, m := range meetings {
+		names[m.MeetingKey] = m.MeetingName
+	}
+
+	var seasonSessions []session.Session
+	for _, s := range sessions {
+		if s.Year == year {
+			seasonSessions = append(seasonSessions, s)
+		}
+	}
+	sort.Slice(seasonSessions, func(i, j int) bool {
+		return seasonSessions[i].DateStart.Before(seasonSessions[j].DateStart)
+	})
+
+	calendar := Calendar{Year: year, Meetings: []CalendarMeeting{}}
+	index := map[int]int{}
+	for _, s := range seasonSessions {
+		i, ok := index[s.MeetingKey]
+		if !ok {
+			name, ok := names[s.MeetingKey]
+			if !ok {
+				name = s.Location
+			}
+			calendar.Meetings = append(calendar.Meetings, CalendarMeeting{
+				MeetingKey:       s.MeetingKey,
+				MeetingName:      name,
+				CircuitShortName: s.CircuitShortName,
+				Location:         s.Location,
+				CountryName:      s.CountryName,
+				GmtOffset:        s.GmtOffset,
+				Sessions:         []CalendarSession{},
+			})
+			i = len(calendar.Meetings) - 1
+			index[s.MeetingKey] = i
+		}
+		calendar.Meetings[i].Sessions = append(calendar.Meetings[i].Sessions, CalendarSession{
+			SessionKey:  s.SessionKey,
+			SessionName: s.SessionName,
+			SessionType: s.SessionType,
+			DateStart:   s.DateStart,
+			DateEnd:     s.DateEnd,
+		})
+	}
+	return calendar
+}
+
+// WriteICS renders the calendar as an iCalendar (RFC 5545) document with one VEVENT per session
+// session times are written in the meeting's local time, using a VTIMEZONE for each gmt offset
+func WriteICS(calendar Calendar, now time.Time) string {
+	var b strings.Builder
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:-//project-galimoto//telem-api-server//EN")
+	writeLine(&b, "CALSCALE:GREGORIAN")
+	writeLine(&b, "METHOD:PUBLISH")
+	writeLine(&b, "X-WR-CALNAME:"+escapeText(fmt.Sprintf("Formula 1 %d", calendar.Year)))
+
+	// one VTIMEZONE per distinct offset, they have to be defined before being referenced
+	// keyed by TZID, "02:00:00" and "+02:00:00" are the same zone
+	zones := map[string]bool{}
+	for _, m := range calendar.Meetings {
+		loc := meetingLocation(m)
+		if loc == nil || zones[tzid(loc)] {
+			continue
+		}
+		zones[tzid(loc)] = true
+		writeTimezone(&b, loc)
+	}
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, m := range calendar.Meetings {
+		loc := meetingLocation(m)
+		for _, s := range m.Sessions {
+			if s.DateStart.IsZero() || s.DateEnd.IsZero() {
+				continue
+			}
+			writeLine(&b, "BEGIN:VEVENT")
+			writeLine(&b, fmt.Sprintf("UID:session-%d@telem-api-server", s.SessionKey))
+			writeLine(&b, "DTSTAMP:"+stamp)
+			writeLine(&b, formatDate("DTSTART", s.DateStart, loc))
+			writeLine(&b, formatDate("DTEND", s.DateEnd, loc))
+			writeLine(&b, "SUMMARY:"+escapeText(m.MeetingName+" - "+s.SessionName))
+			writeLine(&b, "LOCATION:"+escapeText(m.CircuitShortName+", "+m.Location+", "+m.CountryName))
+			writeLine(&b, "CATEGORIES:"+escapeText(s.SessionType))
+			writeLine(&b, "END:VEVENT")
+		}
+	}
+	writeLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// meetingLocation is the meeting's fixed offset zone, nil when openf1 sent an offset we can't read
+func meetingLocation(m CalendarMeeting) *time.Location {
+	loc, err := session.Session{GmtOffset: m.GmtOffset}.GmtLocation()
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// icsOffset formats the location's offset as used by TZOFFSETTO, e.g. +0200
+func icsOffset(loc *time.Location) string {
+	_, offset := time.Unix(0, 0).In(loc).Zone()
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+// tzid is the id of the VTIMEZONE for a location, a colon would need quoting so the offset is used bare
+func tzid(loc *time.Location) string {
+	return "UTC" + icsOffset(loc)
+}
+
+func writeTimezone(b *strings.Builder, loc *time.Location) {
+	writeLine(b, "BEGIN:VTIMEZONE")
+	writeLine(b, "TZID:"+tzid(loc))
+	writeLine(b, "BEGIN:STANDARD")
+	writeLine(b, "DTSTART:19700101T000000")
+	writeLine(b, "TZOFFSETFROM:"+icsOffset(loc))
+	writeLine(b, "TZOFFSETTO:"+icsOffset(loc))
+	writeLine(b, "TZNAME:"+tzid(loc))
+	writeLine(b, "END:STANDARD")
+	writeLine(b, "END:VTIMEZONE")
+}
+
+// formatDate writes a local time against the meeting's time zone, or UTC if the offset is unknown
+func formatDate(property string, t time.Time, loc *time.Location) string {
+	if loc == nil {
+		return property + ":" + t.UTC().Format("20060102T150405Z")
+	}
+	return fmt.Sprintf("%s;TZID=%s:%s", property, tzid(loc), t.In(loc).Format("20060102T150405"))
+}
+
+// escapeText escapes the characters that have a meaning in iCalendar TEXT values
+func escapeText(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return replacer.Replace(text)
+}
+
+// writeLine folds lines longer than 75 octets and terminates them with CRLF as required by the spec
+func writeLine(b *strings.Builder, line string) {
+	// continuation lines start with a space which counts towards the limit
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// don't split a multi-byte character across lines
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
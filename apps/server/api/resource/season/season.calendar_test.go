@@ -0,0 +1,163 @@
+package season_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"telem-api-server/api/resource/season"
+)
+
+// goldenCalendar has two meetings whose offsets are spelled differently but are the same zone
+// a name long enough to fold with a multi-byte character on the fold, text that needs escaping,
+// a session openf1 hasn't dated yet and a meeting with an offset that can't be read
+func goldenCalendar() season.Calendar {
+	return season.Calendar{
+		Year: 2024,
+		Meetings: []season.CalendarMeeting{
+			{
+				MeetingKey: 1229, MeetingName: "Bahrain Grand Prix", CircuitShortName: "Sakhir",
+				Location: "Sakhir", CountryName: "Bahrain", GmtOffset: "03:00:00",
+				Sessions: []season.CalendarSession{
+					{SessionKey: 9472, SessionName: "Race", SessionType: "Race",
+						DateStart: time.Date(2024, 3, 2, 15, 0, 0, 0, time.UTC), DateEnd: time.Date(2024, 3, 2, 17, 0, 0, 0, time.UTC)},
+				},
+			},
+			{
+				MeetingKey: 1230, MeetingName: "Saudi Arabian Grand Prix", CircuitShortName: "Jeddah",
+				Location: "Jeddah", CountryName: "Saudi Arabia", GmtOffset: "+03:00:00",
+				Sessions: []season.CalendarSession{
+					{SessionKey: 9480, SessionName: "Race", SessionType: "Race",
+						DateStart: time.Date(2024, 3, 9, 17, 0, 0, 0, time.UTC), DateEnd: time.Date(2024, 3, 9, 19, 0, 0, 0, time.UTC)},
+				},
+			},
+			{
+				MeetingKey: 1231, MeetingName: "Grande Prêmio de São Paulo, Autódromo José Carlos Pace; Brasília",
+				CircuitShortName: "Interlagos", Location: "São Paulo", CountryName: "Brazil\\Brasil", GmtOffset: "-03:00:00",
+				Sessions: []season.CalendarSession{
+					{SessionKey: 9600, SessionName: "Sprint\nShootout", SessionType: "Qualifying",
+						DateStart: time.Date(2024, 11, 2, 14, 0, 0, 0, time.UTC), DateEnd: time.Date(2024, 11, 2, 15, 0, 0, 0, time.UTC)},
+					// not dated yet, left out rather than written as year 1
+					{SessionKey: 9601, SessionName: "Race", SessionType: "Race", DateStart: time.Date(2024, 11, 3, 17, 0, 0, 0, time.UTC)},
+				},
+			},
+			{
+				MeetingKey: 1232, MeetingName: "Formula 1 Aramco Pre-Season Testing 2024 at the Bahrain International Circuit, three days of running for every team before the first round", CircuitShortName: "Sakhir", Location: "Sakhir", CountryName: "Bahrain",
+				GmtOffset: "unknown",
+				Sessions: []season.CalendarSession{
+					{SessionKey: 9700, SessionName: "Day 1", SessionType: "Practice",
+						DateStart: time.Date(2024, 2, 21, 7, 0, 0, 0, time.UTC), DateEnd: time.Date(2024, 2, 21, 16, 0, 0, 0, time.UTC)},
+				},
+			},
+		},
+	}
+}
+
+// goldenICS is goldenCalendar written out, one entry per physical line
+// both +03:00 spellings share a VTIMEZONE, the undated race is left out and the unreadable offset is written in UTC
+var goldenICS = []string{
+	`BEGIN:VCALENDAR`,
+	`VERSION:2.0`,
+	`PRODID:-//project-galimoto//telem-api-server//EN`,
+	`CALSCALE:GREGORIAN`,
+	`METHOD:PUBLISH`,
+	`X-WR-CALNAME:Formula 1 2024`,
+	`BEGIN:VTIMEZONE`,
+	`TZID:UTC+0300`,
+	`BEGIN:STANDARD`,
+	`DTSTART:19700101T000000`,
+	`TZOFFSETFROM:+0300`,
+	`TZOFFSETTO:+0300`,
+	`TZNAME:UTC+0300`,
+	`END:STANDARD`,
+	`END:VTIMEZONE`,
+	`BEGIN:VTIMEZONE`,
+	`TZID:UTC-0300`,
+	`BEGIN:STANDARD`,
+	`DTSTART:19700101T000000`,
+	`TZOFFSETFROM:-0300`,
+	`TZOFFSETTO:-0300`,
+	`TZNAME:UTC-0300`,
+	`END:STANDARD`,
+	`END:VTIMEZONE`,
+	`BEGIN:VEVENT`,
+	`UID:session-9472@telem-api-server`,
+	`DTSTAMP:20240102T030405Z`,
+	`DTSTART;TZID=UTC+0300:20240302T180000`,
+	`DTEND;TZID=UTC+0300:20240302T200000`,
+	`SUMMARY:Bahrain Grand Prix - Race`,
+	`LOCATION:Sakhir\, Sakhir\, Bahrain`,
+	`CATEGORIES:Race`,
+	`END:VEVENT`,
+	`BEGIN:VEVENT`,
+	`UID:session-9480@telem-api-server`,
+	`DTSTAMP:20240102T030405Z`,
+	`DTSTART;TZID=UTC+0300:20240309T200000`,
+	`DTEND;TZID=UTC+0300:20240309T220000`,
+	`SUMMARY:Saudi Arabian Grand Prix - Race`,
+	`LOCATION:Jeddah\, Jeddah\, Saudi Arabia`,
+	`CATEGORIES:Race`,
+	`END:VEVENT`,
+	`BEGIN:VEVENT`,
+	`UID:session-9600@telem-api-server`,
+	`DTSTAMP:20240102T030405Z`,
+	`DTSTART;TZID=UTC-0300:20241102T110000`,
+	`DTEND;TZID=UTC-0300:20241102T120000`,
+	`SUMMARY:Grande Prêmio de São Paulo\, Autódromo José Carlos Pace\; Bras`,
+	` ília - Sprint\nShootout`,
+	`LOCATION:Interlagos\, São Paulo\, Brazil\\Brasil`,
+	`CATEGORIES:Qualifying`,
+	`END:VEVENT`,
+	`BEGIN:VEVENT`,
+	`UID:session-9700@telem-api-server`,
+	`DTSTAMP:20240102T030405Z`,
+	`DTSTART:20240221T070000Z`,
+	`DTEND:20240221T160000Z`,
+	`SUMMARY:Formula 1 Aramco Pre-Season Testing 2024 at the Bahrain Internation`,
+	` al Circuit\, three days of running for every team before the first round -`,
+	`  Day 1`,
+	`LOCATION:Sakhir\, Sakhir\, Bahrain`,
+	`CATEGORIES:Practice`,
+	`END:VEVENT`,
+	`END:VCALENDAR`,
+}
+
+func TestWriteICS(t *testing.T) {
+	got := season.WriteICS(goldenCalendar(), time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	want := strings.Join(goldenICS, "\r\n") + "\r\n"
+	if got != want {
+		gotLines := strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n")
+		for i := 0; i < max(len(gotLines), len(goldenICS)); i++ {
+			var g, w string
+			if i < len(gotLines) {
+				g = gotLines[i]
+			}
+			if i < len(goldenICS) {
+				w = goldenICS[i]
+			}
+			if g != w {
+				t.Errorf("line %d = %q, want %q", i+1, g, w)
+			}
+		}
+		t.FailNow()
+	}
+
+	for i, line := range strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n") {
+		// 75 octets including the space a continuation line starts with
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets", i+1, len(line))
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line %d splits a character: %q", i+1, line)
+		}
+	}
+	// unfolding gives back the whole summary
+	unfolded := strings.ReplaceAll(got, "\r\n ", "")
+	if !strings.Contains(unfolded, "\r\nSUMMARY:Grande Prêmio de São Paulo\\, Autódromo José Carlos Pace\\; Brasília - Sprint\\nShootout\r\n") {
+		t.Error("folded summary doesn't unfold to the escaped meeting and session name")
+	}
+	if n := strings.Count(got, "BEGIN:VTIMEZONE"); n != 2 {
+		t.Errorf("%d VTIMEZONEs, want one for +0300 and one for -0300", n)
+	}
+}
@@ -0,0 +1,230 @@
+package season
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/api/response"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+type DriverStandings struct {
+	Year      int              `json:"year"`
+	Standings []DriverStanding `json:"standings"`
+	Rounds    []Round          `json:"rounds"`
+}
+
+type ConstructorStandings struct {
+	Year      int                   `json:"year"`
+	Standings []ConstructorStanding `json:"standings"`
+	Rounds    []Round               `json:"rounds"`
+}
+
+// Helper Functions
+func fetchSeasonData(ctx context.Context, source *store.Source, year int) ([]SessionData, error) {
+	sessions, err := source.Sessions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+	system, err := PointsSystemForYear(year)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []SessionData
+	for _, s := range SeasonRaceSessions(sessions, year, time.Now()) {
+		d := SessionData{Session: s}
+		var err error
+		d.Results, err = source.SessionResults(ctx, s.SessionKey)
+		if err != nil && !errors.Is(err, openf1.ErrNoResults) {
+			return nil, err
+		}
+		// sessions that have not been classified yet are left out of the standings
+		if len(d.Results) == 0 {
+			continue
+		}
+		d.Drivers, err = source.Drivers(ctx, s.SessionKey)
+		if err != nil {
+			return nil, err
+		}
+		if system.FastestLap > 0 && !d.IsSprint() {
+			laps, err := source.Laps(ctx, s.SessionKey)
+			if err != nil && !errors.Is(err, openf1.ErrNoResults) {
+				return nil, err
+			}
+			d.FastestLapDriver = FindFastestLapDriver(laps)
+		}
+		data = append(data, d)
+	}
+	return data, nil
+}
+
+func parseYear(w http.ResponseWriter, r *http.Request) (int, bool) {
+	year, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		http.Error(w, "Invalid season year", http.StatusBadRequest)
+		return 0, false
+	}
+	return year, true
+}
+
+func parseStandingsYear(w http.ResponseWriter, r *http.Request) (int, bool) {
+	year, ok := parseYear(w, r)
+	if !ok {
+		return 0, false
+	}
+	if _, err := PointsSystemForYear(year); err != nil {
+		http.Error(w, "Standings are not available for this season", http.StatusBadRequest)
+		return 0, false
+	}
+	return year, true
+}
+
+// wantsICS checks whether the client asked for the calendar as iCalendar rather than JSON
+func wantsICS(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ics" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/calendar")
+}
+
+// Handler serves the season wide views
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Logger *slog.Logger
+}
+
+// Season Handlers
+func (h *Handler) DriverStandingsHandler(w http.ResponseWriter, r *http.Request) {
+	year, ok := parseStandingsYear(w, r)
+	if !ok {
+		return
+	}
+	h.handleGetDriverStandings(w, r, year)
+}
+
+func (h *Handler) ConstructorStandingsHandler(w http.ResponseWriter, r *http.Request) {
+	year, ok := parseStandingsYear(w, r)
+	if !ok {
+		return
+	}
+	h.handleGetConstructorStandings(w, r, year)
+}
+
+func (h *Handler) CalendarHandler(w http.ResponseWriter, r *http.Request) {
+	year, ok := parseYear(w, r)
+	if !ok {
+		return
+	}
+	TzConfig, err := session.ParseTimezoneFromRequest(r)
+	if err != nil {
+		http.Error(w, "invalid tz parameter", http.StatusBadRequest)
+		return
+	}
+	h.handleGetCalendar(w, r, year, wantsICS(r), TzConfig)
+}
+
+// CalendarICSHandler serves the calendar at a .ics url so mail clients can subscribe to it
+func (h *Handler) CalendarICSHandler(w http.ResponseWriter, r *http.Request) {
+	year, ok := parseYear(w, r)
+	if !ok {
+		return
+	}
+	// the iCalendar export always uses each meeting's own time zone
+	h.handleGetCalendar(w, r, year, true, session.TimezoneConfig{})
+}
+
+// business logic of the handler methods
+func (h *Handler) handleGetDriverStandings(w http.ResponseWriter, r *http.Request, year int) {
+	rounds, ok := h.buildRounds(w, r, year)
+	if !ok {
+		return
+	}
+
+	standings := DriverStandings{Year: year, Standings: []DriverStanding{}, Rounds: []Round{}}
+	for _, round := range rounds {
+		round.Constructors = nil
+		standings.Rounds = append(standings.Rounds, round)
+	}
+	if len(rounds) > 0 {
+		standings.Standings = rounds[len(rounds)-1].Drivers
+	}
+
+	response.Write(w, r, standings)
+}
+
+func (h *Handler) handleGetConstructorStandings(w http.ResponseWriter, r *http.Request, year int) {
+	rounds, ok := h.buildRounds(w, r, year)
+	if !ok {
+		return
+	}
+
+	standings := ConstructorStandings{Year: year, Standings: []ConstructorStanding{}, Rounds: []Round{}}
+	for _, round := range rounds {
+		round.Drivers = nil
+		standings.Rounds = append(standings.Rounds, round)
+	}
+	if len(rounds) > 0 {
+		standings.Standings = rounds[len(rounds)-1].Constructors
+	}
+
+	response.Write(w, r, standings)
+}
+
+func (h *Handler) handleGetCalendar(w http.ResponseWriter, r *http.Request, year int, ics bool, tz session.TimezoneConfig) {
+
+	sessions, err := h.Source.Sessions(r.Context())
+	if err != nil {
+		h.Logger.Error("fetching sessions", "error", err)
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	meetings, err := h.Source.Meetings(r.Context(), year)
+	if err != nil {
+		// meeting names are nice to have, the calendar falls back to the location
+		h.Logger.Error("fetching meetings", "year", year, "error", err)
+	}
+
+	calendar := BuildCalendar(year, tz.ApplyAll(sessions), meetings)
+	if len(calendar.Meetings) == 0 {
+		http.Error(w, "No sessions found for this season", http.StatusNotFound)
+		return
+	}
+
+	if ics {
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"f1-%d.ics\"", year))
+		if _, err := io.WriteString(w, WriteICS(calendar, time.Now())); err != nil {
+			h.Logger.Error("writing calendar", "error", err)
+		}
+		return
+	}
+
+	response.Write(w, r, calendar)
+}
+
+func (h *Handler) buildRounds(w http.ResponseWriter, r *http.Request, year int) ([]Round, bool) {
+	data, err := fetchSeasonData(r.Context(), h.Source, year)
+	if err != nil {
+		h.Logger.Error("fetching season data", "year", year, "error", err)
+		http.Error(w, "Error fetching season data", http.StatusInternalServerError)
+		return nil, false
+	}
+	rounds, err := BuildStandings(year, data)
+	if err != nil {
+		http.Error(w, "Error building standings", http.StatusInternalServerError)
+		return nil, false
+	}
+	return rounds, true
+}
@@ -0,0 +1,273 @@
+package season
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/openf1"
+)
+
+// PointsSystem is the points awarded by finishing position for a season
+type PointsSystem struct {
+	Race   []int
+	Sprint []int
+	// a point for the fastest lap of the race, only if the driver finished inside FastestLapMaxPosition
+	FastestLap            int
+	FastestLapMaxPosition int
+}
+
+// points systems ordered by the first season they were used in
+var pointsSystems = []struct {
+	FromYear int
+	System   PointsSystem
+}{
+	{2003, PointsSystem{Race: []int{10, 8, 6, 5, 4, 3, 2, 1}}},
+	{2010, PointsSystem{Race: []int{25, 18, 15, 12, 10, 8, 6, 4, 2, 1}}},
+	{2019, PointsSystem{Race: []int{25, 18, 15, 12, 10, 8, 6, 4, 2, 1}, FastestLap: 1, FastestLapMaxPosition: 10}},
+	{2021, PointsSystem{Race: []int{25, 18, 15, 12, 10, 8, 6, 4, 2, 1}, Sprint: []int{3, 2, 1}, FastestLap: 1, FastestLapMaxPosition: 10}},
+	{2022, PointsSystem{Race: []int{25, 18, 15, 12, 10, 8, 6, 4, 2, 1}, Sprint: []int{8, 7, 6, 5, 4, 3, 2, 1}, FastestLap: 1, FastestLapMaxPosition: 10}},
+	{2025, PointsSystem{Race: []int{25, 18, 15, 12, 10, 8, 6, 4, 2, 1}, Sprint: []int{8, 7, 6, 5, 4, 3, 2, 1}}},
+}
+
+func PointsSystemForYear(year int) (PointsSystem, error) {
+	for i := len(pointsSystems) - 1; i >= 0; i-- {
+		if year >= pointsSystems[i].FromYear {
+			return pointsSystems[i].System, nil
+		}
+	}
+	return PointsSystem{}, fmt.Errorf("no points system for %d", year)
+}
+
+// Points returns the points for a classified position in a race or sprint
+func (p PointsSystem) Points(position int, sprint bool) int {
+	table := p.Race
+	if sprint {
+		table = p.Sprint
+	}
+	if position < 1 || position > len(table) {
+		return 0
+	}
+	return table[position-1]
+}
+
+// SessionData is everything needed from openf1 to score a single race or sprint
+type SessionData struct {
+	Session session.Session
+	Results []openf1.SessionResult
+	Drivers []openf1.Driver
+	// 0 when the fastest lap is unknown or not needed for the points system
+	FastestLapDriver int
+}
+
+func (d SessionData) IsSprint() bool {
+	return d.Session.SessionName == "Sprint"
+}
+
+type DriverStanding struct {
+	Position     int    `json:"position"`
+	DriverNumber int    `json:"driver_number"`
+	FullName     string `json:"full_name"`
+	NameAcronym  string `json:"name_acronym"`
+	TeamName     string `json:"team_name"`
+	Points       int    `json:"points"`
+	Wins         int    `json:"wins"`
+}
+
+type ConstructorStanding struct {
+	Position int    `json:"position"`
+	TeamName string `json:"team_name"`
+	Points   int    `json:"points"`
+	Wins     int    `json:"wins"`
+}
+
+// Round is the championship standings after a meeting
+type Round struct {
+	Round            int                   `json:"round"`
+	MeetingKey       int                   `json:"meeting_key"`
+	CircuitShortName string                `json:"circuit_short_name"`
+	CountryName      string                `json:"country_name"`
+	SessionKeys      []int                 `json:"session_keys"`
+	Drivers          []DriverStanding      `json:"drivers,omitempty"`
+	Constructors     []ConstructorStanding `json:"constructors,omitempty"`
+}
+
+// tally keeps a running total for a driver or team
+// finishes counts race finishes by position, used for count-back when points are tied
+type tally struct {
+	name     string
+	points   int
+	wins     int
+	finishes map[int]int
+}
+
+type standings struct {
+	tallies map[string]*tally
+}
+
+func newStandings() *standings {
+	return &standings{tallies: map[string]*tally{}}
+}
+
+func (s *standings) add(key string, points int, position int, sprint bool) {
+	t, ok := s.tallies[key]
+	if !ok {
+		t = &tally{name: key, finishes: map[int]int{}}
+		s.tallies[key] = t
+	}
+	t.points += points
+	// only grand prix results count towards wins and count-back
+	if sprint || position == 0 {
+		return
+	}
+	t.finishes[position]++
+	if position == 1 {
+		t.wins++
+	}
+}
+
+// ranked returns the tallies ordered by points, then by count-back of finishing positions
+func (s *standings) ranked() []*tally {
+	ranked := make([]*tally, 0, len(s.tallies))
+	for _, t := range s.tallies {
+		ranked = append(ranked, t)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.points != b.points {
+			return a.points > b.points
+		}
+		for position := 1; position <= 30; position++ {
+			if a.finishes[position] != b.finishes[position] {
+				return a.finishes[position] > b.finishes[position]
+			}
+		}
+		return a.name < b.name
+	})
+	return ranked
+}
+
+// BuildStandings scores each session in order and snapshots the standings after every meeting
+func BuildStandings(year int, data []SessionData) ([]Round, error) {
+	system, err := PointsSystemForYear(year)
+	if err != nil {
+		return nil, err
+	}
+
+	drivers := newStandings()
+	constructors := newStandings()
+	// the latest known details of each driver, drivers can change teams mid season
+	driverInfo := map[int]openf1.Driver{}
+
+	var rounds []Round
+	for i, d := range data {
+		teams := map[int]string{}
+		for _, driver := range d.Drivers {
+			teams[driver.DriverNumber] = driver.TeamName
+			driverInfo[driver.DriverNumber] = driver
+		}
+
+		sprint := d.IsSprint()
+		for _, result := range d.Results {
+			position := 0
+			if result.Position != nil && !result.DSQ {
+				position = *result.Position
+			}
+			points := system.Points(position, sprint)
+			if !sprint && system.FastestLap > 0 && result.DriverNumber == d.FastestLapDriver &&
+				position >= 1 && position <= system.FastestLapMaxPosition {
+				points += system.FastestLap
+			}
+			drivers.add(strconv.Itoa(result.DriverNumber), points, position, sprint)
+			if team, ok := teams[result.DriverNumber]; ok {
+				constructors.add(team, points, position, sprint)
+			}
+		}
+
+		// take a snapshot once the last session of the meeting has been scored
+		if i+1 < len(data) && data[i+1].Session.MeetingKey == d.Session.MeetingKey {
+			continue
+		}
+		round := Round{
+			Round:            len(rounds) + 1,
+			MeetingKey:       d.Session.MeetingKey,
+			CircuitShortName: d.Session.CircuitShortName,
+			CountryName:      d.Session.CountryName,
+		}
+		for j := i; j >= 0 && data[j].Session.MeetingKey == d.Session.MeetingKey; j-- {
+			round.SessionKeys = append([]int{data[j].Session.SessionKey}, round.SessionKeys...)
+		}
+		round.Drivers = driverSnapshot(drivers, driverInfo)
+		round.Constructors = constructorSnapshot(constructors)
+		rounds = append(rounds, round)
+	}
+	return rounds, nil
+}
+
+func driverSnapshot(s *standings, info map[int]openf1.Driver) []DriverStanding {
+	var snapshot []DriverStanding
+	for i, t := range s.ranked() {
+		number, _ := strconv.Atoi(t.name)
+		driver := info[number]
+		snapshot = append(snapshot, DriverStanding{
+			Position:     i + 1,
+			DriverNumber: number,
+			FullName:     driver.FullName,
+			NameAcronym:  driver.NameAcronym,
+			TeamName:     driver.TeamName,
+			Points:       t.points,
+			Wins:         t.wins,
+		})
+	}
+	return snapshot
+}
+
+func constructorSnapshot(s *standings) []ConstructorStanding {
+	var snapshot []ConstructorStanding
+	for i, t := range s.ranked() {
+		snapshot = append(snapshot, ConstructorStanding{
+			Position: i + 1,
+			TeamName: t.name,
+			Points:   t.points,
+			Wins:     t.wins,
+		})
+	}
+	return snapshot
+}
+
+// SeasonRaceSessions returns the races and sprints of a season that have finished, in running order
+func SeasonRaceSessions(sessions []session.Session, year int, now time.Time) []session.Session {
+	var races []session.Session
+	for _, s := range sessions {
+		if s.Year != year || s.SessionType != "Race" {
+			continue
+		}
+		if s.DateEnd.IsZero() || s.DateEnd.After(now) {
+			continue
+		}
+		races = append(races, s)
+	}
+	sort.Slice(races, func(i, j int) bool {
+		return races[i].DateStart.Before(races[j].DateStart)
+	})
+	return races
+}
+
+// FindFastestLapDriver returns the driver who set the fastest lap, or 0 if nobody set a time
+func FindFastestLapDriver(laps []openf1.Lap) int {
+	var fastest *openf1.Lap
+	for i, lap := range laps {
+		if lap.LapDuration == nil {
+			continue
+		}
+		if fastest == nil || *lap.LapDuration < *fastest.LapDuration {
+			fastest = &laps[i]
+		}
+	}
+	if fastest == nil {
+		return 0
+	}
+	return fastest.DriverNumber
+}
@@ -0,0 +1,157 @@
+package season_test
+
+import (
+	"testing"
+
+	"telem-api-server/api/resource/season"
+	"telem-api-server/api/resource/session"
+	"telem-api-server/openf1"
+)
+
+// result is a classified finish, position 0 leaves the driver unclassified
+func result(driver int, position int) openf1.SessionResult {
+	r := openf1.SessionResult{DriverNumber: driver}
+	if position > 0 {
+		r.Position = &position
+	}
+	return r
+}
+
+// race is a grand prix at meeting, fastestLap is the driver who set the fastest lap or 0
+func race(sessionKey int, meeting int, fastestLap int, results ...openf1.SessionResult) season.SessionData {
+	return season.SessionData{
+		Session:          session.Session{SessionKey: sessionKey, MeetingKey: meeting, SessionName: "Race", SessionType: "Race"},
+		Results:          results,
+		FastestLapDriver: fastestLap,
+	}
+}
+
+func sprint(sessionKey int, meeting int, results ...openf1.SessionResult) season.SessionData {
+	return season.SessionData{
+		Session: session.Session{SessionKey: sessionKey, MeetingKey: meeting, SessionName: "Sprint", SessionType: "Race"},
+		Results: results,
+	}
+}
+
+// standing is a driver's place in the final round
+type standing struct {
+	driver int
+	points int
+}
+
+func TestBuildStandings(t *testing.T) {
+	tests := []struct {
+		name string
+		year int
+		data []season.SessionData
+		want []standing
+	}{
+		{
+			name: "count-back on wins",
+			year: 2023,
+			data: []season.SessionData{
+				race(1, 100, 0, result(63, 1), result(44, 2)),
+				race(2, 101, 0, result(44, 6), result(63, 10)),
+			},
+			// 26 each, the win puts 63 ahead even though 44 sorts first
+			want: []standing{{63, 26}, {44, 26}},
+		},
+		{
+			name: "count-back on second places",
+			year: 2023,
+			data: []season.SessionData{
+				race(1, 100, 0, result(81, 2), result(4, 3)),
+				race(2, 101, 0, result(4, 3), result(81, 4)),
+			},
+			want: []standing{{81, 30}, {4, 30}},
+		},
+		{
+			name: "sprint wins don't count back",
+			year: 2023,
+			data: []season.SessionData{
+				sprint(1, 100, result(16, 1), result(55, 3)),
+				race(2, 100, 0, result(55, 4), result(16, 5)),
+			},
+			// level on 18, 16's sprint win is left out of the count-back so 55's better race finish decides it
+			want: []standing{{55, 18}, {16, 18}},
+		},
+		{
+			name: "fastest lap outside the top 10",
+			year: 2023,
+			data: []season.SessionData{
+				race(1, 100, 20, result(1, 1), result(2, 10), result(20, 12)),
+			},
+			want: []standing{{1, 25}, {2, 1}, {20, 0}},
+		},
+		{
+			name: "fastest lap in 10th",
+			year: 2023,
+			data: []season.SessionData{
+				race(1, 100, 2, result(1, 1), result(2, 10), result(20, 12)),
+			},
+			want: []standing{{1, 25}, {2, 2}, {20, 0}},
+		},
+		{
+			name: "fastest lap without finishing",
+			year: 2023,
+			data: []season.SessionData{
+				race(1, 100, 20, result(1, 1), result(20, 0)),
+			},
+			want: []standing{{1, 25}, {20, 0}},
+		},
+		{
+			name: "no fastest lap point from 2025",
+			year: 2025,
+			data: []season.SessionData{
+				race(1, 100, 1, result(1, 1), result(2, 2)),
+			},
+			want: []standing{{1, 25}, {2, 18}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rounds, err := season.BuildStandings(tt.year, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rounds) == 0 {
+				t.Fatal("no rounds")
+			}
+			drivers := rounds[len(rounds)-1].Drivers
+			if len(drivers) != len(tt.want) {
+				t.Fatalf("got %d drivers, want %d", len(drivers), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := drivers[i]
+				if got.Position != i+1 || got.DriverNumber != want.driver || got.Points != want.points {
+					t.Errorf("P%d = car %d on %d points, want car %d on %d", got.Position, got.DriverNumber, got.Points, want.driver, want.points)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildStandingsRounds(t *testing.T) {
+	data := []season.SessionData{
+		sprint(1, 100, result(1, 1)),
+		race(2, 100, 0, result(1, 1)),
+		race(3, 101, 0, result(1, 2)),
+	}
+	rounds, err := season.BuildStandings(2023, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a sprint weekend is one round
+	if len(rounds) != 2 {
+		t.Fatalf("got %d rounds, want 2", len(rounds))
+	}
+	if keys := rounds[0].SessionKeys; len(keys) != 2 || keys[0] != 1 || keys[1] != 2 {
+		t.Errorf("round 1 sessions = %v, want [1 2]", keys)
+	}
+	if points := rounds[0].Drivers[0].Points; points != 33 {
+		t.Errorf("points after round 1 = %d, want 33", points)
+	}
+	if wins := rounds[1].Drivers[0].Wins; wins != 1 {
+		t.Errorf("wins = %d, want 1 as sprint wins aren't counted", wins)
+	}
+}
@@ -1,37 +1,38 @@
 package session
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
+	"time"
+
+	"telem-api-server/api/response"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/pubsub"
+	"telem-api-server/store"
 )
 
-type Session struct {
-	CircuitKey       int    `json:"circuit_key"`
-	CircuitShortName string `json:"circuit_short_name"`
-	CountryCode      string `json:"country_code"`
-	CountryKey       int    `json:"country_key"`
-	CountryName      string `json:"country_name"`
-	DateEnd          string `json:"date_end"`
-	DateStart        string `json:"date_start"`
-	Location         string `json:"location"`
-	MeetingKey       int    `json:"meeting_key"`
-	SessionKey       int    `json:"session_key"`
-	SessionName      string `json:"session_name"`
-	SessionType      string `json:"session_type"`
-	Year             int    `json:"year"`
+// Session is the openf1 session model, it lives in the openf1 package so the store can use it too
+type Session = openf1.Session
+
+// DateRange is the start and end of a session
+type DateRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
 }
 
+// SessionKeysOnly is the response of /sessions/keys, kept for the clients that already use it
+// new slimmed down views should use the fields= parameter on /sessions rather than another type like this one
 type SessionKeysOnly struct {
 	SessionKey       int
 	CircuitKey       int
 	MeetingKey       int
 	CircuitShortName string
-	DateRange        string
+	DateRange        DateRange
 }
 
 // while we're able to use the multiple instances, we'll need a more improved way to perform operator overloading
@@ -41,10 +42,16 @@ type PaginationConfig struct {
 	HasPagination bool
 }
 
-type KeyOnlyConfig struct {
-	HasKeysOnly bool
+// TimezoneConfig is the zone timestamps are converted to before being returned
+// when Local is set each session is converted to its own circuit's gmt offset
+type TimezoneConfig struct {
+	Location *time.Location
+	Local    bool
 }
 
+// defaultLimit is the page size when skip is given without a limit
+const defaultLimit = 100
+
 // Helper Functions
 func ParsePaginationFromRequest(r *http.Request) (PaginationConfig, error) {
 	// why is everything only a single letter
@@ -58,6 +65,7 @@ func ParsePaginationFromRequest(r *http.Request) (PaginationConfig, error) {
 	}
 	// now we need to actually parse these values
 	config.HasPagination = true // we know that there is pagination in the request URL
+	config.Limit = defaultLimit
 	if skipStr != "" {
 		// set the value
 		skip, err := parseIntParam(skipStr, 0)
@@ -70,7 +78,7 @@ func ParsePaginationFromRequest(r *http.Request) (PaginationConfig, error) {
 	if limitStr != "" {
 		// set the value
 		// liimit
-		limit, err := parseIntParam(limitStr, 100)
+		limit, err := parseIntParam(limitStr, defaultLimit)
 		if err != nil || limit < 0 {
 			return config, fmt.Errorf("invalid limit parameter")
 		}
@@ -79,37 +87,80 @@ func ParsePaginationFromRequest(r *http.Request) (PaginationConfig, error) {
 	return config, nil
 }
 
-func fetchSessions(BaseUrl string) ([]Session, error) {
-	if BaseUrl == "" {
-		fmt.Println("BaseUrl is empty, unable to make request")
-		return nil, fmt.Errorf("baseUrl is empty, unable to make request")
+// ParseTimezoneFromRequest reads the tz parameter, either an IANA zone such as Europe/London or "local"
+// timestamps are left as they come from openf1 (UTC) when there is no tz parameter
+func ParseTimezoneFromRequest(r *http.Request) (TimezoneConfig, error) {
+	tzStr := r.URL.Query().Get("tz")
+
+	config := TimezoneConfig{}
+	if tzStr == "" {
+		return config, nil
 	}
-	response, urlErr := http.Get(fmt.Sprintf("%s/sessions", BaseUrl))
-	if urlErr != nil {
-		fmt.Println("Error fetching sessions: ", urlErr)
-		return nil, fmt.Errorf("error fetching sessions %d", urlErr)
+	if tzStr == "local" {
+		config.Local = true
+		return config, nil
+	}
+	location, err := time.LoadLocation(tzStr)
+	if err != nil {
+		return config, fmt.Errorf("invalid tz parameter")
 	}
-	// always make sure to close the response body
-	defer response.Body.Close()
+	config.Location = location
+	return config, nil
+}
 
-	if response.StatusCode != http.StatusOK {
-		log.Fatalf("API Response Status Code: %d", response.StatusCode)
-		return nil, fmt.Errorf("Response Status Code: %d", response.StatusCode)
+// LocationFor returns the zone to use for a session's timestamps, nil means leave them untouched
+func (c TimezoneConfig) LocationFor(s Session) *time.Location {
+	if c.Local {
+		location, err := s.GmtLocation()
+		if err != nil {
+			// without an offset the best we can do is UTC
+			return time.UTC
+		}
+		return location
 	}
+	return c.Location
+}
 
-	responseData, responseErr := io.ReadAll(response.Body)
-	if responseErr != nil {
-		fmt.Println("Error reading response body: ", responseErr)
-		return nil, fmt.Errorf("error reading response body: %s", responseErr)
+// Convert returns t in the given zone, or unchanged if there is no zone
+func Convert(t time.Time, location *time.Location) time.Time {
+	if location == nil || t.IsZero() {
+		return t
 	}
+	return t.In(location)
+}
 
-	var sessions []Session
-	err := json.Unmarshal(responseData, &sessions)
-	if err != nil {
-		fmt.Println("Error unmarshalling response data: ", err)
-		return nil, fmt.Errorf("error unmarshalling response data: %s", err)
+// Apply converts a session's timestamps to the configured zone
+func (c TimezoneConfig) Apply(s Session) Session {
+	location := c.LocationFor(s)
+	s.DateStart = Convert(s.DateStart, location)
+	s.DateEnd = Convert(s.DateEnd, location)
+	return s
+}
+
+func (c TimezoneConfig) ApplyAll(sessions []Session) []Session {
+	converted := make([]Session, len(sessions))
+	for i, s := range sessions {
+		converted[i] = c.Apply(s)
+	}
+	return converted
+}
+
+// publishSessions makes the sessions a request was answered with available to hub subscribers on sessions/{key}
+func (h *Handler) publishSessions(sessions []Session) {
+	if h.Hub == nil {
+		return
+	}
+	for _, s := range sessions {
+		topic := fmt.Sprintf("sessions/%d", s.SessionKey)
+		if !h.Hub.Subscribed(topic) {
+			continue
+		}
+		payload, err := json.Marshal(s)
+		if err != nil {
+			continue
+		}
+		h.Hub.Publish(topic, payload)
 	}
-	return sessions, nil
 }
 
 func FormatSessions(sessions []Session) string {
@@ -139,99 +190,103 @@ func parseIntParam(param string, defaultValue int) (int, error) {
 	return strconv.Atoi(param)
 }
 
+// Logger is satisfied by *slog.Logger
+type Logger interface {
+	Error(msg string, args ...any)
+}
+
+// Handler serves sessions and their keys from Source, which keeps the list for the configured sessions ttl
+// the sessions a request is answered with are published to Hub when it is set
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Hub    *pubsub.Hub
+	Logger Logger
+}
+
+// sessions reads the list through the handler's source
+// openf1 answers an empty list with a 404, which these routes serve as a list of none
+func (h *Handler) sessions(ctx context.Context) ([]Session, error) {
+	sessions, err := h.Source.Sessions(ctx)
+	if errors.Is(err, openf1.ErrNoResults) {
+		return []Session{}, nil
+	}
+	if err != nil {
+		h.Logger.Error("fetching sessions", "error", err)
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+	return sessions, nil
+}
+
 // Session Handlers
-func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SessionsHandler(w http.ResponseWriter, r *http.Request) {
 	// extract optional parameters skip & limit
 	// these are optional parameters
-	switch r.Method {
-	case http.MethodGet:
-		handleGetSessions(w, r)
-	default:
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
-	}
+	h.handleGetSessions(w, r)
 }
 
-func SessionHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SessionHandler(w http.ResponseWriter, r *http.Request) {
 	// extract the sessionKey from the URL path
-	id, err := strconv.Atoi(r.URL.Path[len("/sessions/"):])
+	id, err := strconv.Atoi(r.PathValue("key"))
 	if err != nil {
 		http.Error(w, "Invalid session Key Id", http.StatusBadRequest)
 		return
 	}
-	switch r.Method {
-	case http.MethodGet:
-		handleGetSession(w, r, id)
-	default:
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	TzConfig, err := ParseTimezoneFromRequest(r)
+	if err != nil {
+		http.Error(w, "invalid tz parameter", http.StatusBadRequest)
+		return
 	}
+	h.handleGetSession(w, r, id, TzConfig)
 }
 
-func SessionKeyHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SessionKeyHandler(w http.ResponseWriter, r *http.Request) {
 	// extract the keys only handler from the
-	switch r.Method {
-	case http.MethodGet:
-		handleGetSessionKeys(w, r)
-	default:
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
-	}
+	h.handleGetSessionKeys(w, r)
 }
 
 // business logic of the handler methods
-func handleGetSessions(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Handling our /sessions gets")
+func (h *Handler) handleGetSessions(w http.ResponseWriter, r *http.Request) {
 	// parse the query parameters
 	PageConfig, err := ParsePaginationFromRequest(r)
 	if err != nil {
 		http.Error(w, "invalid query parameters", http.StatusBadRequest)
 		return
 	}
+	TzConfig, err := ParseTimezoneFromRequest(r)
+	if err != nil {
+		http.Error(w, "invalid tz parameter", http.StatusBadRequest)
+		return
+	}
 	if PageConfig.HasPagination {
-		handleGetSessionsWithPagination(w, r, PageConfig.Skip, PageConfig.Limit)
+		h.handleGetSessionsWithPagination(w, r, PageConfig.Skip, PageConfig.Limit, TzConfig)
 		return
 	}
 	// if the skip and limit are empty strings then we just return without pagination
-	handleSessionsNoPagination(w, r)
+	h.handleSessionsNoPagination(w, r, TzConfig)
 }
 
-func handleSessionsNoPagination(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
+func (h *Handler) handleSessionsNoPagination(w http.ResponseWriter, r *http.Request, tz TimezoneConfig) {
 	// fetch our session data from our openf1 api
-	openF1Url := os.Getenv("OPENF1_API_URL")
-
-	sessions, err := fetchSessions(openF1Url)
+	sessions, err := h.sessions(r.Context())
 	if err != nil {
 		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
 		return
 	}
+	h.publishSessions(sessions)
 
-	// // An optional operation is to cache the session info
-	// sessionsMu.Lock()
-	// // defer sessionsMu.Unlock()
-	// for _, session := range sessions {
-	// 	sessionsCache[session.SessionKey] = session
-	// }
-	// sessionsMu.Unlock()
 	// encode and send response
-	if err := json.NewEncoder(w).Encode(sessions); err != nil {
-		log.Printf("Error encoding sessions: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
-		return
-	}
+	response.Write(w, r, tz.ApplyAll(sessions))
 }
 
-func handleGetSessionsWithPagination(w http.ResponseWriter, r *http.Request, skip int, limit int) {
+func (h *Handler) handleGetSessionsWithPagination(w http.ResponseWriter, r *http.Request, skip int, limit int, tz TimezoneConfig) {
 	// this function is responsible for fetching sessions with pagination
-	log.Print("Getting sessions with pagination")
 	if skip < 0 || limit < 0 {
 		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-
-	openF1Url := os.Getenv("OPENF1_API_URL")
-	sessions, err := fetchSessions(openF1Url)
+	sessions, err := h.sessions(r.Context())
 	if err != nil {
 		http.Error(w, "Error fetching session data", http.StatusInternalServerError)
 		return
@@ -245,21 +300,15 @@ func handleGetSessionsWithPagination(w http.ResponseWriter, r *http.Request, ski
 	// limit is the number we obtain
 	startIndex := skip
 	endIndex := min(startIndex+limit, len(sessions))
-	sessions = sessions[startIndex:endIndex]
+	h.publishSessions(sessions[startIndex:endIndex])
+	sessions = tz.ApplyAll(sessions[startIndex:endIndex])
 
-	if err := json.NewEncoder(w).Encode(sessions); err != nil {
-		log.Print("Error encoding sessions: ", err)
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
-		return
-	}
+	response.Write(w, r, sessions)
 }
 
-func handleGetSession(w http.ResponseWriter, r *http.Request, id int) {
-	fmt.Println("Handling our /sessions/:id gets")
+func (h *Handler) handleGetSession(w http.ResponseWriter, r *http.Request, id int, tz TimezoneConfig) {
 	// fetch the sessions
-	openF1Url := os.Getenv("OPENF1_API_URL")
-
-	sessions, err := fetchSessions(openF1Url)
+	sessions, err := h.sessions(r.Context())
 	if err != nil {
 		http.Error(w, "Error fetching Sessions", http.StatusInternalServerError)
 		return
@@ -270,38 +319,39 @@ func handleGetSession(w http.ResponseWriter, r *http.Request, id int) {
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
+	h.publishSessions([]Session{*session})
 
 	// encode and send a response
-	if err := json.NewEncoder(w).Encode(session); err != nil {
-		log.Printf("Error encoding session: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
-	}
+	response.Write(w, r, tz.Apply(*session))
 }
 
-func handleGetSessionKeys(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleGetSessionKeys(w http.ResponseWriter, r *http.Request) {
 	// here we provide the keys of the sessions, and we provide only that
-	log.Print("fetching sessions/keys/ \n")
 	PageConfig, err := ParsePaginationFromRequest(r)
 	if err != nil {
 		http.Error(w, "invalid query parameters", http.StatusBadRequest)
+		return
+	}
+	TzConfig, err := ParseTimezoneFromRequest(r)
+	if err != nil {
+		http.Error(w, "invalid tz parameter", http.StatusBadRequest)
+		return
 	}
 	if PageConfig.HasPagination {
-		handleSessionKeysWithPagination(w, r, PageConfig.Skip, PageConfig.Limit)
+		h.handleSessionKeysWithPagination(w, r, PageConfig.Skip, PageConfig.Limit, TzConfig)
 		return
 	}
-	handleSessionKeysNoPagination(w, r)
+	h.handleSessionKeysNoPagination(w, r, TzConfig)
 }
 
-func handleSessionKeysWithPagination(w http.ResponseWriter, r *http.Request, skip int, limit int) {
+func (h *Handler) handleSessionKeysWithPagination(w http.ResponseWriter, r *http.Request, skip int, limit int, tz TimezoneConfig) {
 	// fetch session data and return only the keys
-	log.Print("getting sessions/keys with pagination \n")
 	if skip < 0 || limit < 0 {
 		http.Error(w, "invalid pagination parameters", http.StatusBadRequest)
+		return
 	}
-	w.Header().Set("Content-Type", "application/json")
 
-	openF1Url := os.Getenv("OPENF1_API_URL")
-	sessions, err := fetchSessions(openF1Url)
+	sessions, err := h.sessions(r.Context())
 	if err != nil {
 		http.Error(w, "Error fetching session data", http.StatusInternalServerError)
 		return
@@ -312,49 +362,29 @@ func handleSessionKeysWithPagination(w http.ResponseWriter, r *http.Request, ski
 	}
 	startIndex := skip
 	endIndex := min(startIndex+limit, len(sessions))
-	sessions = sessions[startIndex:endIndex]
-	var keysOnlyList []SessionKeysOnly
-	for _, s := range sessions {
-		keysOnlyList = append(keysOnlyList, SessionKeysOnly{
-			SessionKey:       s.SessionKey,
-			CircuitKey:       s.CircuitKey,
-			MeetingKey:       s.MeetingKey,
-			CircuitShortName: s.CircuitShortName,
-			DateRange:        s.DateStart + " - " + s.DateEnd,
-		})
-	}
-
-	if err := json.NewEncoder(w).Encode(keysOnlyList); err != nil {
-		log.Printf("Error encoding sessions: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
-		return
-	}
+	response.Write(w, r, keysOnly(tz.ApplyAll(sessions[startIndex:endIndex])))
 }
 
-func handleSessionKeysNoPagination(w http.ResponseWriter, r *http.Request) {
-	log.Print("fetching sessions/keys/ without pagination")
-	openF1Url := os.Getenv("OPENF1_API_URL")
-
-	sessions, err := fetchSessions(openF1Url)
+func (h *Handler) handleSessionKeysNoPagination(w http.ResponseWriter, r *http.Request, tz TimezoneConfig) {
+	sessions, err := h.sessions(r.Context())
 	if err != nil {
 		http.Error(w, "error fetching sessions", http.StatusInternalServerError)
 		return
 	}
+	response.Write(w, r, keysOnly(tz.ApplyAll(sessions)))
+}
 
-	var keysOnlyList []SessionKeysOnly
+// keysOnly slims sessions down to their keys, an empty list stays a list rather than null
+func keysOnly(sessions []Session) []SessionKeysOnly {
+	keysOnlyList := []SessionKeysOnly{}
 	for _, s := range sessions {
 		keysOnlyList = append(keysOnlyList, SessionKeysOnly{
 			SessionKey:       s.SessionKey,
 			CircuitKey:       s.CircuitKey,
 			MeetingKey:       s.MeetingKey,
 			CircuitShortName: s.CircuitShortName,
-			DateRange:        s.DateStart + " - " + s.DateEnd,
+			DateRange:        DateRange{Start: s.DateStart, End: s.DateEnd},
 		})
 	}
-
-	if err := json.NewEncoder(w).Encode(keysOnlyList); err != nil {
-		log.Printf("Error encoding session keys: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
-		return
-	}
+	return keysOnlyList
 }
@@ -0,0 +1,548 @@
+package session_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"telem-api-server/api/middleware"
+	"telem-api-server/api/resource/session"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/pubsub"
+	"telem-api-server/store"
+)
+
+var testSessions = []session.Session{
+	{SessionKey: 9158, MeetingKey: 1219, CircuitKey: 61, CircuitShortName: "Singapore", SessionName: "Race", SessionType: "Race",
+		DateStart: time.Date(2023, 9, 17, 12, 0, 0, 0, time.UTC), DateEnd: time.Date(2023, 9, 17, 14, 0, 0, 0, time.UTC), GmtOffset: "08:00:00"},
+	{SessionKey: 9161, MeetingKey: 1220, CircuitKey: 46, CircuitShortName: "Suzuka", SessionName: "Race", SessionType: "Race",
+		DateStart: time.Date(2023, 9, 24, 5, 0, 0, 0, time.UTC), DateEnd: time.Date(2023, 9, 24, 7, 0, 0, 0, time.UTC), GmtOffset: "09:00:00"},
+	{SessionKey: 9165, MeetingKey: 1221, CircuitKey: 150, CircuitShortName: "Lusail", SessionName: "Sprint", SessionType: "Race",
+		DateStart: time.Date(2023, 10, 7, 17, 30, 0, 0, time.UTC), DateEnd: time.Date(2023, 10, 7, 18, 30, 0, 0, time.UTC), GmtOffset: "03:00:00"},
+}
+
+// fakeOpenF1 serves /sessions the way openf1 does, answering with status when it is set
+// or with a 503 for the next failures calls, and not answering at all while hang is set
+type fakeOpenF1 struct {
+	*httptest.Server
+	calls    atomic.Int64
+	status   atomic.Int64
+	failures atomic.Int64
+	hang     atomic.Bool
+	sessions []session.Session
+}
+
+func newFakeOpenF1(t *testing.T, sessions []session.Session) *fakeOpenF1 {
+	t.Helper()
+	f := &fakeOpenF1{sessions: sessions}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.calls.Add(1)
+		if r.URL.Path != "/sessions" {
+			http.NotFound(w, r)
+			return
+		}
+		if f.hang.Load() {
+			<-r.Context().Done()
+			return
+		}
+		if status := int(f.status.Load()); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if f.failures.Add(-1) >= 0 {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f.sessions)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type memoryCache struct {
+	mu        sync.Mutex
+	sessions  []session.Session
+	fetchedAt time.Time
+	err       error
+}
+
+func (c *memoryCache) Load(ctx context.Context) ([]session.Session, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessions, c.fetchedAt, c.err
+}
+
+func (c *memoryCache) Save(ctx context.Context, sessions []session.Session, fetchedAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions, c.fetchedAt = sessions, fetchedAt
+	return nil
+}
+
+type harness struct {
+	upstream *fakeOpenF1
+	cache    *memoryCache
+	clock    *fakeClock
+	client   *openf1.Client
+	hub      *pubsub.Hub
+	server   *httptest.Server
+	// header is the headers of the last response
+	header http.Header
+}
+
+// newHarness serves the session routes the way the router registers them, backed by a fake openf1
+// cached is false for a handler without a cache, where every request goes upstream
+func newHarness(t *testing.T, cached bool) *harness {
+	t.Helper()
+	h := &harness{
+		upstream: newFakeOpenF1(t, testSessions),
+		clock:    &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	cfg := config.Default()
+	cfg.OpenF1.URL = h.upstream.URL
+	// retries back off in milliseconds and each harness has its own breaker, so one test can't open it for the next
+	h.client = openf1.NewClient(h.upstream.URL)
+	h.client.Retry = openf1.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Timeout: 5 * time.Second}
+	h.client.Breaker = openf1.NewBreaker(5, time.Minute)
+	h.hub = pubsub.NewHub()
+	source := &store.Source{Client: h.client, Clock: h.clock, TTL: cfg.Store.SessionsTTL}
+	if cached {
+		h.cache = &memoryCache{}
+		source.SessionCache = h.cache
+	}
+	handler := &session.Handler{
+		Config: cfg,
+		Source: source,
+		Hub:    h.hub,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", handler.SessionsHandler)
+	mux.HandleFunc("GET /sessions/keys", handler.SessionKeyHandler)
+	mux.HandleFunc("GET /sessions/{key}", handler.SessionHandler)
+	h.server = httptest.NewServer(middleware.Stale(mux))
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+// get requests path and decodes a 200 response into out, out is left alone for any other status
+func (h *harness) get(t *testing.T, path string, out any) int {
+	t.Helper()
+	response, err := http.Get(h.server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer response.Body.Close()
+	h.header = response.Header
+	if response.StatusCode == http.StatusOK && out != nil {
+		if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+			t.Fatalf("decoding GET %s: %v", path, err)
+		}
+	}
+	return response.StatusCode
+}
+
+func sessionKeys(sessions []session.Session) []int {
+	keys := []int{}
+	for _, s := range sessions {
+		keys = append(keys, s.SessionKey)
+	}
+	return keys
+}
+
+func equalKeys(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSessionsPagination(t *testing.T) {
+	h := newHarness(t, false)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		keys   []int
+	}{
+		{"no pagination", "", http.StatusOK, []int{9158, 9161, 9165}},
+		{"skip and limit", "?skip=1&limit=1", http.StatusOK, []int{9161}},
+		{"limit only", "?limit=2", http.StatusOK, []int{9158, 9161}},
+		{"skip only", "?skip=1", http.StatusOK, []int{9161, 9165}},
+		{"limit past the end", "?skip=1&limit=10", http.StatusOK, []int{9161, 9165}},
+		{"skip to the end", "?skip=3&limit=1", http.StatusOK, []int{}},
+		{"limit zero", "?limit=0", http.StatusOK, []int{}},
+		{"skip past the end", "?skip=4&limit=1", http.StatusBadRequest, nil},
+		{"negative skip", "?skip=-1&limit=1", http.StatusBadRequest, nil},
+		{"negative limit", "?limit=-1", http.StatusBadRequest, nil},
+		{"skip not a number", "?skip=one", http.StatusBadRequest, nil},
+		{"limit not a number", "?limit=1.5", http.StatusBadRequest, nil},
+		{"unknown tz", "?tz=Mars/Olympus_Mons", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sessions []session.Session
+			status := h.get(t, "/sessions"+tt.query, &sessions)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.keys != nil && !equalKeys(sessionKeys(sessions), tt.keys) {
+				t.Errorf("session keys = %v, want %v", sessionKeys(sessions), tt.keys)
+			}
+		})
+	}
+}
+
+func TestSessionKeysPagination(t *testing.T) {
+	h := newHarness(t, false)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		keys   []int
+	}{
+		{"no pagination", "", http.StatusOK, []int{9158, 9161, 9165}},
+		{"skip and limit", "?skip=2&limit=5", http.StatusOK, []int{9165}},
+		{"skip to the end", "?skip=3&limit=5", http.StatusOK, []int{}},
+		{"skip only", "?skip=1", http.StatusOK, []int{9161, 9165}},
+		{"skip past the end", "?skip=9&limit=1", http.StatusBadRequest, nil},
+		{"negative limit", "?limit=-5", http.StatusBadRequest, nil},
+		{"skip not a number", "?skip=x", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys []session.SessionKeysOnly
+			status := h.get(t, "/sessions/keys"+tt.query, &keys)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.keys == nil {
+				return
+			}
+			got := []int{}
+			for _, k := range keys {
+				got = append(got, k.SessionKey)
+			}
+			if !equalKeys(got, tt.keys) {
+				t.Errorf("session keys = %v, want %v", got, tt.keys)
+			}
+		})
+	}
+}
+
+func TestSessionKeysDateRange(t *testing.T) {
+	h := newHarness(t, false)
+
+	var keys []session.SessionKeysOnly
+	if status := h.get(t, "/sessions/keys?limit=1&tz=local", &keys); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("got %d keys, want 1", len(keys))
+	}
+	// Singapore is 8 hours ahead of UTC
+	if _, offset := keys[0].DateRange.Start.Zone(); offset != 8*60*60 {
+		t.Errorf("start offset = %d, want %d", offset, 8*60*60)
+	}
+	if !keys[0].DateRange.Start.Equal(testSessions[0].DateStart) {
+		t.Errorf("start = %v, want %v", keys[0].DateRange.Start, testSessions[0].DateStart)
+	}
+}
+
+func TestSession(t *testing.T) {
+	h := newHarness(t, false)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"found", "/sessions/9161", http.StatusOK},
+		{"not found", "/sessions/1", http.StatusNotFound},
+		{"key not a number", "/sessions/latest", http.StatusBadRequest},
+		{"unknown tz", "/sessions/9161?tz=nowhere", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s session.Session
+			status := h.get(t, tt.path, &s)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if status == http.StatusOK && s.SessionKey != 9161 {
+				t.Errorf("session key = %d, want 9161", s.SessionKey)
+			}
+		})
+	}
+}
+
+// published drains the topics sub has been sent, waiting briefly for each
+func published(t *testing.T, sub *pubsub.Subscriber) []string {
+	t.Helper()
+	var topics []string
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		message, err := sub.Next(ctx)
+		cancel()
+		if err != nil {
+			return topics
+		}
+		topics = append(topics, message.Topic)
+	}
+}
+
+func TestSessionsPublished(t *testing.T) {
+	h := newHarness(t, false)
+	sub, err := h.hub.Subscribe(0, "sessions/+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		topics []string
+	}{
+		{"only the page", "/sessions?skip=1&limit=1", []string{"sessions/9161"}},
+		{"skip only", "/sessions?skip=2", []string{"sessions/9165"}},
+		{"skip past the end", "/sessions?skip=4&limit=1", nil},
+		{"negative limit", "/sessions?limit=-1", nil},
+		{"one session", "/sessions/9158", []string{"sessions/9158"}},
+		{"unknown session", "/sessions/1", nil},
+		{"keys", "/sessions/keys", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.get(t, tt.path, nil)
+			if got := published(t, sub); !equalTopics(got, tt.topics) {
+				t.Errorf("published %v, want %v", got, tt.topics)
+			}
+		})
+	}
+}
+
+func equalTopics(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream int
+		path     string
+		status   int
+	}{
+		{"server error", http.StatusInternalServerError, "/sessions", http.StatusInternalServerError},
+		{"rate limited", http.StatusTooManyRequests, "/sessions?skip=0&limit=1", http.StatusInternalServerError},
+		{"bad gateway for keys", http.StatusBadGateway, "/sessions/keys", http.StatusInternalServerError},
+		{"server error for a session", http.StatusServiceUnavailable, "/sessions/9158", http.StatusInternalServerError},
+		// openf1 answers an empty result with a 404
+		{"no sessions", http.StatusNotFound, "/sessions", http.StatusOK},
+		{"no sessions for a session", http.StatusNotFound, "/sessions/9158", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, false)
+			h.upstream.status.Store(int64(tt.upstream))
+			var sessions []session.Session
+			if status := h.get(t, tt.path, nil); status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.status == http.StatusOK {
+				h.get(t, tt.path, &sessions)
+				if len(sessions) != 0 {
+					t.Errorf("got %d sessions, want none", len(sessions))
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamRetries(t *testing.T) {
+	t.Run("transient errors are retried", func(t *testing.T) {
+		h := newHarness(t, false)
+		h.upstream.failures.Store(2)
+		if status := h.get(t, "/sessions", nil); status != http.StatusOK {
+			t.Fatalf("status = %d, want 200", status)
+		}
+		if calls := h.upstream.calls.Load(); calls != 3 {
+			t.Errorf("openf1 calls = %d, want 3", calls)
+		}
+	})
+
+	t.Run("client errors are not retried", func(t *testing.T) {
+		h := newHarness(t, false)
+		h.upstream.status.Store(http.StatusBadRequest)
+		h.get(t, "/sessions", nil)
+		if calls := h.upstream.calls.Load(); calls != 1 {
+			t.Errorf("openf1 calls = %d, want 1", calls)
+		}
+	})
+
+	t.Run("open breaker stops calling openf1", func(t *testing.T) {
+		h := newHarness(t, false)
+		h.upstream.status.Store(http.StatusInternalServerError)
+		// two requests of three attempts each are enough to open it
+		h.get(t, "/sessions", nil)
+		h.get(t, "/sessions", nil)
+		if state := h.client.Breaker.State(); state != openf1.BreakerOpen {
+			t.Fatalf("breaker = %s, want open", state)
+		}
+		calls := h.upstream.calls.Load()
+		if status := h.get(t, "/sessions", nil); status != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", status)
+		}
+		if after := h.upstream.calls.Load(); after != calls {
+			t.Errorf("openf1 called %d times with the breaker open", after-calls)
+		}
+	})
+}
+
+func TestBreakerIgnoresCancelledRequests(t *testing.T) {
+	h := newHarness(t, false)
+	h.upstream.status.Store(http.StatusInternalServerError)
+	// three failed attempts, two short of opening
+	h.get(t, "/sessions", nil)
+
+	// a client giving up while openf1 is slow doesn't say openf1 is back
+	h.upstream.hang.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, h.server.URL+"/sessions", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err := http.DefaultClient.Do(request); err == nil {
+		response.Body.Close()
+		t.Fatal("request finished, want it cancelled")
+	}
+	// the handler's call to openf1 ends once it sees the client has gone
+	deadline := time.Now().Add(time.Second)
+	for h.upstream.calls.Load() < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	h.upstream.hang.Store(false)
+
+	if state := h.client.Breaker.State(); state != openf1.BreakerClosed {
+		t.Fatalf("breaker = %s after the cancelled request, want closed", state)
+	}
+	h.get(t, "/sessions", nil)
+	if state := h.client.Breaker.State(); state != openf1.BreakerOpen {
+		t.Errorf("breaker = %s, want open after five failures either side of a cancelled request", state)
+	}
+}
+
+func TestUpstreamUnreachable(t *testing.T) {
+	h := newHarness(t, false)
+	h.upstream.Close()
+	if status := h.get(t, "/sessions", nil); status != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", status)
+	}
+}
+
+func TestCache(t *testing.T) {
+	t.Run("fresh list is served from the cache", func(t *testing.T) {
+		h := newHarness(t, true)
+		for range 3 {
+			if status := h.get(t, "/sessions", nil); status != http.StatusOK {
+				t.Fatalf("status = %d, want 200", status)
+			}
+		}
+		if calls := h.upstream.calls.Load(); calls != 1 {
+			t.Errorf("openf1 calls = %d, want 1", calls)
+		}
+	})
+
+	t.Run("expired list is fetched again", func(t *testing.T) {
+		h := newHarness(t, true)
+		h.get(t, "/sessions", nil)
+		h.clock.Advance(config.Default().Store.SessionsTTL + time.Second)
+		h.get(t, "/sessions", nil)
+		if calls := h.upstream.calls.Load(); calls != 2 {
+			t.Errorf("openf1 calls = %d, want 2", calls)
+		}
+		if fetchedAt := h.cache.fetchedAt; !fetchedAt.Equal(h.clock.Now()) {
+			t.Errorf("cached at %v, want %v", fetchedAt, h.clock.Now())
+		}
+	})
+
+	t.Run("stale list is served while openf1 is down", func(t *testing.T) {
+		h := newHarness(t, true)
+		h.get(t, "/sessions", nil)
+		h.clock.Advance(24 * time.Hour)
+		h.upstream.status.Store(http.StatusInternalServerError)
+
+		var sessions []session.Session
+		if status := h.get(t, "/sessions", &sessions); status != http.StatusOK {
+			t.Fatalf("status = %d, want 200", status)
+		}
+		if !equalKeys(sessionKeys(sessions), sessionKeys(testSessions)) {
+			t.Errorf("session keys = %v, want %v", sessionKeys(sessions), sessionKeys(testSessions))
+		}
+		if stale := h.header.Get(middleware.StaleHeader); stale != "true" {
+			t.Errorf("%s = %q, want true", middleware.StaleHeader, stale)
+		}
+		if warning := h.header.Get("Warning"); warning == "" {
+			t.Error("no Warning header on a stale response")
+		}
+		if status := h.get(t, "/sessions/9165", nil); status != http.StatusOK {
+			t.Errorf("session status = %d, want 200", status)
+		}
+	})
+
+	t.Run("cache errors fall back to openf1", func(t *testing.T) {
+		h := newHarness(t, true)
+		h.cache.err = errors.New("disk on fire")
+		if status := h.get(t, "/sessions/9158", nil); status != http.StatusOK {
+			t.Fatalf("status = %d, want 200", status)
+		}
+		if calls := h.upstream.calls.Load(); calls != 1 {
+			t.Errorf("openf1 calls = %d, want 1", calls)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+// package for the car telemetry of a session
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"telem-api-server/api/resource/session"
+	"telem-api-server/api/response"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/pubsub"
+	"telem-api-server/store"
+)
+
+// Helper Functions
+
+// fetchCarData returns the car data of one driver, or of every driver in the session when driverNumber is 0
+// openf1 only serves car data a driver at a time for a whole session, so every driver is fetched separately
+func fetchCarData(ctx context.Context, source *store.Source, sessionKey int, driverNumber int) ([]openf1.CarData, error) {
+	driverNumbers := []int{driverNumber}
+	if driverNumber == 0 {
+		drivers, err := source.Drivers(ctx, sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		driverNumbers = driverNumbers[:0]
+		for _, d := range drivers {
+			driverNumbers = append(driverNumbers, d.DriverNumber)
+		}
+	}
+
+	samples := []openf1.CarData{}
+	for _, n := range driverNumbers {
+		rows, err := source.CarData(ctx, sessionKey, n)
+		if err != nil && !errors.Is(err, openf1.ErrNoResults) {
+			return nil, err
+		}
+		samples = append(samples, rows...)
+	}
+	if driverNumber == 0 {
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].Date.Before(samples[j].Date)
+		})
+	}
+	return samples, nil
+}
+
+// publishCarData sends each sample to sessions/{key}/car_data/{driver}, the topics the live feed uses
+// a session is tens of thousands of samples a driver so drivers nobody subscribed to aren't encoded at all
+func publishCarData(hub *pubsub.Hub, sessionKey int, samples []openf1.CarData) {
+	if hub == nil {
+		return
+	}
+	// the topic of each driver, empty for drivers without a subscriber
+	topics := map[int]string{}
+	for _, sample := range samples {
+		topic, seen := topics[sample.DriverNumber]
+		if !seen {
+			topic = fmt.Sprintf("sessions/%d/car_data/%d", sessionKey, sample.DriverNumber)
+			if !hub.Subscribed(topic) {
+				topic = ""
+			}
+			topics[sample.DriverNumber] = topic
+		}
+		if topic == "" {
+			continue
+		}
+		payload, err := json.Marshal(sample)
+		if err != nil {
+			continue
+		}
+		hub.Publish(topic, payload)
+	}
+}
+
+// Handler serves car telemetry
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Hub    *pubsub.Hub
+	Logger *slog.Logger
+}
+
+// Telemetry Handlers
+func (h *Handler) TelemetryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("key"))
+	if err != nil {
+		http.Error(w, "Invalid session Key Id", http.StatusBadRequest)
+		return
+	}
+	h.handleGetTelemetry(w, r, id)
+}
+
+// business logic of the handler methods
+func (h *Handler) handleGetTelemetry(w http.ResponseWriter, r *http.Request, id int) {
+	driverNumber := 0
+	if param := r.URL.Query().Get("driver_number"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid driver_number", http.StatusBadRequest)
+			return
+		}
+		driverNumber = n
+	}
+
+	sessions, err := h.Source.Sessions(r.Context())
+	if err != nil {
+		h.Logger.Error("fetching sessions", "error", err)
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	if session.FindSessionById(sessions, id) == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	samples, err := fetchCarData(r.Context(), h.Source, id, driverNumber)
+	if errors.Is(err, openf1.ErrNoResults) {
+		http.Error(w, "No drivers found for this session", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error fetching telemetry", http.StatusInternalServerError)
+		return
+	}
+	publishCarData(h.Hub, id, samples)
+	response.Write(w, r, samples)
+}
@@ -0,0 +1,400 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"telem-api-server/api/resource/live"
+	"telem-api-server/api/resource/session"
+	"telem-api-server/config"
+	"telem-api-server/ingest"
+	"telem-api-server/store"
+)
+
+const (
+	// messages are collected and sent together at this interval
+	batchInterval = 250 * time.Millisecond
+	// a client that has this many messages waiting loses the oldest ones, unless the handler sets MaxPending
+	maxPending   = 1000
+	pingInterval = 30 * time.Second
+	pongWait     = 60 * time.Second
+	writeWait    = 10 * time.Second
+	// subscribe/unsubscribe requests are small, anything bigger is a misbehaving client
+	maxRequestSize = 64 * 1024
+)
+
+// Request is sent by the client to change its subscriptions
+type Request struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics"`
+}
+
+// Response is anything sent to the client, Type is one of subscribed, unsubscribed, batch, end or error
+type Response struct {
+	Type     string    `json:"type"`
+	Topics   []string  `json:"topics,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+	// number of messages thrown away since the last batch because the client fell behind
+	Dropped int    `json:"dropped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Message is the rows of a single live event that matched a topic
+type Message struct {
+	Topic   string            `json:"topic"`
+	EventID int64             `json:"event_id"`
+	Data    []json.RawMessage `json:"data"`
+}
+
+// Handler upgrades websocket clients, origins outside the configured list are turned away
+type Handler struct {
+	Config *config.Config
+	Source *store.Source
+	Feeds  *ingest.Manager
+	Logger *slog.Logger
+	// MaxPending is how many messages a client can have waiting before it loses the oldest, zero is maxPending
+	MaxPending int
+
+	// connected is every open connection, so they can be told to go elsewhere when the server shuts down
+	connectedMu sync.Mutex
+	connected   map[*client]struct{}
+}
+
+// checkOrigin allows same-origin connections plus anything in the configured allowed origins
+func (h *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "http://"+r.Host || origin == "https://"+r.Host {
+		return true
+	}
+	for _, allowed := range h.Config.WebSocket.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// client is a single websocket connection and its subscriptions
+// every session it has topics in gets one subscription to the shared live feed
+type client struct {
+	conn    *websocket.Conn
+	ctx     context.Context
+	source  *store.Source
+	manager *ingest.Manager
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	topics  map[string]Topic
+	feeds   map[int]*ingest.Subscription
+	pending []Message
+	dropped int
+	replies []Response
+	closed  bool
+	// maxPending is the handler's MaxPending
+	maxPending int
+}
+
+// CloseAll sends every client connected to h a going away close and drops its connection
+// hijacked connections aren't waited for by the server's shutdown, this ends them so clients reconnect elsewhere
+func (h *Handler) CloseAll() {
+	h.connectedMu.Lock()
+	defer h.connectedMu.Unlock()
+	for c := range h.connected {
+		// WriteControl is the one write gorilla allows alongside the write loop
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(writeWait))
+		// closing the connection ends the read loop, which cleans up the client
+		c.conn.Close()
+	}
+}
+
+// WS Handlers
+func (h *Handler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	h.handleWebSocket(w, r)
+}
+
+// business logic of the handler methods
+func (h *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already written an error response
+		h.Logger.Error("upgrading websocket", "error", err)
+		return
+	}
+	h.Logger.Info("websocket client connected", "remote_addr", r.RemoteAddr)
+
+	c := &client{
+		conn:    conn,
+		ctx:     r.Context(),
+		source:  h.Source,
+		manager: h.Feeds,
+		logger:  h.Logger,
+		topics:  map[string]Topic{},
+		feeds:   map[int]*ingest.Subscription{},
+
+		maxPending: h.MaxPending,
+	}
+	if c.maxPending <= 0 {
+		c.maxPending = maxPending
+	}
+	h.connectedMu.Lock()
+	if h.connected == nil {
+		h.connected = map[*client]struct{}{}
+	}
+	h.connected[c] = struct{}{}
+	h.connectedMu.Unlock()
+
+	done := make(chan struct{})
+	go c.writeLoop(done)
+	c.readLoop()
+	close(done)
+	c.close()
+
+	h.connectedMu.Lock()
+	delete(h.connected, c)
+	h.connectedMu.Unlock()
+	h.Logger.Info("websocket client disconnected", "remote_addr", r.RemoteAddr)
+}
+
+func (c *client) readLoop() {
+	c.conn.SetReadLimit(maxRequestSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var request Request
+		if err := json.Unmarshal(data, &request); err != nil {
+			c.reply(Response{Type: "error", Error: "invalid request"})
+			continue
+		}
+		switch request.Action {
+		case "subscribe":
+			c.subscribe(request.Topics)
+		case "unsubscribe":
+			c.unsubscribe(request.Topics)
+		default:
+			c.reply(Response{Type: "error", Error: fmt.Sprintf("unknown action %q", request.Action)})
+		}
+	}
+}
+
+// writeLoop is the only goroutine that writes to the connection, gorilla doesn't allow concurrent writers
+func (c *client) writeLoop(done chan struct{}) {
+	batch := time.NewTicker(batchInterval)
+	ping := time.NewTicker(pingInterval)
+	defer batch.Stop()
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+			return
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-batch.C:
+			for _, response := range c.drain() {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteJSON(response); err != nil {
+					// closing the connection also ends the read loop
+					c.conn.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+// drain takes everything waiting to be sent, replies first so a subscribe is acknowledged before its data
+func (c *client) drain() []Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	responses := c.replies
+	c.replies = nil
+	if len(c.pending) > 0 || c.dropped > 0 {
+		responses = append(responses, Response{Type: "batch", Messages: c.pending, Dropped: c.dropped})
+		c.pending = nil
+		c.dropped = 0
+	}
+	return responses
+}
+
+func (c *client) reply(response Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replies = append(c.replies, response)
+}
+
+func (c *client) subscribe(names []string) {
+	var subscribed []string
+	// sessions are only looked up once per request, and only if a new feed is needed
+	var sessions []session.Session
+	for _, name := range names {
+		topic, err := ParseTopic(name)
+		if err != nil {
+			c.reply(Response{Type: "error", Topics: []string{name}, Error: err.Error()})
+			continue
+		}
+
+		c.mu.Lock()
+		_, hasFeed := c.feeds[topic.SessionKey]
+		c.mu.Unlock()
+		if !hasFeed {
+			if sessions == nil {
+				sessions, err = c.source.Sessions(c.ctx)
+				if err != nil {
+					c.logger.Error("fetching sessions", "error", err)
+					c.reply(Response{Type: "error", Topics: []string{name}, Error: "error fetching sessions"})
+					continue
+				}
+			}
+			if err := c.joinFeed(sessions, topic.SessionKey); err != nil {
+				c.reply(Response{Type: "error", Topics: []string{name}, Error: err.Error()})
+				continue
+			}
+		}
+
+		c.mu.Lock()
+		c.topics[name] = topic
+		c.mu.Unlock()
+		subscribed = append(subscribed, name)
+	}
+	if len(subscribed) > 0 {
+		c.reply(Response{Type: "subscribed", Topics: subscribed})
+	}
+}
+
+// joinFeed subscribes the client to a session's live feed and starts forwarding its events
+func (c *client) joinFeed(sessions []session.Session, sessionKey int) error {
+	s := session.FindSessionById(sessions, sessionKey)
+	if s == nil {
+		return fmt.Errorf("session %d not found", sessionKey)
+	}
+	if !live.IsLive(*s, time.Now()) {
+		return fmt.Errorf("session %d is not live", sessionKey)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return fmt.Errorf("connection closed")
+	}
+	if _, ok := c.feeds[sessionKey]; ok {
+		return nil
+	}
+	sub, _ := c.manager.Subscribe(s.SessionKey, s.DateEnd, 0)
+	c.feeds[sessionKey] = sub
+	go c.forward(sessionKey, sub)
+	return nil
+}
+
+// forward routes a session's live events to the client's matching topics until the feed closes
+func (c *client) forward(sessionKey int, sub *ingest.Subscription) {
+	for event := range sub.Events {
+		if event.Type == ingest.EventEnd {
+			continue
+		}
+		var rows []json.RawMessage
+		if err := json.Unmarshal(event.Data, &rows); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		for _, topic := range c.topics {
+			if topic.SessionKey != sessionKey || topic.Resource != event.Type {
+				continue
+			}
+			data := topic.Filter(rows)
+			if len(data) == 0 {
+				continue
+			}
+			c.pending = append(c.pending, Message{Topic: topic.Name, EventID: event.ID, Data: data})
+		}
+		if len(c.pending) > c.maxPending {
+			c.dropped += len(c.pending) - c.maxPending
+			c.pending = c.pending[len(c.pending)-c.maxPending:]
+		}
+		c.mu.Unlock()
+	}
+
+	// the feed has ended, either the session finished or this client fell too far behind
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.feeds[sessionKey] != sub {
+		return
+	}
+	delete(c.feeds, sessionKey)
+	var ended []string
+	for name, topic := range c.topics {
+		if topic.SessionKey == sessionKey {
+			ended = append(ended, name)
+			delete(c.topics, name)
+		}
+	}
+	if len(ended) > 0 && !c.closed {
+		c.replies = append(c.replies, Response{Type: "end", Topics: ended})
+	}
+}
+
+func (c *client) unsubscribe(names []string) {
+	c.mu.Lock()
+	var unsubscribed []string
+	for _, name := range names {
+		if _, ok := c.topics[name]; ok {
+			delete(c.topics, name)
+			unsubscribed = append(unsubscribed, name)
+		}
+	}
+	// leave the feeds of sessions that have no topics left
+	var idle []*ingest.Subscription
+	for sessionKey, sub := range c.feeds {
+		if !c.hasTopicsLocked(sessionKey) {
+			delete(c.feeds, sessionKey)
+			idle = append(idle, sub)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, sub := range idle {
+		sub.Close()
+	}
+	c.reply(Response{Type: "unsubscribed", Topics: unsubscribed})
+}
+
+func (c *client) hasTopicsLocked(sessionKey int) bool {
+	for _, topic := range c.topics {
+		if topic.SessionKey == sessionKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *client) close() {
+	c.mu.Lock()
+	c.closed = true
+	feeds := c.feeds
+	c.feeds = map[int]*ingest.Subscription{}
+	c.mu.Unlock()
+
+	for _, sub := range feeds {
+		sub.Close()
+	}
+	c.conn.Close()
+}
@@ -0,0 +1,237 @@
+package ws_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"telem-api-server/api/resource/ws"
+	"telem-api-server/config"
+	"telem-api-server/ingest"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+type wsServer struct {
+	*httptest.Server
+	handler *ws.Handler
+	feeds   *ingest.Manager
+}
+
+// newWSServer serves /ws against a fake openf1 with one session running now and one that has finished
+// every car data request gets a new sample for cars 1 and 44, so each poll is an event
+func newWSServer(t *testing.T, maxPending int) *wsServer {
+	t.Helper()
+	now := time.Now().UTC()
+	sessions := []openf1.Session{
+		{SessionKey: 9158, SessionName: "Race", SessionType: "Race", DateStart: now.Add(-time.Hour), DateEnd: now.Add(time.Hour)},
+		{SessionKey: 9157, SessionName: "Qualifying", SessionType: "Qualifying", DateStart: now.Add(-26 * time.Hour), DateEnd: now.Add(-25 * time.Hour)},
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sessions":
+			json.NewEncoder(w).Encode(sessions)
+		case "/car_data":
+			date := time.Now().UTC()
+			json.NewEncoder(w).Encode([]openf1.CarData{
+				{SessionKey: 9158, DriverNumber: 1, Date: date, Speed: 301},
+				{SessionKey: 9158, DriverNumber: 44, Date: date, Speed: 298},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	client := openf1.NewClient(upstream.URL)
+	client.Retry.MaxAttempts = 1
+	feeds := ingest.NewManager(client, nil)
+	feeds.PollInterval = 10 * time.Millisecond
+	t.Cleanup(feeds.Stop)
+	handler := &ws.Handler{Config: config.Default(), Source: store.NewSource(client, nil, time.Minute), Feeds: feeds, Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), MaxPending: maxPending}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", handler.WebSocketHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return &wsServer{Server: srv, handler: handler, feeds: feeds}
+}
+
+func (s *wsServer) dial(t *testing.T) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, action string, topics ...string) {
+	t.Helper()
+	if err := conn.WriteJSON(ws.Request{Action: action, Topics: topics}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns the next response, ok is false if none comes within wait
+func read(t *testing.T, conn *websocket.Conn, wait time.Duration) (response ws.Response, ok bool) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(wait))
+	if err := conn.ReadJSON(&response); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return ws.Response{}, false
+		}
+		t.Fatalf("reading response: %v", err)
+	}
+	return response, true
+}
+
+// next skips batches up to the next response of type typ, batches keep coming while a session is live
+func next(t *testing.T, conn *websocket.Conn, typ string) ws.Response {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		response, ok := read(t, conn, time.Until(deadline))
+		if !ok {
+			break
+		}
+		if response.Type == typ {
+			return response
+		}
+		if response.Type != "batch" {
+			t.Fatalf("got %+v waiting for %s", response, typ)
+		}
+	}
+	t.Fatalf("no %s response", typ)
+	return ws.Response{}
+}
+
+func TestSubscribe(t *testing.T) {
+	srv := newWSServer(t, 0)
+	conn := srv.dial(t)
+	send(t, conn, "subscribe", "session:9158/car:44", "session:9157/car", "car:44")
+
+	// bad topics are answered one at a time, the good ones together
+	for _, want := range []string{"session:9157/car", "car:44"} {
+		response, _ := read(t, conn, 2*time.Second)
+		if response.Type != "error" || len(response.Topics) != 1 || response.Topics[0] != want {
+			t.Errorf("response = %+v, want an error for %s", response, want)
+		}
+	}
+	if response := next(t, conn, "subscribed"); len(response.Topics) != 1 || response.Topics[0] != "session:9158/car:44" {
+		t.Errorf("subscribed to %v, want session:9158/car:44", response.Topics)
+	}
+
+	// polls between batches are sent together, each message only has the topic's car
+	for {
+		batch := next(t, conn, "batch")
+		for _, message := range batch.Messages {
+			if message.Topic != "session:9158/car:44" {
+				t.Fatalf("message for %s", message.Topic)
+			}
+			for _, row := range message.Data {
+				var sample openf1.CarData
+				json.Unmarshal(row, &sample)
+				if sample.DriverNumber != 44 {
+					t.Fatalf("car %d's sample sent for car 44's topic", sample.DriverNumber)
+				}
+			}
+		}
+		if len(batch.Messages) > 1 {
+			if batch.Messages[0].EventID >= batch.Messages[1].EventID {
+				t.Errorf("batch event ids %d, %d are out of order", batch.Messages[0].EventID, batch.Messages[1].EventID)
+			}
+			break
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	srv := newWSServer(t, 0)
+	conn := srv.dial(t)
+	send(t, conn, "subscribe", "session:9158/car")
+	next(t, conn, "subscribed")
+	next(t, conn, "batch")
+
+	send(t, conn, "unsubscribe", "session:9158/car", "session:9158/positions")
+	if response := next(t, conn, "unsubscribed"); len(response.Topics) != 1 || response.Topics[0] != "session:9158/car" {
+		t.Errorf("unsubscribed from %v, want only the topic that was subscribed to", response.Topics)
+	}
+	// the batch collected before the request can follow its reply, nothing after that
+	batches := 0
+	for {
+		response, ok := read(t, conn, 600*time.Millisecond)
+		if !ok {
+			break
+		}
+		batches++
+		if batches > 1 {
+			t.Fatalf("got %+v after unsubscribing", response)
+		}
+	}
+}
+
+func TestSlowClientDropsOldest(t *testing.T) {
+	srv := newWSServer(t, 2)
+	conn := srv.dial(t)
+	send(t, conn, "subscribe", "session:9158/car")
+	next(t, conn, "subscribed")
+
+	// a poll every 10ms is far more than 2 messages a batch
+	for {
+		batch := next(t, conn, "batch")
+		if len(batch.Messages) > 2 {
+			t.Fatalf("batch has %d messages, want at most 2", len(batch.Messages))
+		}
+		if batch.Dropped > 0 {
+			if len(batch.Messages) != 2 {
+				t.Errorf("batch has %d messages after dropping some, want the newest 2", len(batch.Messages))
+			}
+			break
+		}
+	}
+}
+
+func TestFeedEnd(t *testing.T) {
+	srv := newWSServer(t, 0)
+	conn := srv.dial(t)
+	send(t, conn, "subscribe", "session:9158/car", "session:9158/car:1")
+	next(t, conn, "subscribed")
+
+	srv.feeds.Stop()
+	response := next(t, conn, "end")
+	if len(response.Topics) != 2 {
+		t.Errorf("ended topics = %v, want both of the session's", response.Topics)
+	}
+}
+
+func TestCloseAll(t *testing.T) {
+	srv := newWSServer(t, 0)
+	conn := srv.dial(t)
+	send(t, conn, "subscribe", "session:9158/car")
+	next(t, conn, "subscribed")
+
+	srv.handler.CloseAll()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("err = %v, want a going away close", err)
+		}
+		break
+	}
+}
@@ -0,0 +1,76 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// topic names map onto the openf1 resource the live feed polls
+var topicResources = map[string]string{
+	"car":          "car_data",
+	"positions":    "position",
+	"intervals":    "intervals",
+	"race-control": "race_control",
+}
+
+// Topic is a parsed subscription such as session:9158/car:1
+type Topic struct {
+	Name       string
+	SessionKey int
+	Resource   string
+	// only set for per-driver topics, e.g. car:1
+	DriverNumber *int
+}
+
+// ParseTopic parses topics of the form session:{key}/{stream} or session:{key}/{stream}:{driver}
+func ParseTopic(name string) (Topic, error) {
+	sessionPart, streamPart, ok := strings.Cut(name, "/")
+	if !ok {
+		return Topic{}, fmt.Errorf("invalid topic %q", name)
+	}
+	keyStr, ok := strings.CutPrefix(sessionPart, "session:")
+	if !ok {
+		return Topic{}, fmt.Errorf("invalid topic %q, topics start with session:{key}", name)
+	}
+	sessionKey, err := strconv.Atoi(keyStr)
+	if err != nil {
+		return Topic{}, fmt.Errorf("invalid session key in topic %q", name)
+	}
+
+	stream, driverStr, hasDriver := strings.Cut(streamPart, ":")
+	resource, ok := topicResources[stream]
+	if !ok {
+		return Topic{}, fmt.Errorf("unknown stream %q in topic %q", stream, name)
+	}
+	topic := Topic{Name: name, SessionKey: sessionKey, Resource: resource}
+	if hasDriver {
+		driver, err := strconv.Atoi(driverStr)
+		if err != nil {
+			return Topic{}, fmt.Errorf("invalid driver number in topic %q", name)
+		}
+		topic.DriverNumber = &driver
+	}
+	return topic, nil
+}
+
+// Filter returns the rows of a batch that belong to the topic
+func (t Topic) Filter(rows []json.RawMessage) []json.RawMessage {
+	if t.DriverNumber == nil {
+		return rows
+	}
+	var filtered []json.RawMessage
+	for _, row := range rows {
+		var driver struct {
+			DriverNumber *int `json:"driver_number"`
+		}
+		if err := json.Unmarshal(row, &driver); err != nil {
+			continue
+		}
+		if driver.DriverNumber != nil && *driver.DriverNumber == *t.DriverNumber {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
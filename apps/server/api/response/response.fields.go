@@ -0,0 +1,170 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// ParseFields reads the fields= parameter, a comma separated list of json field names
+// nil means the parameter was left out and every field is returned
+func ParseFields(r *http.Request) []string {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil
+	}
+	var fields []string
+	seen := map[string]bool{}
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// FieldNames returns the top level json field names of t in struct order, the names fields= can select
+// they are the keys of the JSON encoding, even where a csv tag names the column something else
+func FieldNames(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil
+	}
+	var names []string
+	seen := map[string]bool{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			// embedded structs without a json tag have their fields promoted
+			if embedded := indirectType(f.Type); f.Anonymous && embedded.Kind() == reflect.Struct && !hasJSONTag(f) {
+				walk(embedded)
+				continue
+			}
+			name, skip := jsonName(f)
+			if skip || seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	walk(t)
+	return names
+}
+
+// ValidateFields checks every requested field is a json field of t
+func ValidateFields(t reflect.Type, fields []string) error {
+	names := FieldNames(t)
+	if len(names) == 0 {
+		return fmt.Errorf("fields can't be selected for this resource")
+	}
+	valid := map[string]bool{}
+	for _, name := range names {
+		valid[name] = true
+	}
+	for _, field := range fields {
+		if !valid[field] {
+			return fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(names, ","))
+		}
+	}
+	return nil
+}
+
+// project encodes row as a JSON object holding only the requested fields, in the order they were asked for
+// fields left out of the encoding by omitempty stay left out
+func project(row any, fields []string) (json.RawMessage, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := true
+	for _, field := range fields {
+		value, ok := object[field]
+		if !ok {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		key, _ := json.Marshal(field)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// projectAll projects v, keeping it a list if it was one
+func projectAll(v any, fields []string) (any, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return project(v, fields)
+	}
+	projected := make([]json.RawMessage, value.Len())
+	for i := range projected {
+		row, err := project(value.Index(i).Interface(), fields)
+		if err != nil {
+			return nil, err
+		}
+		projected[i] = row
+	}
+	return projected, nil
+}
+
+// selectColumns keeps the CSV columns of the requested fields, nested columns come along with their parent
+func selectColumns(cols []column, fields []string) []column {
+	var selected []column
+	for _, field := range fields {
+		for _, col := range cols {
+			if col.key == field {
+				selected = append(selected, col)
+			}
+		}
+	}
+	return selected
+}
+
+// jsonName is the key encoding/json writes f under, skip is true for fields it leaves out
+func jsonName(f reflect.StructField) (name string, skip bool) {
+	name, _, _ = strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return "", true
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name, false
+}
+
+func hasJSONTag(f reflect.StructField) bool {
+	_, ok := f.Tag.Lookup("json")
+	return ok
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,83 @@
+package response_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"telem-api-server/api/response"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "", want: nil},
+		{query: "fields=lap_number", want: []string{"lap_number"}},
+		{query: "fields=lap_number,+driver_number,,lap_number", want: []string{"lap_number", "driver_number"}},
+	}
+	for _, tt := range tests {
+		got := response.ParseFields(httptest.NewRequest(http.MethodGet, "/laps?"+tt.query, nil))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFields(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+type dateRange struct {
+	Start string `json:"start"`
+}
+
+// renamed has csv tags that differ from its json keys, and fields promoted from an embedded struct
+type renamed struct {
+	dateRange
+	Meta
+	SessionKey int    `json:"session_key" csv:"session"`
+	Name       string `csv:"session_name"`
+	Hidden     string `json:"-"`
+	NoCSV      string `json:"no_csv" csv:"-"`
+}
+
+type Meta struct {
+	Year int `json:"year"`
+}
+
+func TestFieldNames(t *testing.T) {
+	// the names are the JSON keys, the same ones the JSON projection selects by
+	want := []string{"year", "session_key", "Name", "no_csv"}
+	if got := response.FieldNames(reflect.TypeOf(renamed{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldNames = %v, want %v", got, want)
+	}
+	if got := response.FieldNames(reflect.TypeOf(3)); got != nil {
+		t.Errorf("FieldNames(int) = %v, want none", got)
+	}
+}
+
+func TestFieldsSelectByJSONKey(t *testing.T) {
+	rows := []renamed{{Meta: Meta{Year: 2023}, SessionKey: 9158, Name: "Race"}}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "json", want: `[{"session_key":9158,"year":2023}]` + "\n"},
+		{format: "ndjson", want: `{"session_key":9158,"year":2023}` + "\n"},
+		// the CSV columns keep their csv names
+		{format: "csv", want: "session,year\n9158,2023\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		response.Write(w, httptest.NewRequest(http.MethodGet, "/sessions?fields=session_key,year&format="+tt.format, nil), rows)
+		if w.Code != http.StatusOK || w.Body.String() != tt.want {
+			t.Errorf("%s: %d %q, want %q", tt.format, w.Code, w.Body.String(), tt.want)
+		}
+	}
+
+	// a csv name isn't a field
+	w := httptest.NewRecorder()
+	response.Write(w, httptest.NewRequest(http.MethodGet, "/sessions?fields=session&format=csv", nil), rows)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d selecting by csv name, want 400", w.Code)
+	}
+}
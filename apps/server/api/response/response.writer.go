@@ -0,0 +1,389 @@
+// package for writing handler responses in the format the client asked for
+package response
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("telem-api-server/api/response")
+
+type Format string
+
+const (
+	JSON   Format = "application/json"
+	NDJSON Format = "application/x-ndjson"
+	CSV    Format = "text/csv"
+)
+
+// formatParams are the values accepted by the format= query parameter
+var formatParams = map[string]Format{
+	"json":   JSON,
+	"ndjson": NDJSON,
+	"csv":    CSV,
+}
+
+// mediaTypes are the Accept header media types that map to a format, wildcards pick the first format of the family
+var mediaTypes = map[string]Format{
+	"application/json":     JSON,
+	"application/x-ndjson": NDJSON,
+	"application/ndjson":   NDJSON,
+	"text/csv":             CSV,
+	"application/*":        JSON,
+	"text/*":               CSV,
+	"*/*":                  JSON,
+}
+
+// NDJSON responses are flushed every flushRows rows so large lists reach the client while they're still being written
+const flushRows = 500
+
+var ErrUnknownFormat = errors.New("unknown format")
+var ErrNotAcceptable = errors.New("none of the accepted media types can be produced")
+
+// Negotiate picks the response format from the format= parameter, then the Accept header, falling back to JSON
+func Negotiate(r *http.Request) (Format, error) {
+	if param := r.URL.Query().Get("format"); param != "" {
+		format, ok := formatParams[strings.ToLower(param)]
+		if !ok {
+			return "", ErrUnknownFormat
+		}
+		return format, nil
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return JSON, nil
+	}
+
+	type candidate struct {
+		format Format
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := mediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{format: format, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return "", ErrNotAcceptable
+	}
+	// stable so equally weighted types keep the order the client listed them in
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].format, nil
+}
+
+// Write sends v in the negotiated format, trimmed to the fields= parameter when it is given
+// slices are written one row per element for NDJSON and CSV, anything else is written as a single row
+func Write(w http.ResponseWriter, r *http.Request, v any) {
+	format, err := Negotiate(r)
+	if errors.Is(err, ErrUnknownFormat) {
+		http.Error(w, "Unsupported format, expected json, ndjson or csv", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unsupported Accept header, expected application/json, application/x-ndjson or text/csv", http.StatusNotAcceptable)
+		return
+	}
+	fields := ParseFields(r)
+	if fields != nil {
+		rowType, _ := rows(v)
+		if err := ValidateFields(rowType, fields); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fields: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Add("Vary", "Accept")
+
+	// encoding is traced on its own as large lists spend as long here as fetching them
+	_, span := tracer.Start(r.Context(), "encode "+formatName(format), trace.WithAttributes(attribute.Bool("response.fields", fields != nil)))
+	defer span.End()
+
+	switch format {
+	case NDJSON:
+		w.Header().Set("Content-Type", string(NDJSON))
+		err = writeNDJSON(w, v, fields)
+	case CSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8; header=present")
+		err = writeCSV(w, v, fields)
+	default:
+		if fields != nil {
+			if v, err = projectAll(v, fields); err != nil {
+				log.Printf("Error projecting response fields: %v", err)
+				http.Error(w, "error encoding response", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", string(JSON))
+		err = json.NewEncoder(w).Encode(v)
+	}
+	if err != nil {
+		// the status has gone out with the first row, all that is left to do is log it
+		log.Printf("Error encoding %s response: %v", format, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "encoding failed")
+	}
+}
+
+// formatName is the format= value of a format
+func formatName(format Format) string {
+	for name, f := range formatParams {
+		if f == format {
+			return name
+		}
+	}
+	return string(format)
+}
+
+// rows returns the elements of a slice, or v itself as the only row
+func rows(v any) (reflect.Type, func(yield func(reflect.Value) error) error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		return value.Type().Elem(), func(yield func(reflect.Value) error) error {
+			for i := 0; i < value.Len(); i++ {
+				if err := yield(value.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return value.Type(), func(yield func(reflect.Value) error) error {
+		return yield(value)
+	}
+}
+
+func writeNDJSON(w http.ResponseWriter, v any, fields []string) error {
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	_, each := rows(v)
+	n := 0
+	return each(func(row reflect.Value) error {
+		var out any = row.Interface()
+		if fields != nil {
+			projected, err := project(out, fields)
+			if err != nil {
+				return err
+			}
+			out = projected
+		}
+		if err := encoder.Encode(out); err != nil {
+			return err
+		}
+		if n++; n%flushRows == 0 && flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+func writeCSV(w http.ResponseWriter, v any, fields []string) error {
+	rowType, each := rows(v)
+	cols := columnsOf(rowType)
+	if fields != nil {
+		cols = selectColumns(cols, fields)
+	}
+
+	writer := csv.NewWriter(w)
+	record := make([]string, len(cols))
+	for i, col := range cols {
+		record[i] = col.name
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	err := each(func(row reflect.Value) error {
+		for i, col := range cols {
+			record[i] = formatField(col.field(row))
+		}
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// column is a CSV column, index is the path of struct fields leading to the value
+// key is the top level json field the column belongs to, the name fields= selects it by
+type column struct {
+	name  string
+	key   string
+	index []int
+}
+
+// field follows the column's path through row, an invalid value means a nil pointer was on the way
+func (c column) field(row reflect.Value) reflect.Value {
+	for _, i := range c.index {
+		row = reflect.Indirect(row)
+		if !row.IsValid() {
+			return row
+		}
+		row = row.Field(i)
+	}
+	return row
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// columns are worked out once per type, telemetry lists can run to hundreds of thousands of rows
+var columnCache sync.Map
+
+// columnsOf derives the CSV header from the struct tags of t, a csv tag wins over the json tag
+// nested structs are flattened with their fields prefixed by the parent's name, e.g. DateRange.start
+func columnsOf(t reflect.Type) []column {
+	if cached, ok := columnCache.Load(t); ok {
+		return cached.([]column)
+	}
+	cols := structColumns(t, "", "", nil)
+	columnCache.Store(t, cols)
+	return cols
+}
+
+func structColumns(t reflect.Type, prefix string, key string, index []int) []column {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		name := prefix
+		if name == "" {
+			name = "value"
+		}
+		return []column{{name: name, key: key, index: index}}
+	}
+
+	var cols []column
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, skip := fieldName(f)
+		if skip {
+			continue
+		}
+		path := append(append([]int{}, index...), i)
+		// embedded structs have their fields promoted in JSON, so they are in CSV too
+		embedded := f.Type
+		if embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+		if f.Anonymous && embedded.Kind() == reflect.Struct && !hasTag(f) {
+			cols = append(cols, structColumns(f.Type, prefix, key, path)...)
+			continue
+		}
+		fieldKey := key
+		if fieldKey == "" {
+			fieldKey, _ = jsonName(f)
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		cols = append(cols, structColumns(f.Type, name, fieldKey, path)...)
+	}
+	return cols
+}
+
+func fieldName(f reflect.StructField) (string, bool) {
+	for _, key := range []string{"csv", "json"} {
+		tag, ok := f.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			return "", true
+		}
+		if name != "" {
+			return name, false
+		}
+	}
+	return f.Name, false
+}
+
+func hasTag(f reflect.StructField) bool {
+	_, csvTag := f.Tag.Lookup("csv")
+	_, jsonTag := f.Tag.Lookup("json")
+	return csvTag || jsonTag
+}
+
+// formatField writes nulls as empty cells, times as RFC 3339 and lists or maps as JSON
+func formatField(v reflect.Value) string {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	if v.Type() == rawMessageType {
+		raw := strings.TrimSpace(string(v.Bytes()))
+		if raw == "null" {
+			return ""
+		}
+		return strings.Trim(raw, `"`)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.IsNil() {
+		return ""
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,175 @@
+package response_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"telem-api-server/api/response"
+)
+
+type sector struct {
+	Number   int      `json:"number"`
+	Duration *float64 `json:"duration"`
+}
+
+type lap struct {
+	DriverNumber int       `json:"driver_number"`
+	LapNumber    int       `json:"lap_number"`
+	DateStart    time.Time `json:"date_start"`
+	Sector       sector    `json:"sector"`
+	Segments     []int     `json:"segments"`
+	Internal     string    `json:"-"`
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		want   response.Format
+		err    error
+	}{
+		{name: "nothing asked for", want: response.JSON},
+		{name: "parameter", query: "format=csv", want: response.CSV},
+		{name: "parameter is case insensitive", query: "format=NDJSON", want: response.NDJSON},
+		{name: "parameter wins over the header", query: "format=json", accept: "text/csv", want: response.JSON},
+		{name: "unknown parameter", query: "format=xml", err: response.ErrUnknownFormat},
+		{name: "header", accept: "application/x-ndjson", want: response.NDJSON},
+		{name: "highest quality", accept: "application/json;q=0.5, text/csv;q=0.9", want: response.CSV},
+		{name: "equal quality keeps the client's order", accept: "text/csv, application/json", want: response.CSV},
+		{name: "unknown types are skipped", accept: "application/xml, application/ndjson;q=0.1", want: response.NDJSON},
+		{name: "wildcard", accept: "text/*", want: response.CSV},
+		{name: "any", accept: "*/*", want: response.JSON},
+		{name: "refused", accept: "text/csv;q=0", err: response.ErrNotAcceptable},
+		{name: "nothing we make", accept: "application/xml", err: response.ErrNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/laps?"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			format, err := response.Negotiate(r)
+			if err != tt.err || format != tt.want {
+				t.Errorf("Negotiate = %q, %v, want %q, %v", format, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func write(t *testing.T, w http.ResponseWriter, target string, v any) {
+	t.Helper()
+	response.Write(w, httptest.NewRequest(http.MethodGet, target, nil), v)
+}
+
+func TestWriteCSV(t *testing.T) {
+	duration := 31.5
+	laps := []lap{
+		{DriverNumber: 1, LapNumber: 2, DateStart: time.Date(2023, 9, 17, 12, 5, 0, 0, time.UTC), Sector: sector{Number: 1, Duration: &duration}, Segments: []int{2048, 2049}, Internal: "x"},
+		{DriverNumber: 44, LapNumber: 1},
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{
+			// columns follow the struct, nested fields are flattened and nulls are empty
+			name:   "every column",
+			target: "/laps?format=csv",
+			want: "driver_number,lap_number,date_start,sector.number,sector.duration,segments\n" +
+				"1,2,2023-09-17T12:05:00Z,1,31.5,\"[2048,2049]\"\n" +
+				"44,1,,0,,\n",
+		},
+		{
+			// selected columns come in the order they were asked for, a nested field brings all of its columns
+			name:   "fields",
+			target: "/laps?format=csv&fields=sector,driver_number",
+			want:   "sector.number,sector.duration,driver_number\n1,31.5,1\n0,,44\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			write(t, w, tt.target, laps)
+			if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8; header=present" {
+				t.Errorf("content type = %s", got)
+			}
+			if w.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+// flushCounter counts the flushes of the response
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes int
+	// lines is how many rows had been written at each flush
+	lines []int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.lines = append(f.lines, strings.Count(f.Body.String(), "\n"))
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	laps := make([]lap, 1200)
+	for i := range laps {
+		laps[i] = lap{DriverNumber: 1, LapNumber: i + 1}
+	}
+	w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+	write(t, w, "/laps?format=ndjson&fields=lap_number", laps)
+
+	if got := w.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("content type = %s", got)
+	}
+	// large lists reach the client every 500 rows
+	if w.flushes != 2 || w.lines[0] != 500 || w.lines[1] != 1000 {
+		t.Errorf("flushed %d times after %v rows, want after 500 and 1000", w.flushes, w.lines)
+	}
+	// one projected object a line
+	scanner := bufio.NewScanner(w.Body)
+	n := 0
+	for scanner.Scan() {
+		n++
+		if want := `{"lap_number":` + strconv.Itoa(n) + `}`; scanner.Text() != want {
+			t.Fatalf("row %d = %s, want %s", n, scanner.Text(), want)
+		}
+	}
+	if n != len(laps) {
+		t.Errorf("%d rows, want %d", n, len(laps))
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	tests := []struct {
+		target string
+		accept string
+		status int
+	}{
+		{target: "/laps?format=xml", status: http.StatusBadRequest},
+		{target: "/laps", accept: "application/xml", status: http.StatusNotAcceptable},
+		{target: "/laps?fields=speed", status: http.StatusBadRequest},
+		// json:"-" fields aren't part of the response, so can't be selected
+		{target: "/laps?fields=Internal", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		response.Write(w, r, []lap{{DriverNumber: 1}})
+		if w.Code != tt.status {
+			t.Errorf("%s (Accept %q) status = %d, want %d", tt.target, tt.accept, w.Code, tt.status)
+		}
+	}
+}
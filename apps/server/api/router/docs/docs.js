@@ -0,0 +1,193 @@
+// renders the OpenAPI document served next to this page, with a form to try each operation
+
+const methods = ["get", "post", "put", "patch", "delete"];
+
+function el(tag, attrs = {}, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs)) {
+    if (key === "class") {
+      node.className = value;
+    } else {
+      node.setAttribute(key, value);
+    }
+  }
+  for (const child of children) {
+    node.append(child);
+  }
+  return node;
+}
+
+// schemaName shortens a schema to something readable in a table cell
+function schemaName(schema) {
+  if (!schema) {
+    return "";
+  }
+  if (schema.$ref) {
+    return schema.$ref.split("/").pop();
+  }
+  if (schema.anyOf) {
+    return schema.anyOf.map(schemaName).join(" | ");
+  }
+  if (schema.type === "array") {
+    return schemaName(schema.items) + "[]";
+  }
+  if (Array.isArray(schema.type)) {
+    return schema.type.join(" | ");
+  }
+  return schema.type || "any";
+}
+
+function schemaLink(schema) {
+  const name = schemaName(schema);
+  const ref = schema && (schema.$ref || (schema.items && schema.items.$ref));
+  if (!ref) {
+    return el("code", {}, name);
+  }
+  return el("a", { href: "#schema-" + ref.split("/").pop() }, el("code", {}, name));
+}
+
+function parametersTable(parameters, inputs) {
+  const table = el("table", {}, el("tr", {}, el("th", {}, "Name"), el("th", {}, "In"), el("th", {}, "Type"), el("th", {}, "Description"), el("th", {}, "Value")));
+  for (const param of parameters) {
+    let input;
+    if (param.schema.enum) {
+      input = el("select", {}, el("option", { value: "" }, ""));
+      for (const value of param.schema.enum) {
+        input.append(el("option", { value }, value));
+      }
+    } else {
+      input = el("input", { placeholder: param.name });
+    }
+    inputs.push({ param, input });
+    const name = el("td", {}, el("code", {}, param.name));
+    if (param.required) {
+      name.append(el("span", { class: "required" }, " *"));
+    }
+    table.append(el("tr", {}, name, el("td", {}, param.in), el("td", {}, schemaLink(param.schema)), el("td", {}, param.description || ""), el("td", {}, input)));
+  }
+  return table;
+}
+
+function responsesTable(responses) {
+  const table = el("table", {}, el("tr", {}, el("th", {}, "Status"), el("th", {}, "Media type"), el("th", {}, "Schema")));
+  for (const [status, res] of Object.entries(responses)) {
+    const content = Object.entries(res.content || { "": {} });
+    for (const [mediaType, media] of content) {
+      table.append(el("tr", {}, el("td", {}, status + " " + res.description), el("td", {}, mediaType), el("td", {}, schemaLink(media.schema))));
+    }
+  }
+  return table;
+}
+
+async function send(method, path, inputs, body, output) {
+  let url = path;
+  const query = new URLSearchParams();
+  const headers = {};
+  for (const { param, input } of inputs) {
+    if (input.value === "") {
+      continue;
+    }
+    if (param.in === "path") {
+      url = url.replace("{" + param.name + "}", encodeURIComponent(input.value));
+    } else if (param.in === "query") {
+      query.set(param.name, input.value);
+    } else if (param.in === "header") {
+      headers[param.name] = input.value;
+    }
+  }
+  if ([...query].length > 0) {
+    url += "?" + query;
+  }
+  const options = { method: method.toUpperCase(), headers };
+  if (body) {
+    options.body = body.value;
+    headers["Content-Type"] = "application/json";
+  }
+
+  output.textContent = options.method + " " + url + "\n\n...";
+  try {
+    // paths are relative to where the docs are served so they keep working behind a prefix
+    const res = await fetch(new URL("." + url, document.baseURI), options);
+    let text = await res.text();
+    if ((res.headers.get("Content-Type") || "").startsWith("application/json")) {
+      try {
+        text = JSON.stringify(JSON.parse(text), null, 2);
+      } catch (e) {
+        // leave it as it came
+      }
+    }
+    output.textContent = options.method + " " + url + "\n" + res.status + " " + res.statusText + "\n\n" + text;
+  } catch (err) {
+    output.textContent = options.method + " " + url + "\n\n" + err;
+  }
+}
+
+function operation(path, method, op) {
+  const inputs = [];
+  const body = el("div", { class: "body" });
+  if (op.summary) {
+    body.append(el("p", {}, op.summary));
+  }
+  if (op.parameters && op.parameters.length > 0) {
+    body.append(el("h4", {}, "Parameters"), parametersTable(op.parameters, inputs));
+  }
+  let requestBody = null;
+  if (op.requestBody) {
+    const media = Object.values(op.requestBody.content)[0];
+    requestBody = el("textarea", { rows: "6" });
+    requestBody.value = "{}";
+    body.append(el("h4", {}, "Request body ", schemaLink(media.schema)), requestBody);
+  }
+  body.append(el("h4", {}, "Responses"), responsesTable(op.responses));
+
+  const output = el("pre", {});
+  const button = el("button", {}, "Send");
+  button.addEventListener("click", () => send(method, path, inputs, requestBody, output));
+  body.append(button, output);
+
+  return el("details", {}, el("summary", {}, el("span", { class: "method " + method }, method), el("span", { class: "path" }, path)), body);
+}
+
+function render(spec) {
+  document.title = spec.info.title;
+  document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+  document.getElementById("description").textContent = spec.info.description || "";
+
+  // operations are grouped by their first tag, in the order the tags first appear
+  const groups = new Map();
+  for (const [path, item] of Object.entries(spec.paths)) {
+    for (const method of methods) {
+      const op = item[method];
+      if (!op) {
+        continue;
+      }
+      const tag = (op.tags && op.tags[0]) || "other";
+      if (!groups.has(tag)) {
+        groups.set(tag, []);
+      }
+      groups.get(tag).push(operation(path, method, op));
+    }
+  }
+  const operations = document.getElementById("operations");
+  for (const [tag, ops] of groups) {
+    operations.append(el("h2", {}, tag), ...ops);
+  }
+
+  const schemas = document.getElementById("schemas");
+  const components = (spec.components && spec.components.schemas) || {};
+  for (const name of Object.keys(components).sort()) {
+    const schema = components[name];
+    const table = el("table", {}, el("tr", {}, el("th", {}, "Field"), el("th", {}, "Type")));
+    for (const [field, property] of Object.entries(schema.properties || {})) {
+      table.append(el("tr", {}, el("td", {}, el("code", {}, field)), el("td", {}, schemaLink(property))));
+    }
+    schemas.append(el("details", { id: "schema-" + name }, el("summary", {}, el("span", { class: "path" }, name)), el("div", { class: "body" }, table)));
+  }
+}
+
+fetch("openapi.json")
+  .then((res) => res.json())
+  .then(render)
+  .catch((err) => {
+    document.getElementById("operations").textContent = "Unable to load openapi.json: " + err;
+  });
@@ -0,0 +1,29 @@
+package router
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// the docs page renders /openapi.json in the browser, its assets are embedded so it works without a CDN
+//
+//go:embed docs
+var docsAssets embed.FS
+
+// DocsHandler serves the docs page at /docs and its assets below it
+// the page loads the document and its assets with relative urls, so /docs has no trailing slash
+func DocsHandler() http.HandlerFunc {
+	assets, err := fs.Sub(docsAssets, "docs")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/docs/", http.FileServerFS(assets))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("file") == "" {
+			http.ServeFileFS(w, r, assets, "index.html")
+			return
+		}
+		files.ServeHTTP(w, r)
+	}
+}
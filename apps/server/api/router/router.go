@@ -1,21 +1,243 @@
 package router
 
 import (
+	"log/slog"
 	"net/http"
 
+	"github.com/graphql-go/graphql"
+
+	"telem-api-server/api/resource/export"
+	"telem-api-server/api/resource/gql"
+	"telem-api-server/api/resource/grid"
+	"telem-api-server/api/resource/health"
+	"telem-api-server/api/resource/lap"
+	"telem-api-server/api/resource/live"
+	"telem-api-server/api/resource/qualifying"
+	"telem-api-server/api/resource/season"
 	"telem-api-server/api/resource/session"
+	"telem-api-server/api/resource/telemetry"
+	"telem-api-server/api/resource/ws"
+	"telem-api-server/config"
+	"telem-api-server/ingest"
+	"telem-api-server/metrics"
+	"telem-api-server/openf1"
+	"telem-api-server/pubsub"
+	"telem-api-server/store"
 )
 
-func SetupRoutes() *http.ServeMux {
+// APIPrefix is where the current version of the API is served
+const APIPrefix = "/api/v1"
+
+var apiInfo = Info{
+	Title:       "Galimoto Telemetry API",
+	Version:     "0.1.0",
+	Description: "Formula 1 sessions, timing and telemetry built on openf1 data",
+}
+
+// parameters shared between routes
+var (
+	sessionKeyParam   = PathParam("key", "integer", "openf1 session key")
+	yearParam         = PathParam("year", "integer", "Season year, e.g. 2024")
+	skipParam         = QueryParam("skip", "integer", "Number of sessions to skip")
+	limitParam        = QueryParam("limit", "integer", "Maximum number of sessions to return, 100 when only skip is given")
+	tzParam           = QueryParam("tz", "string", "IANA time zone such as Europe/London, or local for each circuit's own zone")
+	driverNumberParam = QueryParam("driver_number", "integer", "Only return this driver, every driver when left out")
+)
+
+// Deps is what the handlers share besides the configuration, built once by main
+type Deps struct {
+	// Source is where every handler reads openf1 data through
+	Source *store.Source
+	// Hub is where the sessions and telemetry requests are answered with get published, nil publishes nothing
+	Hub *pubsub.Hub
+	// Feeds polls live sessions for the SSE and websocket handlers
+	Feeds *ingest.Manager
+	// Upstream is Source's client, for the upstream status
+	Upstream *openf1.Client
+	// Warmer fetches the session list at startup, readiness waits on it
+	Warmer *health.Warmer
+	// Logger is what handlers log errors to, nil logs to slog.Default()
+	Logger *slog.Logger
+	// WebSocket serves /ws, main keeps hold of it to close the connections on shutdown
+	// nil gets one built from Source and Feeds
+	WebSocket *ws.Handler
+}
+
+// handlers holds a handler of each resource, all sharing the configuration and deps
+type handlers struct {
+	export     *export.Handler
+	gql        *gql.Handler
+	grid       *grid.Handler
+	health     *health.Handler
+	lap        *lap.Handler
+	live       *live.Handler
+	qualifying *qualifying.Handler
+	season     *season.Handler
+	session    *session.Handler
+	telemetry  *telemetry.Handler
+	ws         *ws.Handler
+}
+
+func newHandlers(cfg *config.Config, deps Deps) handlers {
+	logger := deps.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	sockets := deps.WebSocket
+	if sockets == nil {
+		sockets = &ws.Handler{Config: cfg, Source: deps.Source, Feeds: deps.Feeds, Logger: logger}
+	}
+	return handlers{
+		export:     &export.Handler{Config: cfg, Source: deps.Source, Logger: logger},
+		gql:        &gql.Handler{Config: cfg, Source: deps.Source, Logger: logger},
+		grid:       &grid.Handler{Config: cfg, Source: deps.Source, Logger: logger},
+		health:     &health.Handler{Config: cfg, Source: deps.Source, Upstream: deps.Upstream, Warmer: deps.Warmer},
+		lap:        &lap.Handler{Config: cfg, Source: deps.Source, Logger: logger},
+		live:       &live.Handler{Config: cfg, Source: deps.Source, Feeds: deps.Feeds, Logger: logger},
+		qualifying: &qualifying.Handler{Config: cfg, Source: deps.Source, Logger: logger},
+		season:     &season.Handler{Config: cfg, Source: deps.Source, Logger: logger},
+		session:    &session.Handler{Config: cfg, Source: deps.Source, Hub: deps.Hub, Logger: logger},
+		telemetry:  &telemetry.Handler{Config: cfg, Source: deps.Source, Hub: deps.Hub, Logger: logger},
+		ws:         sockets,
+	}
+}
+
+func SetupRoutes(cfg *config.Config, deps Deps) http.Handler {
+	h := newHandlers(cfg, deps)
 	mux := http.NewServeMux()
 	// home API
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Hello, World!"))
 	})
 
+	api := NewRegistry(mux).Group(APIPrefix, "")
+	registerAPI(api, h)
+	// the unversioned paths the first clients were built against, they're left out of the document
+	registerAPI(NewRegistry(mux), h)
+
+	// the contract of everything above, and a page to browse it
+	mux.HandleFunc("GET /openapi.json", OpenAPIHandler(api, apiInfo))
+	mux.HandleFunc("GET /docs", DocsHandler())
+	mux.HandleFunc("GET /docs/{file...}", DocsHandler())
+	// scraped by Prometheus, like the docs it sits outside the versioned API
+	mux.Handle("GET /metrics", metrics.Handler())
+	// probes for the orchestrator and on-call, unversioned so they never move
+	mux.HandleFunc("GET /healthz", health.LiveHandler)
+	mux.HandleFunc("GET /readyz", h.health.ReadyHandler)
+	mux.HandleFunc("GET /status/upstream", h.health.UpstreamHandler)
+	return NormaliseSlashes(mux)
+}
+
+// registerAPI registers every resource on reg, resources nested under a session or season share a group
+func registerAPI(reg *Registry, h handlers) {
 	// add more routes as we continue
-	mux.HandleFunc("/sessions", session.SessionsHandler)
-	mux.HandleFunc("/sessions/", session.SessionHandler)
-	mux.HandleFunc("/sessions/keys", session.SessionKeyHandler)
-	return mux
+	sessions := reg.Group("/sessions", "sessions")
+	sessions.Handle(Route{
+		Method: http.MethodGet, Summary: "List sessions",
+		Params: []Param{skipParam, limitParam, tzParam}, Response: []session.Session{}, Negotiated: true,
+		Handler: h.session.SessionsHandler,
+	})
+	sessions.Handle(Route{
+		Method: http.MethodGet, Path: "/keys", Summary: "List session keys with their circuit and dates",
+		Params: []Param{skipParam, limitParam, tzParam}, Response: []session.SessionKeysOnly{}, Negotiated: true,
+		Handler: h.session.SessionKeyHandler,
+	})
+
+	// resources of a single session
+	s := sessions.Group("/{key}", "", sessionKeyParam)
+	s.Handle(Route{
+		Method: http.MethodGet, Summary: "Get a session",
+		Params: []Param{tzParam}, Response: session.Session{}, Negotiated: true,
+		Handler: h.session.SessionHandler,
+	})
+	s.Handle(Route{
+		Method: http.MethodGet, Path: "/grid", Summary: "Starting grid of a race or sprint",
+		Response: grid.Grid{}, Negotiated: true,
+		Handler: h.grid.GridHandler,
+	})
+	s.Handle(Route{
+		Method: http.MethodGet, Path: "/qualifying", Summary: "Qualifying breakdown by segment",
+		Params: []Param{tzParam}, Response: qualifying.Breakdown{}, Negotiated: true,
+		Handler: h.qualifying.QualifyingHandler,
+	})
+	s.Handle(Route{
+		Method: http.MethodGet, Path: "/live", Summary: "Stream a live session as server-sent events",
+		Params: []Param{
+			HeaderParam("Last-Event-ID", "Id of the last event received, to resume after reconnecting"),
+			QueryParam("lastEventId", "integer", "Same as Last-Event-ID for clients that can't set headers"),
+		},
+		ContentType: "text/event-stream",
+		Handler:     h.live.LiveHandler,
+	})
+	s.Handle(Route{
+		Method: http.MethodGet, Path: "/export", Summary: "Export session telemetry as a zip of Parquet files",
+		Params: []Param{
+			{Name: "format", In: "query", Type: "string", Description: "Export format", Enum: []string{"parquet"}},
+			QueryParam("resources", "string", "Comma separated resources to export, car_data,laps,location when left out"),
+		},
+		ContentType: "application/zip",
+		Handler:     h.export.ExportHandler,
+	})
+	s.Handle(Route{
+		Method: http.MethodGet, Path: "/laps", Summary: "Laps of a session",
+		Params: []Param{driverNumberParam}, Response: []openf1.Lap{}, Negotiated: true,
+		Handler: h.lap.LapsHandler,
+	})
+	s.Handle(Route{
+		Method: http.MethodGet, Path: "/telemetry", Summary: "Car telemetry samples of a session",
+		Params: []Param{driverNumberParam}, Response: []openf1.CarData{}, Negotiated: true,
+		Handler: h.telemetry.TelemetryHandler,
+	})
+
+	// season wide views
+	seasons := reg.Group("/seasons/{year}", "seasons", yearParam)
+	seasons.Handle(Route{
+		Method: http.MethodGet, Path: "/standings/drivers", Summary: "Drivers' championship standings",
+		Response: season.DriverStandings{}, Negotiated: true,
+		Handler: h.season.DriverStandingsHandler,
+	})
+	seasons.Handle(Route{
+		Method: http.MethodGet, Path: "/standings/constructors", Summary: "Constructors' championship standings",
+		Response: season.ConstructorStandings{}, Negotiated: true,
+		Handler: h.season.ConstructorStandingsHandler,
+	})
+	seasons.Handle(Route{
+		Method: http.MethodGet, Path: "/calendar", Summary: "Season calendar",
+		Params: []Param{
+			tzParam,
+			{Name: "format", In: "query", Type: "string", Description: "Response format, ics for iCalendar", Enum: []string{"json", "ndjson", "csv", "ics"}},
+		},
+		Response: season.Calendar{}, Negotiated: true,
+		Handler: h.season.CalendarHandler,
+	})
+	seasons.Handle(Route{
+		Method: http.MethodGet, Path: "/calendar.ics", Summary: "Season calendar as iCalendar to subscribe to",
+		ContentType: "text/calendar",
+		Handler:     h.season.CalendarICSHandler,
+	})
+
+	// sessions, meetings, drivers, laps and stints in one query
+	graphQL := reg.Group("/graphql", "graphql")
+	graphQL.Handle(Route{
+		Method: http.MethodGet, Summary: "Run a GraphQL query",
+		Params: []Param{
+			{Name: "query", In: "query", Type: "string", Description: "GraphQL query", Required: true},
+			QueryParam("operationName", "string", "Operation to run when the query has several"),
+			QueryParam("variables", "string", "JSON encoded variables"),
+		},
+		Response: graphql.Result{},
+		Handler:  h.gql.GraphQLHandler,
+	})
+	graphQL.Handle(Route{
+		Method: http.MethodPost, Summary: "Run a GraphQL query",
+		Request: gql.Request{}, Response: graphql.Result{},
+		Handler: h.gql.GraphQLHandler,
+	})
+
+	// live telemetry subscriptions over a websocket
+	reg.Handle(Route{
+		Method: http.MethodGet, Path: "/ws", Tag: "live", Summary: "Upgrade to a websocket to subscribe to live topics",
+		Status:  http.StatusSwitchingProtocols,
+		Handler: h.ws.WebSocketHandler,
+	})
 }
@@ -0,0 +1,23 @@
+package router
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// NormaliseSlashes redirects paths with a trailing slash to the same path without one, /api/v1/sessions/ to /api/v1/sessions
+// routes are only registered without the slash, 308 keeps the method and body so POSTs follow it too
+func NormaliseSlashes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" || !strings.HasSuffix(r.URL.Path, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u := *r.URL
+		// cleaning also collapses a leading //, which would otherwise redirect to another host
+		u.Path = path.Clean(u.Path)
+		u.RawPath = ""
+		http.Redirect(w, r, u.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
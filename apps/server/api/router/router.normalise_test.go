@@ -0,0 +1,45 @@
+package router_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"telem-api-server/api/router"
+)
+
+func TestNormaliseSlashes(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	tests := []struct {
+		method   string
+		target   string
+		location string
+	}{
+		{method: http.MethodGet, target: "/api/v1/sessions"},
+		{method: http.MethodGet, target: "/"},
+		{method: http.MethodGet, target: "/api/v1/sessions/", location: "/api/v1/sessions"},
+		// the query comes along
+		{method: http.MethodGet, target: "/api/v1/sessions/?limit=5", location: "/api/v1/sessions?limit=5"},
+		// a 308 is followed with the same method and body
+		{method: http.MethodPost, target: "/api/v1/graphql/", location: "/api/v1/graphql"},
+		// not a redirect to another host
+		{method: http.MethodGet, target: "//evil.example/", location: "/evil.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.NormaliseSlashes(next).ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if tt.location == "" {
+				if w.Code != http.StatusOK {
+					t.Errorf("status = %d, want the request passed through", w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.location {
+				t.Errorf("status = %d, location = %q, want a 308 to %q", w.Code, w.Header().Get("Location"), tt.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package router
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"telem-api-server/api/response"
+)
+
+// Info is the info block of the OpenAPI document
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemas builds JSON schemas from Go types the way encoding/json would encode them
+// named structs become components so a type used by several routes is only described once
+type schemas struct {
+	components map[string]any
+	names      map[reflect.Type]string
+	taken      map[string]reflect.Type
+}
+
+func newSchemas() *schemas {
+	return &schemas{
+		components: map[string]any{},
+		names:      map[reflect.Type]string{},
+		taken:      map[string]reflect.Type{},
+	}
+}
+
+func (s *schemas) of(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(s.of(t.Elem()))
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + s.component(t)}
+	}
+	// interfaces and anything else can hold any value
+	return map[string]any{}
+}
+
+// component registers a named struct and returns its component name
+// names are the type's own name, prefixed with its package when two packages use the same one
+func (s *schemas) component(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, t.Name())
+	if other, ok := s.taken[name]; ok && other != t {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	s.names[t] = name
+	s.taken[name] = t
+	// the name is taken before the properties are built so self referencing types end in a $ref
+	s.components[name] = s.object(t)
+	return name
+}
+
+func (s *schemas) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	s.fields(t, properties, &required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// fields adds the json fields of t, embedded structs without a json name are flattened like encoding/json does
+// fields without omitempty are always encoded so they are required, pointers among them are nullable
+func (s *schemas) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.fields(embedded, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.of(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// nullable allows null alongside the schema, refs can't carry a type so they're wrapped in anyOf
+func nullable(schema map[string]any) map[string]any {
+	switch typ := schema["type"].(type) {
+	case string:
+		schema["type"] = []string{typ, "null"}
+		return schema
+	case nil:
+		if _, ok := schema["$ref"]; !ok {
+			// already accepts anything
+			return schema
+		}
+	}
+	return map[string]any{"anyOf": []any{schema, map[string]any{"type": "null"}}}
+}
+
+// negotiated parameters are read by response.Write on every route that uses it
+var negotiatedParams = []Param{
+	{Name: "format", In: "query", Type: "string", Description: "Response format, overrides the Accept header", Enum: []string{"json", "ndjson", "csv"}},
+	QueryParam("fields", "string", "Comma separated json field names to return, all fields when left out"),
+}
+
+func parameters(route Route) []any {
+	params := route.Params
+	if route.Negotiated {
+		for _, param := range negotiatedParams {
+			if !hasParam(params, param.Name) {
+				params = append(params, param)
+			}
+		}
+	}
+	list := []any{}
+	for _, param := range params {
+		schema := map[string]any{"type": param.Type}
+		if len(param.Enum) > 0 {
+			schema["enum"] = param.Enum
+		}
+		p := map[string]any{
+			"name":     param.Name,
+			"in":       param.In,
+			"required": param.Required,
+			"schema":   schema,
+		}
+		if param.Description != "" {
+			p["description"] = param.Description
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+func hasParam(params []Param, name string) bool {
+	for _, param := range params {
+		if param.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *schemas) content(route Route) map[string]any {
+	if route.ContentType != "" {
+		schema := map[string]any{"type": "string"}
+		if route.Response != nil {
+			schema = s.of(reflect.TypeOf(route.Response))
+		}
+		return map[string]any{route.ContentType: map[string]any{"schema": schema}}
+	}
+	if route.Response == nil {
+		return nil
+	}
+
+	t := reflect.TypeOf(route.Response)
+	content := map[string]any{string(response.JSON): map[string]any{"schema": s.of(t)}}
+	if route.Negotiated {
+		// NDJSON is one row per line, each line is an element of the list or the value itself
+		row := t
+		if row.Kind() == reflect.Slice || row.Kind() == reflect.Array {
+			row = row.Elem()
+		}
+		content[string(response.NDJSON)] = map[string]any{"schema": s.of(row)}
+		content[string(response.CSV)] = map[string]any{"schema": map[string]any{"type": "string"}}
+	}
+	return content
+}
+
+// operationID is the method followed by the path's segments, e.g. getSessionsKeyGrid for GET /sessions/{key}/grid
+func operationID(route Route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(route.Method))
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// Document generates the OpenAPI 3.1 document describing every registered route
+func (reg *Registry) Document(info Info) map[string]any {
+	s := newSchemas()
+	paths := map[string]map[string]any{}
+	for _, route := range reg.Routes() {
+		operation := map[string]any{
+			"operationId": operationID(route),
+			"parameters":  parameters(route),
+		}
+		if route.Summary != "" {
+			operation["summary"] = route.Summary
+		}
+		if route.Tag != "" {
+			operation["tags"] = []string{route.Tag}
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					string(response.JSON): map[string]any{"schema": s.of(reflect.TypeOf(route.Request))},
+				},
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if content := s.content(route); content != nil {
+			success["content"] = content
+		}
+		operation["responses"] = map[string]any{
+			// the status is a string key in OpenAPI
+			strconv.Itoa(status): success,
+			// errors are written with http.Error
+			"default": map[string]any{
+				"description": "Error",
+				"content": map[string]any{
+					"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+				},
+			},
+		}
+
+		if paths[route.Path] == nil {
+			paths[route.Path] = map[string]any{}
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi":    "3.1.0",
+		"info":       info,
+		"paths":      paths,
+		"components": map[string]any{"schemas": s.components},
+	}
+}
+
+// OpenAPIHandler serves the document, generated on the first request once every route has been registered
+func OpenAPIHandler(reg *Registry, info Info) http.HandlerFunc {
+	var once sync.Once
+	var document []byte
+	var err error
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			document, err = json.MarshalIndent(reg.Document(info), "", "  ")
+		})
+		if err != nil {
+			log.Printf("Error encoding openapi document: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	}
+}
@@ -0,0 +1,141 @@
+package router_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"telem-api-server/api/router"
+	"telem-api-server/config"
+)
+
+var update = flag.Bool("update", false, "write the served OpenAPI document to testdata")
+
+// TestOpenAPIGolden keeps the API's contract from changing unnoticed
+// after changing a route on purpose, regenerate the document with go test ./api/router -run OpenAPIGolden -update
+func TestOpenAPIGolden(t *testing.T) {
+	srv := httptest.NewServer(router.SetupRoutes(config.Default(), router.Deps{}))
+	t.Cleanup(srv.Close)
+	response, err := http.Get(srv.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var got bytes.Buffer
+	got.ReadFrom(response.Body)
+	got.WriteByte('\n')
+
+	golden := filepath.Join("testdata", "openapi.json")
+	if *update {
+		if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("/openapi.json differs from %s, run with -update if the change is meant", golden)
+	}
+}
+
+type stint struct {
+	Compound string `json:"compound"`
+}
+
+type Meta struct {
+	Year int `json:"year"`
+}
+
+type lap struct {
+	Meta
+	LapNumber int       `json:"lap_number"`
+	Duration  *float64  `json:"duration"`
+	Stint     *stint    `json:"stint"`
+	DateStart time.Time `json:"date_start"`
+	Segments  []int     `json:"segments,omitempty"`
+	Next      *lap      `json:"next,omitempty"`
+	Hidden    string    `json:"-"`
+	Internal  string    `json:"-,"`
+	private   string
+}
+
+func TestDocumentSchemas(t *testing.T) {
+	reg := router.NewRegistry(http.NewServeMux())
+	reg.Group("/sessions/{key}", "sessions", router.PathParam("key", "integer", "session key")).Handle(router.Route{
+		Method: http.MethodGet, Path: "/laps", Response: []lap{}, Negotiated: true,
+		Handler: func(w http.ResponseWriter, r *http.Request) {},
+	})
+	data, err := json.Marshal(reg.Document(router.Info{Title: "test", Version: "1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Tags        []string
+			Parameters  []struct{ Name, In string }
+			Responses   map[string]struct {
+				Content map[string]struct{ Schema map[string]any }
+			}
+		}
+		Components struct{ Schemas map[string]map[string]any }
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+
+	operation := document.Paths["/sessions/{key}/laps"]["get"]
+	if operation.OperationID != "getSessionsKeyLaps" || !reflect.DeepEqual(operation.Tags, []string{"sessions"}) {
+		t.Errorf("operation id = %s, tags = %v", operation.OperationID, operation.Tags)
+	}
+	var params []string
+	for _, param := range operation.Parameters {
+		params = append(params, param.In+":"+param.Name)
+	}
+	// negotiated routes take format= and fields= as well as the route's own
+	if want := []string{"path:key", "query:format", "query:fields"}; !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %v, want %v", params, want)
+	}
+	content := operation.Responses["200"].Content
+	for _, media := range []string{"application/json", "application/x-ndjson", "text/csv"} {
+		if _, ok := content[media]; !ok {
+			t.Errorf("no %s response", media)
+		}
+	}
+	// NDJSON lines are single rows
+	if ref := content["application/x-ndjson"].Schema["$ref"]; ref != "#/components/schemas/lap" {
+		t.Errorf("ndjson schema = %v, want a lap", content["application/x-ndjson"].Schema)
+	}
+
+	schema := document.Components.Schemas["lap"]
+	want := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			// embedded fields are flattened like encoding/json does
+			"year":       map[string]any{"type": "integer"},
+			"lap_number": map[string]any{"type": "integer"},
+			"duration":   map[string]any{"type": []any{"number", "null"}},
+			"stint":      map[string]any{"anyOf": []any{map[string]any{"$ref": "#/components/schemas/stint"}, map[string]any{"type": "null"}}},
+			"date_start": map[string]any{"type": "string", "format": "date-time"},
+			"segments":   map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+			// a type refers to itself by name
+			"next": map[string]any{"anyOf": []any{map[string]any{"$ref": "#/components/schemas/lap"}, map[string]any{"type": "null"}}},
+			"-":    map[string]any{"type": "string"},
+		},
+		// omitempty fields may be left out of the encoding
+		"required": []any{"year", "lap_number", "duration", "stint", "date_start", "-"},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		got, _ := json.MarshalIndent(schema, "", "  ")
+		t.Errorf("lap schema = %s", got)
+	}
+}
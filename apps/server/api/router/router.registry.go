@@ -0,0 +1,92 @@
+package router
+
+import (
+	"net/http"
+)
+
+// Param is a path, query or header parameter a route reads
+type Param struct {
+	Name        string
+	In          string // path, query or header
+	Type        string // integer, number, string or boolean
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+func PathParam(name, typ, description string) Param {
+	return Param{Name: name, In: "path", Type: typ, Description: description, Required: true}
+}
+
+func QueryParam(name, typ, description string) Param {
+	return Param{Name: name, In: "query", Type: typ, Description: description}
+}
+
+func HeaderParam(name, description string) Param {
+	return Param{Name: name, In: "header", Type: "string", Description: description}
+}
+
+// Route is a handler and the contract it serves, the OpenAPI document is generated from these
+type Route struct {
+	Method string
+	// Path is relative to the group the route is registered on, e.g. /grid on /api/v1/sessions/{key}
+	Path    string
+	Summary string
+	Tag     string
+	Params  []Param
+	// Request is a value of the JSON request body's type, nil when there is no body
+	Request any
+	// Response is a value of the response body's type, encoded as JSON unless ContentType is set
+	Response any
+	// ContentType is the media type of responses that aren't JSON, e.g. text/calendar
+	ContentType string
+	// Status is the success status, 200 when left out
+	Status int
+	// Negotiated routes write through response.Write, so they also answer with NDJSON and CSV and take fields=
+	Negotiated bool
+	Handler    http.HandlerFunc
+}
+
+// Registry registers routes on a mux as method patterns, e.g. GET /api/v1/sessions/{key}, and keeps them so they can be described
+// the mux answers a path registered for other methods with a 405 and an Allow header, so handlers don't check the method
+type Registry struct {
+	mux    *http.ServeMux
+	routes *[]Route
+	prefix string
+	tag    string
+	params []Param
+}
+
+func NewRegistry(mux *http.ServeMux) *Registry {
+	return &Registry{mux: mux, routes: &[]Route{}}
+}
+
+// Group returns a registry for routes nested under prefix, they share this registry's mux and routes
+// params are the path parameters the prefix adds, every route of the group takes them before its own
+// tag is used for routes that don't set one, empty keeps the parent's
+func (reg *Registry) Group(prefix, tag string, params ...Param) *Registry {
+	group := *reg
+	group.prefix = reg.prefix + prefix
+	if tag != "" {
+		group.tag = tag
+	}
+	group.params = append(append([]Param{}, reg.params...), params...)
+	return &group
+}
+
+// Handle registers the route's handler on its method and full path and records the route
+// the group's own path is registered with an empty Path
+func (reg *Registry) Handle(route Route) {
+	route.Path = reg.prefix + route.Path
+	if route.Tag == "" {
+		route.Tag = reg.tag
+	}
+	route.Params = append(append([]Param{}, reg.params...), route.Params...)
+	reg.mux.HandleFunc(route.Method+" "+route.Path, route.Handler)
+	*reg.routes = append(*reg.routes, route)
+}
+
+// Routes returns the documented routes in the order they were registered
+func (reg *Registry) Routes() []Route {
+	return *reg.routes
+}
@@ -0,0 +1,71 @@
+package router_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"telem-api-server/api/router"
+)
+
+func TestRegistryGroups(t *testing.T) {
+	mux := http.NewServeMux()
+	reg := router.NewRegistry(mux)
+	key := router.PathParam("key", "integer", "session key")
+	driver := router.QueryParam("driver_number", "integer", "driver")
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Pattern)) }
+
+	sessions := reg.Group("/api/v1", "").Group("/sessions", "sessions")
+	sessions.Handle(router.Route{Method: http.MethodGet, Handler: ok})
+	session := sessions.Group("/{key}", "", key)
+	session.Handle(router.Route{Method: http.MethodGet, Path: "/laps", Params: []router.Param{driver}, Handler: ok})
+	session.Handle(router.Route{Method: http.MethodGet, Path: "/live", Tag: "live", Handler: ok})
+
+	type registered struct {
+		Path   string
+		Tag    string
+		Params []string
+	}
+	var got []registered
+	for _, route := range reg.Routes() {
+		r := registered{Path: route.Path, Tag: route.Tag}
+		for _, param := range route.Params {
+			r.Params = append(r.Params, param.Name)
+		}
+		got = append(got, r)
+	}
+	// groups add their prefix, the tag unless the route has its own and their params ahead of the route's
+	want := []registered{
+		{Path: "/api/v1/sessions", Tag: "sessions"},
+		{Path: "/api/v1/sessions/{key}/laps", Tag: "sessions", Params: []string{"key", "driver_number"}},
+		{Path: "/api/v1/sessions/{key}/live", Tag: "live", Params: []string{"key"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("routes = %+v, want %+v", got, want)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+		allow  string
+	}{
+		{method: http.MethodGet, path: "/api/v1/sessions", status: http.StatusOK, body: "GET /api/v1/sessions"},
+		{method: http.MethodGet, path: "/api/v1/sessions/9158/laps", status: http.StatusOK, body: "GET /api/v1/sessions/{key}/laps"},
+		// the mux turns away other methods, the handlers don't have to
+		{method: http.MethodPost, path: "/api/v1/sessions", status: http.StatusMethodNotAllowed, allow: "GET, HEAD"},
+		{method: http.MethodGet, path: "/sessions", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s Allow = %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package router_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"telem-api-server/api/router"
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+func newAPIServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sessions" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]openf1.Session{
+			{SessionKey: 9158, SessionName: "Race", SessionType: "Race", DateStart: time.Date(2023, 9, 17, 12, 0, 0, 0, time.UTC)},
+		})
+	}))
+	t.Cleanup(upstream.Close)
+	client := openf1.NewClient(upstream.URL)
+	srv := httptest.NewServer(router.SetupRoutes(config.Default(), router.Deps{Source: store.NewSource(client, nil, time.Minute), Upstream: client}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return response, string(body)
+}
+
+func TestVersionedAndUnversionedRoutes(t *testing.T) {
+	srv := newAPIServer(t)
+	versioned, body := get(t, srv.URL+"/api/v1/sessions")
+	if versioned.StatusCode != http.StatusOK || !strings.Contains(body, "9158") {
+		t.Fatalf("/api/v1/sessions = %d %s", versioned.StatusCode, body)
+	}
+	// the first clients' paths answer the same
+	unversioned, same := get(t, srv.URL+"/sessions")
+	if unversioned.StatusCode != http.StatusOK || same != body {
+		t.Errorf("/sessions = %d %s, want the same as /api/v1/sessions", unversioned.StatusCode, same)
+	}
+
+	// but only the versioned ones are documented
+	_, document := get(t, srv.URL+"/openapi.json")
+	var doc struct {
+		Paths map[string]any
+	}
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for path := range doc.Paths {
+		if !strings.HasPrefix(path, router.APIPrefix+"/") {
+			t.Errorf("%s is documented", path)
+		}
+	}
+	if _, ok := doc.Paths["/api/v1/sessions/{key}/laps"]; !ok {
+		t.Error("/api/v1/sessions/{key}/laps isn't documented")
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	srv := newAPIServer(t)
+	// the client follows the 308 to the registered path
+	response, body := get(t, srv.URL+"/api/v1/sessions/")
+	if response.StatusCode != http.StatusOK || response.Request.URL.Path != "/api/v1/sessions" || !strings.Contains(body, "9158") {
+		t.Errorf("GET /api/v1/sessions/ ended at %s with %d, want the sessions", response.Request.URL.Path, response.StatusCode)
+	}
+	// the home page keeps its slash
+	if response, body := get(t, srv.URL+"/"); response.StatusCode != http.StatusOK || body != "Hello, World!" {
+		t.Errorf("GET / = %d %q", response.StatusCode, body)
+	}
+}
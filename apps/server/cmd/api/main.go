@@ -2,431 +2,199 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
+	// embed the IANA zone database so tz= works on hosts without one installed
+	_ "time/tzdata"
 
+	"telem-api-server/api/middleware"
+	"telem-api-server/api/resource/health"
+	"telem-api-server/api/resource/ws"
 	"telem-api-server/api/router"
-
-	"github.com/joho/godotenv"
+	"telem-api-server/config"
+	"telem-api-server/ingest"
+	"telem-api-server/metrics"
+	"telem-api-server/openf1"
+	"telem-api-server/pubsub"
+	"telem-api-server/server"
+	"telem-api-server/store"
+	"telem-api-server/store/sqlite"
+	"telem-api-server/tracing"
 )
 
-// a response struct to map response from API
-
-type Session struct {
-	CircuitKey       int    `json:"circuit_key"`
-	CircuitShortName string `json:"circuit_short_name"`
-	CountryCode      string `json:"country_code"`
-	CountryKey       int    `json:"country_key"`
-	CountryName      string `json:"country_name"`
-	DateEnd          string `json:"date_end"`
-	DateStart        string `json:"date_start"`
-	Location         string `json:"location"`
-	MeetingKey       int    `json:"meeting_key"`
-	SessionKey       int    `json:"session_key"`
-	SessionName      string `json:"session_name"`
-	SessionType      string `json:"session_type"`
-	Year             int    `json:"year"`
-}
-
-var (
-	sessionsCache  = make(map[int]Session)
-	nextSessionKey int
-	sessionsMu     sync.Mutex
-)
-
-type Lap struct {
-	MeetingKey   int       `json:"meeting_key"`
-	SessionKey   int       `json:"session_key"`
-	DriverNumber int       `json:"driver_number"`
-	LapNumber    int       `json:"lap_number"`
-	DateStart    time.Time `json:"date_start"`
-	DurationS1   *float64  `json:"duration_sector_1"`
-	DurationS2   *float64  `json:"duration_sector_2"`
-	DurationS3   *float64  `json:"duration_sector_3"`
-	SpeedI1      *int      `json:"i1_speed"`
-	SpeedI2      *int      `json:"i2_speed"`
-	IsPitOutLap  bool      `json:"is_pit_out_lap"`
-	LapDuration  *float64  `json:"lap_duration"`
-	SegmentsS1   []int     `json:"segments_sector_1"`
-	SegmentsS2   []int     `json:"segments_sector_2"`
-	SegmentsS3   []int     `json:"segments_sector_3"`
-	StSpeed      *int      `json:"st_speed"`
-}
-
-type SessionKeysOnly struct {
-	SessionKey       int
-	CircuitKey       int
-	MeetingKey       int
-	CircuitShortName string
-	DateRange        string
-}
-
-// while we're able to use the multiple instances, we'll need a more improved way to perform operator overloading
-type PaginationConfig struct {
-	Skip          int
-	Limit         int
-	HasPagination bool
-}
-
-type KeyOnlyConfig struct {
-	HasKeysOnly bool
-}
-
-// learned that in Go we should pretty much separate everything as much as possible
-// this is probably a more idiomatic way to learn Go
-//
-
-// Helper Functions
-func ParsePaginationFromRequest(r *http.Request) (PaginationConfig, error) {
-	// why is everything only a single letter
-	// if there is a skip and limit we return it as a config element
-	skipStr := r.URL.Query().Get("skip")
-	limitStr := r.URL.Query().Get("limit")
-
-	config := PaginationConfig{}
-	if skipStr == "" && limitStr == "" {
-		return config, nil
-	}
-	// now we need to actually parse these values
-	config.HasPagination = true // we know that there is pagination in the request URL
-	if skipStr != "" {
-		// set the value
-		skip, err := parseIntParam(skipStr, 0)
-		if err != nil || skip < 0 {
-			return config, fmt.Errorf("invalid skip parameter")
-		}
-		config.Skip = skip
-	}
-
-	if limitStr != "" {
-		// set the value
-		// liimit
-		limit, err := parseIntParam(limitStr, 100)
-		if err != nil || limit < 0 {
-			return config, fmt.Errorf("invalid limit parameter")
-		}
-		config.Limit = limit
+// newLogger writes JSON lines when format is json, for log collectors, and key=value text otherwise
+func newLogger(format string) *slog.Logger {
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	}
-	return config, nil
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
 }
 
-// may not need this function
-func ParseKeysOnlyFromRequest(r *http.Request) (KeyOnlyConfig, error) {
-	keyStr := r.URL.Query().Get("keysOnly")
-	KeyConfig := KeyOnlyConfig{}
-
-	if keyStr != "true" {
-		KeyConfig.HasKeysOnly = false
-		return KeyConfig, nil
-	}
-	KeyConfig.HasKeysOnly = true
-	return KeyConfig, nil
-}
-
-func fetchSessions(BaseUrl string) ([]Session, error) {
-	if BaseUrl == "" {
-		fmt.Println("BaseUrl is empty, unable to make request")
-		return nil, fmt.Errorf("baseUrl is empty, unable to make request")
-	}
-	response, urlErr := http.Get(fmt.Sprintf("%s/sessions", BaseUrl))
-	if urlErr != nil {
-		fmt.Println("Error fetching sessions: ", urlErr)
-		return nil, fmt.Errorf("error fetching sessions %d", urlErr)
-	}
-	// always make sure to close the response body
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusOK {
-		log.Fatalf("API Response Status Code: %d", response.StatusCode)
-		return nil, fmt.Errorf("Response Status Code: %d", response.StatusCode)
+// newClient is the openf1 client requests, live polling and the cache warmer all share, so they share its breaker and limiter
+// live polling goes ahead of client requests in the limiter's queue, a nil limiter doesn't limit
+func newClient(cfg *config.Config, limiter *openf1.Limiter) *openf1.Client {
+	retry := openf1.DefaultRetry
+	retry.MaxAttempts = cfg.OpenF1.MaxAttempts
+	retry.Timeout = cfg.OpenF1.Timeout
+	client := &openf1.Client{
+		BaseUrl:    cfg.OpenF1.URL,
+		HTTPClient: http.DefaultClient,
+		Retry:      retry,
+		Breaker:    openf1.NewBreaker(cfg.OpenF1.BreakerThreshold, cfg.OpenF1.BreakerCooldown),
 	}
-
-	responseData, responseErr := io.ReadAll(response.Body)
-	if responseErr != nil {
-		fmt.Println("Error reading response body: ", responseErr)
-		return nil, fmt.Errorf("error reading response body: %s", responseErr)
-	}
-
-	var sessions []Session
-	err := json.Unmarshal(responseData, &sessions)
-	if err != nil {
-		fmt.Println("Error unmarshalling response data: ", err)
-		return nil, fmt.Errorf("error unmarshalling response data: %s", err)
-	}
-	return sessions, nil
-}
-
-func FormatSessions(sessions []Session) string {
-	var formattedSessions string
-	for _, session := range sessions {
-		formattedSessions += fmt.Sprintf("Session ID: %d, Circuit Name: %s\n", session.SessionKey, session.CircuitShortName)
-	}
-	return formattedSessions
-}
-
-func FindSessionById(sessions []Session, id int) *Session {
-	var session *Session
-	for _, s := range sessions {
-		if s.SessionKey == id {
-			session = &s
-			return session
-		}
-	}
-	return nil
-}
-
-func parseIntParam(param string, defaultValue int) (int, error) {
-	// parse query params as ints
-	if param == "" {
-		return defaultValue, nil
-	}
-	return strconv.Atoi(param)
-}
-
-// TODO: create a method that will extract only the keys from the list of sessions
-// func FetchSessionKeysOnly(sessions []Session) ([]int, err)
-
-// Session Handlers
-func sessionsHandler(w http.ResponseWriter, r *http.Request) {
-	// extract optional parameters skip & limit
-	// these are optional parameters
-	switch r.Method {
-	case http.MethodGet:
-		handleGetSessions(w, r)
-	default:
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
-	}
-}
-
-func sessionHandler(w http.ResponseWriter, r *http.Request) {
-	// extract the sessionKey from the URL path
-	id, err := strconv.Atoi(r.URL.Path[len("/sessions/"):])
-	if err != nil {
-		http.Error(w, "Invalid session Key Id", http.StatusBadRequest)
-		return
-	}
-	switch r.Method {
-	case http.MethodGet:
-		handleGetSession(w, r, id)
-	default:
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
-	}
-}
-
-func sessionKeyHandler(w http.ResponseWriter, r *http.Request) {
-	// extract the keys only handler from the
-	switch r.Method {
-	case http.MethodGet:
-		handleGetSessionKeys(w, r)
-	default:
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	if limiter != nil {
+		client.Limiter = limiter
 	}
+	return client
 }
 
-// business logic of the handler methods
-func handleGetSessions(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Handling our /sessions gets")
-	// parse the query parameters
-	PageConfig, err := ParsePaginationFromRequest(r)
+// serveLimiter lets a backfill queue on limiter at addr, behind every call of the server's own
+// stop ends the backfill calls still waiting, they are refused until the server is back
+func serveLimiter(addr string, limiter *openf1.Limiter) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		http.Error(w, "invalid query parameters", http.StatusBadRequest)
-		return
+		return nil, err
 	}
-	if PageConfig.HasPagination {
-		handleGetSessionsWithPagination(w, r, PageConfig.Skip, PageConfig.Limit)
-		return
-	}
-	// if the skip and limit are empty strings then we just return without pagination
-	handleSessionsNoPagination(w, r)
-}
-
-func handleSessionsNoPagination(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	// fetch our session data from our openf1 api
-	openF1Url := os.Getenv("OPENF1_API_URL")
-
-	sessions, err := fetchSessions(openF1Url)
-	if err != nil {
-		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
-		return
-	}
-
-	// An optional operation is to cache the session info
-	sessionsMu.Lock()
-	// defer sessionsMu.Unlock()
-	for _, session := range sessions {
-		sessionsCache[session.SessionKey] = session
-	}
-	sessionsMu.Unlock()
-	// encode and send response
-	if err := json.NewEncoder(w).Encode(sessions); err != nil {
-		log.Printf("Error encoding sessions: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
-		return
-	}
-}
-
-func handleGetSessionsWithPagination(w http.ResponseWriter, r *http.Request, skip int, limit int) {
-	// this function is responsible for fetching sessions with pagination
-	log.Print("Getting sessions with pagination")
-	if skip < 0 || limit < 0 {
-		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-
-	openF1Url := os.Getenv("OPENF1_API_URL")
-	sessions, err := fetchSessions(openF1Url)
-	if err != nil {
-		http.Error(w, "Error fetching session data", http.StatusInternalServerError)
-		return
-	}
-	if skip > len(sessions) {
-		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
-		return
-	}
-
-	// starting index is skip
-	// limit is the number we obtain
-	startIndex := skip
-	endIndex := min(startIndex+limit, len(sessions))
-	sessions = sessions[startIndex:endIndex]
-
-	if err := json.NewEncoder(w).Encode(sessions); err != nil {
-		log.Print("Error encoding sessions: ", err)
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
-		return
-	}
-}
-
-func handleGetSession(w http.ResponseWriter, r *http.Request, id int) {
-	fmt.Println("Handling our /sessions/:id gets")
-	// fetch the sessions
-	openF1Url := os.Getenv("OPENF1_API_URL")
-
-	sessions, err := fetchSessions(openF1Url)
-	if err != nil {
-		http.Error(w, "Error fetching Sessions", http.StatusInternalServerError)
-		return
-	}
-	session := FindSessionById(sessions, id)
-
-	if session == nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
-		return
-	}
-
-	// encode and send a response
-	if err := json.NewEncoder(w).Encode(session); err != nil {
-		log.Printf("Error encoding session: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
-	}
-}
-
-func handleGetSessionKeys(w http.ResponseWriter, r *http.Request) {
-	// here we provide the keys of the sessions, and we provide only that
-	log.Print("fetching sessions/keys/ \n")
-	PageConfig, err := ParsePaginationFromRequest(r)
-	if err != nil {
-		http.Error(w, "invalid query parameters", http.StatusBadRequest)
-	}
-	if PageConfig.HasPagination {
-		handleSessionKeysWithPagination(w, r, PageConfig.Skip, PageConfig.Limit)
-		return
-	}
-	handleSessionKeysNoPagination(w, r)
+	srv := &http.Server{Handler: openf1.LimiterHandler(limiter), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("backfill limiter stopped: %v", err)
+		}
+	}()
+	return func() { srv.Close() }, nil
 }
 
-func handleSessionKeysWithPagination(w http.ResponseWriter, r *http.Request, skip int, limit int) {
-	// fetch session data and return only the keys
-	log.Print("getting sessions/keys with pagination \n")
-	if skip < 0 || limit < 0 {
-		http.Error(w, "invalid pagination parameters", http.StatusBadRequest)
-	}
-	w.Header().Set("Content-Type", "application/json")
+// configPath is a YAML or TOML config file, CONFIG_FILE is used when it isn't given
+var configPath = flag.String("config", "", "path of a YAML or TOML config file, defaults to $CONFIG_FILE")
 
-	openF1Url := os.Getenv("OPENF1_API_URL")
-	sessions, err := fetchSessions(openF1Url)
-	if err != nil {
-		http.Error(w, "Error fetching session data", http.StatusInternalServerError)
-		return
-	}
-	if skip > len(sessions) { // replace with a workaround
-		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
-		return
-	}
-	startIndex := skip
-	endIndex := min(startIndex+limit, len(sessions))
-	sessions = sessions[startIndex:endIndex]
-	var keysOnlyList []SessionKeysOnly
-	for _, s := range sessions {
-		keysOnlyList = append(keysOnlyList, SessionKeysOnly{
-			SessionKey:       s.SessionKey,
-			CircuitKey:       s.CircuitKey,
-			MeetingKey:       s.MeetingKey,
-			CircuitShortName: s.CircuitShortName,
-			DateRange:        s.DateStart + " - " + s.DateEnd,
-		})
-	}
-
-	if err := json.NewEncoder(w).Encode(keysOnlyList); err != nil {
-		log.Printf("Error encoding sessions: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
-		return
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
 	}
 }
 
-func handleSessionKeysNoPagination(w http.ResponseWriter, r *http.Request) {
-	log.Print("fetching sessions/keys/ without pagination")
-	openF1Url := os.Getenv("OPENF1_API_URL")
-
-	sessions, err := fetchSessions(openF1Url)
+// run serves until SIGINT or SIGTERM, then drains the server and flushes the store and traces before returning
+func run() error {
+	// .env and a config file are both optional, every setting has a default or can come from the environment
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		http.Error(w, "error fetching sessions", http.StatusInternalServerError)
-		return
+		return fmt.Errorf("error loading config: %w", err)
 	}
+	// slog becomes the default so the log.Print calls outside the handlers come out in the same format as the access log
+	logger := newLogger(cfg.Log.Format)
+	slog.SetDefault(logger)
 
-	var keysOnlyList []SessionKeysOnly
-	for _, s := range sessions {
-		keysOnlyList = append(keysOnlyList, SessionKeysOnly{
-			SessionKey:       s.SessionKey,
-			CircuitKey:       s.CircuitKey,
-			MeetingKey:       s.MeetingKey,
-			CircuitShortName: s.CircuitShortName,
-			DateRange:        s.DateStart + " - " + s.DateEnd,
-		})
-	}
-
-	if err := json.NewEncoder(w).Encode(keysOnlyList); err != nil {
-		log.Printf("Error encoding session keys: %v", err)
-		http.Error(w, "error encoding response", http.StatusInternalServerError)
-		return
-	}
-}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-func main() {
-	err := godotenv.Load("./.env")
+	// spans go to an OTLP collector or stdout when OTEL_TRACES_EXPORTER asks for them
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing.Exporter)
 	if err != nil {
-		log.Fatal("Error loading .env file: ", err)
+		return fmt.Errorf("error setting up tracing: %w", err)
+	}
+	defer func() {
+		// the spans of the last requests are still buffered
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}()
+
+	hub := pubsub.NewHub()
+	metrics.RegisterHub(hub)
+	// local tools can subscribe to the live feeds over TCP when an address is configured
+	stopPubSub := func() {}
+	if cfg.PubSub.Addr != "" {
+		hubServer := &pubsub.Server{Hub: hub}
+		go func() {
+			if err := hubServer.ListenAndServe(cfg.PubSub.Addr); !errors.Is(err, pubsub.ErrServerClosed) {
+				log.Printf("pub/sub hub stopped: %v", err)
+			}
+		}()
+		stopPubSub = func() { hubServer.Close() }
+	}
+
+	// one bucket for everything that calls openf1, a backfill in another process waits on it too
+	var limiter *openf1.Limiter
+	if cfg.OpenF1.RateLimit > 0 {
+		limiter = openf1.NewLimiter(cfg.OpenF1.RateLimit, float64(cfg.OpenF1.RateBurst), cfg.OpenF1.Weights)
+	}
+	client := newClient(cfg, limiter)
+	stopLimiter := func() {}
+	if limiter != nil && cfg.OpenF1.LimiterAddr != "" {
+		stop, err := serveLimiter(cfg.OpenF1.LimiterAddr, limiter)
+		if err != nil {
+			// requests are limited either way, only a backfill can't share the bucket
+			log.Printf("Error serving the limiter to backfills on %s: %v", cfg.OpenF1.LimiterAddr, err)
+		} else {
+			stopLimiter = stop
+		}
 	}
-	// Sessions APIs
-	// http.HandleFunc("/sessions", sessionsHandler)
-	// http.HandleFunc("/sessions/", sessionHandler)
-	// http.HandleFunc("/sessions/keys", sessionKeyHandler)
 
-	mux := router.SetupRoutes()
-	apiUrl := os.Getenv("API_URL")
-	apiPort := os.Getenv("API_PORT")
-	fmt.Printf("Server is running at %s:%s\n", apiUrl, apiPort)
-
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	// fetched openf1 data is kept in SQLite when a path is configured, otherwise every request goes upstream
+	var st store.Store
+	if cfg.Store.Path != "" {
+		db, err := sqlite.Open(cfg.Store.Path)
+		if err != nil {
+			return fmt.Errorf("error opening store: %w", err)
+		}
+		// closed once the server has drained, so saves from the last requests make it to disk
+		defer func() {
+			if err := db.Close(); err != nil {
+				log.Printf("Error closing store: %v", err)
+			}
+		}()
+		st = db
+	}
+	source := store.NewSource(client, st, cfg.Store.SessionsTTL)
+	feeds := ingest.NewManager(client, hub)
+
+	// /readyz fails until the session list every other resource starts from has been fetched
+	warmer := &health.Warmer{Source: source}
+	go warmer.Warm(ctx)
+
+	sockets := &ws.Handler{Config: cfg, Source: source, Feeds: feeds, Logger: logger}
+
+	handler := middleware.Chain(router.SetupRoutes(cfg, router.Deps{Source: source, Hub: hub, Feeds: feeds, Upstream: client, Warmer: warmer, Logger: logger, WebSocket: sockets}),
+		middleware.RequestID,
+		middleware.Tracing,
+		middleware.AccessLog(logger),
+		middleware.Metrics,
+		middleware.Recover(logger),
+		middleware.Stale,
+	)
+	srv := server.New(server.Config{
+		Addr:               cfg.Server.ListenAddr(),
+		ReadHeaderTimeout:  cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:        cfg.Server.ReadTimeout,
+		WriteTimeout:       cfg.Server.WriteTimeout,
+		IdleTimeout:        cfg.Server.IdleTimeout,
+		MaxHeaderBytes:     cfg.Server.MaxHeaderBytes,
+		ShutdownTimeout:    cfg.Server.ShutdownTimeout,
+		TLSCertFile:        cfg.Server.TLS.CertFile,
+		TLSKeyFile:         cfg.Server.TLS.KeyFile,
+		CertReloadInterval: cfg.Server.TLS.ReloadInterval,
+	}, handler)
+	// event streams and websockets never finish by themselves, ending the live feeds ends the streams
+	srv.OnShutdown(feeds.Stop)
+	srv.OnShutdown(sockets.CloseAll)
+	srv.OnShutdown(stopLimiter)
+	srv.OnShutdown(stopPubSub)
+	if err := srv.Run(ctx); err != nil {
+		return fmt.Errorf("error serving: %w", err)
+	}
+	log.Print("server stopped")
+	return nil
 }
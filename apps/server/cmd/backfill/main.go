@@ -0,0 +1,347 @@
+// backfill downloads openf1 data for whole seasons, meetings or sessions into the local store
+// so the server can run without reaching openf1, e.g. for an offline demo
+//
+//	go run ./cmd/backfill --year 2023
+//	go run ./cmd/backfill --meeting 1216 --concurrency 8
+//	go run ./cmd/backfill --session 9140 --store ./f1.db
+//
+// resources that were already fetched for a finished session are skipped, so an interrupted run
+// can be started again with the same flags and picks up where it stopped
+// while a server is running the backfill queues on its rate limiter, behind every call of the server's own
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"telem-api-server/config"
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+	"telem-api-server/store/sqlite"
+)
+
+// task is a single resource to fetch for a session
+type task struct {
+	resource     string
+	sessionKey   int
+	driverNumber int
+	params       url.Values
+	fetch        func(ctx context.Context) (int, error)
+}
+
+func (t task) String() string {
+	if t.driverNumber != 0 {
+		return fmt.Sprintf("%s session %d driver %d", t.resource, t.sessionKey, t.driverNumber)
+	}
+	return fmt.Sprintf("%s session %d", t.resource, t.sessionKey)
+}
+
+type runner struct {
+	source      *store.Source
+	concurrency int
+
+	done    atomic.Int64
+	skipped atomic.Int64
+	failed  atomic.Int64
+	rows    atomic.Int64
+}
+
+func main() {
+	// the server's configuration fills in whatever isn't passed as a flag
+	configPath := flag.String("config", "", "path of a YAML or TOML config file, defaults to $CONFIG_FILE")
+	year := flag.Int("year", 0, "backfill every session of a season")
+	meeting := flag.Int("meeting", 0, "backfill every session of a meeting")
+	sessionKey := flag.Int("session", 0, "backfill a single session")
+	storePath := flag.String("store", "", "path of the SQLite store, defaults to the configured store path")
+	baseUrl := flag.String("openf1", "", "openf1 API url, defaults to the configured url")
+	concurrency := flag.Int("concurrency", 4, "number of requests to openf1 at once")
+	retries := flag.Int("retries", 3, "times to retry a request that failed with a transient error")
+	limiterAddr := flag.String("limiter", "", "host:port of a running server's limiter to share, defaults to OPENF1_LIMITER_ADDR")
+	flag.Parse()
+	if *year == 0 && *meeting == 0 && *sessionKey == 0 {
+		fmt.Fprintln(os.Stderr, "one of --year, --meeting or --session is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("Error loading config: ", err)
+	}
+	if *storePath == "" {
+		*storePath = cfg.Store.Path
+	}
+	if *baseUrl == "" {
+		*baseUrl = cfg.OpenF1.URL
+	}
+	if *limiterAddr == "" {
+		*limiterAddr = cfg.OpenF1.LimiterAddr
+	}
+	if *storePath == "" {
+		log.Fatal("--store, STORE_PATH or a store path in the config file is required")
+	}
+	if *baseUrl == "" {
+		log.Fatal("--openf1 or OPENF1_API_URL is required")
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+
+	db, err := sqlite.Open(*storePath)
+	if err != nil {
+		log.Fatal("Error opening store: ", err)
+	}
+	defer db.Close()
+
+	// ctrl-c stops handing out tasks, the ones in flight finish so their rows and fetch log entry are saved together
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// the client retries with jitter and waits as long as a 429's Retry-After asks, within backfillMaxDelay
+	// there's no breaker, a backfill should wait out an outage rather than skip sessions behind it
+	retry := openf1.DefaultRetry
+	retry.MaxAttempts = max(*retries, 0) + 1
+	retry.MaxDelay = backfillMaxDelay
+	client := &openf1.Client{BaseUrl: *baseUrl, HTTPClient: http.DefaultClient, Retry: retry}
+	if cfg.OpenF1.RateLimit > 0 {
+		client.Limiter = newLimiter(*limiterAddr, cfg.OpenF1)
+	}
+
+	source := store.NewSource(client, db, cfg.Store.SessionsTTL)
+	r := &runner{source: source, concurrency: *concurrency}
+	if err := r.backfill(ctx, *year, *meeting, *sessionKey); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// backfillMaxDelay is the longest the client waits between attempts, far longer than the server's
+// nobody is waiting on a backfill, so it can sit out a Retry-After rather than record the resource as failed
+const backfillMaxDelay = 2 * time.Minute
+
+// newLimiter queues the backfill on the limiter of the server at addr when one is running, behind all of the server's calls
+// with no server to share with the backfill has the whole rate limit to itself
+func newLimiter(addr string, o config.OpenF1) openf1.RateLimiter {
+	if addr != "" {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			log.Printf("sharing the rate limit of the server at %s", addr)
+			return &openf1.RemoteLimiter{URL: "http://" + addr, HTTPClient: http.DefaultClient}
+		}
+	}
+	log.Print("no server to share the rate limit with, the backfill has all of it")
+	return openf1.NewLimiter(o.RateLimit, float64(o.RateBurst), o.Weights)
+}
+
+func (r *runner) backfill(ctx context.Context, year int, meeting int, sessionKey int) error {
+	sessions, err := r.source.Sessions(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list sessions to backfill: %w", err)
+	}
+	sessions = selectSessions(sessions, year, meeting, sessionKey, time.Now())
+	if len(sessions) == 0 {
+		return fmt.Errorf("no sessions to backfill")
+	}
+	fmt.Printf("backfilling %d sessions\n", len(sessions))
+
+	// meetings are only needed for their names, a failure here isn't worth stopping for
+	years := map[int]bool{}
+	for _, s := range sessions {
+		years[s.Year] = true
+	}
+	for y := range years {
+		if _, err := r.source.Meetings(ctx, y); err != nil {
+			log.Printf("Error fetching meetings for %d: %v", y, err)
+		}
+	}
+
+	// car data and location are fetched per driver so they need the drivers of each session first
+	var tasks []task
+	for _, s := range sessions {
+		tasks = append(tasks, sessionTasks(r.source, s)...)
+	}
+	r.run(ctx, tasks)
+
+	tasks = nil
+	for _, s := range sessions {
+		drivers, err := r.source.Drivers(ctx, s.SessionKey)
+		if err != nil {
+			continue
+		}
+		for _, d := range drivers {
+			tasks = append(tasks, carDataTask(r.source, s.SessionKey, d.DriverNumber), locationTask(r.source, s.SessionKey, d.DriverNumber))
+		}
+	}
+	r.run(ctx, tasks)
+
+	fmt.Printf("done: %d fetched (%d rows), %d already stored, %d failed\n",
+		r.done.Load(), r.rows.Load(), r.skipped.Load(), r.failed.Load())
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted, run again with the same flags to resume")
+	}
+	if r.failed.Load() > 0 {
+		return fmt.Errorf("%d resources failed, run again with the same flags to retry them", r.failed.Load())
+	}
+	return nil
+}
+
+// selectSessions picks the sessions matching every filter that was given, sessions that haven't started have nothing to fetch
+func selectSessions(sessions []openf1.Session, year int, meeting int, sessionKey int, now time.Time) []openf1.Session {
+	var selected []openf1.Session
+	for _, s := range sessions {
+		if year != 0 && s.Year != year {
+			continue
+		}
+		if meeting != 0 && s.MeetingKey != meeting {
+			continue
+		}
+		if sessionKey != 0 && s.SessionKey != sessionKey {
+			continue
+		}
+		if !s.DateStart.IsZero() && s.DateStart.After(now) {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].DateStart.Before(selected[j].DateStart)
+	})
+	return selected
+}
+
+// sessionTasks are everything fetched for a session as a whole, the grid is only set in qualifying and gaps are only kept in races
+func sessionTasks(source *store.Source, s openf1.Session) []task {
+	sessionKey := s.SessionKey
+	tasks := []task{
+		{resource: "drivers", sessionKey: sessionKey, fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.Drivers(ctx, sessionKey)
+			return len(rows), err
+		}},
+		{resource: "laps", sessionKey: sessionKey, fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.Laps(ctx, sessionKey)
+			return len(rows), err
+		}},
+		{resource: "stints", sessionKey: sessionKey, fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.Stints(ctx, sessionKey)
+			return len(rows), err
+		}},
+		{resource: "session_result", sessionKey: sessionKey, fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.SessionResults(ctx, sessionKey)
+			return len(rows), err
+		}},
+		{resource: "race_control", sessionKey: sessionKey, fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.RaceControl(ctx, sessionKey)
+			return len(rows), err
+		}},
+		{resource: "position", sessionKey: sessionKey, fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.Positions(ctx, sessionKey)
+			return len(rows), err
+		}},
+	}
+	switch s.SessionType {
+	case "Qualifying":
+		tasks = append(tasks, task{resource: "starting_grid", sessionKey: sessionKey, fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.StartingGrid(ctx, sessionKey)
+			return len(rows), err
+		}})
+	case "Race":
+		tasks = append(tasks, task{resource: "intervals", sessionKey: sessionKey, fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.Intervals(ctx, sessionKey)
+			return len(rows), err
+		}})
+	}
+	return tasks
+}
+
+func carDataTask(source *store.Source, sessionKey int, driverNumber int) task {
+	params := url.Values{}
+	params.Set("driver_number", strconv.Itoa(driverNumber))
+	return task{resource: "car_data", sessionKey: sessionKey, driverNumber: driverNumber, params: params,
+		fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.CarData(ctx, sessionKey, driverNumber)
+			return len(rows), err
+		}}
+}
+
+func locationTask(source *store.Source, sessionKey int, driverNumber int) task {
+	params := url.Values{}
+	params.Set("driver_number", strconv.Itoa(driverNumber))
+	return task{resource: "location", sessionKey: sessionKey, driverNumber: driverNumber, params: params,
+		fetch: func(ctx context.Context) (int, error) {
+			rows, err := source.Location(ctx, sessionKey, driverNumber)
+			return len(rows), err
+		}}
+}
+
+// run works through the tasks with at most r.concurrency in flight, printing a line as each one finishes
+// once ctx is done no more tasks are started, the ones already started finish without it
+func (r *runner) run(ctx context.Context, tasks []task) {
+	if ctx.Err() != nil {
+		return
+	}
+	var pending []task
+	for _, t := range tasks {
+		if r.source.Complete(ctx, t.resource, t.sessionKey, t.params) {
+			r.skipped.Add(1)
+			continue
+		}
+		pending = append(pending, t)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	// an interrupted fetch would be recorded as a failure, and could leave rows saved without the fetch log entry
+	work := context.WithoutCancel(ctx)
+	queue := make(chan task)
+	var finished atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				start := time.Now()
+				rows, err := t.fetch(work)
+				if errors.Is(err, openf1.ErrNoResults) {
+					err = nil
+				}
+				n := finished.Add(1)
+				if err != nil {
+					r.failed.Add(1)
+					fmt.Printf("[%d/%d] %s failed: %v\n", n, len(pending), t, err)
+					continue
+				}
+				r.done.Add(1)
+				r.rows.Add(int64(rows))
+				fmt.Printf("[%d/%d] %s: %d rows in %s\n", n, len(pending), t, rows, time.Since(start).Round(time.Millisecond))
+			}
+		}()
+	}
+
+feed:
+	for _, t := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case queue <- t:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+func TestSelectSessions(t *testing.T) {
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 15, 0, 0, 0, time.UTC) }
+	sessions := []openf1.Session{
+		{SessionKey: 9472, MeetingKey: 1229, Year: 2024, SessionType: "Race", DateStart: day(2)},
+		{SessionKey: 9468, MeetingKey: 1229, Year: 2024, SessionType: "Qualifying", DateStart: day(1)},
+		{SessionKey: 9480, MeetingKey: 1230, Year: 2024, SessionType: "Race", DateStart: day(9)},
+		{SessionKey: 9158, MeetingKey: 1219, Year: 2023, SessionType: "Race", DateStart: time.Date(2023, 9, 17, 12, 0, 0, 0, time.UTC)},
+		// openf1 lists some sessions before it knows when they start
+		{SessionKey: 9999, MeetingKey: 1231, Year: 2024, SessionType: "Race"},
+	}
+
+	tests := []struct {
+		name       string
+		year       int
+		meeting    int
+		sessionKey int
+		want       []int
+	}{
+		{name: "season, oldest first and nothing yet to start", year: 2024, want: []int{9999, 9468}},
+		{name: "meeting", meeting: 1229, want: []int{9468}},
+		{name: "session", sessionKey: 9158, want: []int{9158}},
+		{name: "every filter has to match", year: 2023, meeting: 1229, want: nil},
+		{name: "session that hasn't started", sessionKey: 9480, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys []int
+			for _, s := range selectSessions(sessions, tt.year, tt.meeting, tt.sessionKey, now) {
+				keys = append(keys, s.SessionKey)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("sessions = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
+
+// fakeClient answers every request with no rows and remembers what was asked for
+type fakeClient struct {
+	mu        sync.Mutex
+	resources []string
+}
+
+func (c *fakeClient) Get(ctx context.Context, resource string, params url.Values, out any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resources = append(c.resources, resource)
+	return json.Unmarshal([]byte("[]"), out)
+}
+
+func (c *fakeClient) requested() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	requested := slices.Clone(c.resources)
+	slices.Sort(requested)
+	return requested
+}
+
+// fakeStore has the fetch log of a previous run, the rest of store.Store isn't used when nothing is saved
+type fakeStore struct {
+	store.Store
+	mu      sync.Mutex
+	fetches map[string]store.Fetch
+}
+
+func (s *fakeStore) GetFetch(ctx context.Context, resource string, scope string) (store.Fetch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fetch, ok := s.fetches[resource+"?"+scope]
+	if !ok {
+		return store.Fetch{}, store.ErrNotFound
+	}
+	return fetch, nil
+}
+
+func (s *fakeStore) SaveFetch(ctx context.Context, fetch store.Fetch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches[fetch.Resource+"?"+fetch.Scope] = fetch
+	return nil
+}
+
+func (s *fakeStore) GetSession(ctx context.Context, sessionKey int) (openf1.Session, error) {
+	return openf1.Session{}, store.ErrNotFound
+}
+
+func TestSessionTasks(t *testing.T) {
+	common := []string{"drivers", "laps", "position", "race_control", "session_result", "stints"}
+	tests := []struct {
+		sessionType string
+		extra       string
+	}{
+		{"Race", "intervals"},
+		{"Qualifying", "starting_grid"},
+		{"Practice", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sessionType, func(t *testing.T) {
+			client := &fakeClient{}
+			source := &store.Source{Client: client}
+			tasks := sessionTasks(source, openf1.Session{SessionKey: 9158, SessionType: tt.sessionType})
+
+			want := slices.Clone(common)
+			if tt.extra != "" {
+				want = append(want, tt.extra)
+			}
+			slices.Sort(want)
+			var resources []string
+			for _, task := range tasks {
+				resources = append(resources, task.resource)
+				if _, err := task.fetch(context.Background()); err != nil {
+					t.Errorf("%s: %v", task, err)
+				}
+			}
+			slices.Sort(resources)
+			if !reflect.DeepEqual(resources, want) {
+				t.Errorf("tasks = %v, want %v", resources, want)
+			}
+			// each task fetches its own resource
+			if requested := client.requested(); !reflect.DeepEqual(requested, want) {
+				t.Errorf("requested = %v, want %v", requested, want)
+			}
+		})
+	}
+}
+
+func TestRunSkipsComplete(t *testing.T) {
+	client := &fakeClient{}
+	// laps and car 1's car data were fetched for good by a run that was interrupted
+	st := &fakeStore{fetches: map[string]store.Fetch{
+		"laps?session_key=9158":                     {Complete: true},
+		"car_data?session_key=9158&driver_number=1": {Complete: true},
+		// fetched before the session was final, so fetched again
+		"stints?session_key=9158": {Complete: false},
+	}}
+	source := &store.Source{Client: client, Store: st, Clock: store.SystemClock{}}
+	r := &runner{source: source, concurrency: 2}
+
+	tasks := sessionTasks(source, openf1.Session{SessionKey: 9158, SessionType: "Practice"})
+	tasks = append(tasks, carDataTask(source, 9158, 1), carDataTask(source, 9158, 44))
+	r.run(context.Background(), tasks)
+
+	want := []string{"car_data", "drivers", "position", "race_control", "session_result", "stints"}
+	if requested := client.requested(); !reflect.DeepEqual(requested, want) {
+		t.Errorf("requested = %v, want %v", requested, want)
+	}
+	if r.skipped.Load() != 2 || r.done.Load() != 6 || r.failed.Load() != 0 {
+		t.Errorf("skipped = %d, done = %d, failed = %d, want 2, 6 and 0", r.skipped.Load(), r.done.Load(), r.failed.Load())
+	}
+}
+
+func TestRunStopsFeedingWhenInterrupted(t *testing.T) {
+	client := &fakeClient{}
+	r := &runner{source: &store.Source{Client: client}, concurrency: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var fetchErr error
+	tasks := []task{
+		{resource: "laps", sessionKey: 9158, fetch: func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			// the task in flight isn't cancelled with the run
+			fetchErr = ctx.Err()
+			return 0, nil
+		}},
+		{resource: "stints", sessionKey: 9158, fetch: func(ctx context.Context) (int, error) {
+			t.Error("task started after the interrupt")
+			return 0, nil
+		}},
+	}
+	go func() {
+		<-started
+		cancel()
+		close(release)
+	}()
+	r.run(ctx, tasks)
+
+	if fetchErr != nil {
+		t.Errorf("task in flight got %v, want its context left alone", fetchErr)
+	}
+	if r.done.Load() != 1 || r.failed.Load() != 0 {
+		t.Errorf("done = %d, failed = %d, want 1 and 0", r.done.Load(), r.failed.Load())
+	}
+}
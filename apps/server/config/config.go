@@ -0,0 +1,375 @@
+// package for the server's configuration, loaded once at startup and handed to whatever needs it
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the server can be configured with
+// later sources override earlier ones: defaults, then .env, then the config file, then the environment
+type Config struct {
+	OpenF1    OpenF1    `yaml:"openf1" toml:"openf1"`
+	Server    Server    `yaml:"server" toml:"server"`
+	Store     Store     `yaml:"store" toml:"store"`
+	Log       Log       `yaml:"log" toml:"log"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+	PubSub    PubSub    `yaml:"pubsub" toml:"pubsub"`
+	WebSocket WebSocket `yaml:"websocket" toml:"websocket"`
+}
+
+type OpenF1 struct {
+	URL string `yaml:"url" toml:"url"`
+	// MaxAttempts includes the first try, Timeout is per attempt for resources without a longer one of their own
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts"`
+	Timeout     time.Duration `yaml:"timeout" toml:"timeout"`
+	// the breaker opens after BreakerThreshold failed calls in a row and tries again after BreakerCooldown
+	BreakerThreshold int           `yaml:"breaker_threshold" toml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown" toml:"breaker_cooldown"`
+	// RateLimit is the calls a second made to openf1 with up to RateBurst at once, 0 doesn't limit them
+	// Weights is what a call to a resource counts as, on top of the defaults where heavy resources count double
+	RateLimit float64            `yaml:"rate_limit" toml:"rate_limit"`
+	RateBurst int                `yaml:"rate_burst" toml:"rate_burst"`
+	Weights   map[string]float64 `yaml:"weights" toml:"weights"`
+	// LimiterAddr is where the server lets cmd/backfill queue on its limiter, so the two share one RateLimit
+	// a backfill's calls queue behind all of the server's, it is off unless set
+	// it has no authentication, keep it on loopback (e.g. localhost:7071) so only processes on the same host can spend the rate limit
+	LimiterAddr string `yaml:"limiter_addr" toml:"limiter_addr"`
+}
+
+type Server struct {
+	// Addr is host:port and takes precedence over Port, which listens on every interface
+	Addr              string        `yaml:"addr" toml:"addr"`
+	Port              int           `yaml:"port" toml:"port"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" toml:"max_header_bytes"`
+	TLS               TLS           `yaml:"tls" toml:"tls"`
+}
+
+// TLS is served when both files are set
+type TLS struct {
+	CertFile       string        `yaml:"cert_file" toml:"cert_file"`
+	KeyFile        string        `yaml:"key_file" toml:"key_file"`
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+type Store struct {
+	// Path of the SQLite store, without one every request goes to openf1 except for the session list
+	Path string `yaml:"path" toml:"path"`
+	// SessionsTTL is how long the session list is served before it's fetched again, kept in memory without a store
+	SessionsTTL time.Duration `yaml:"sessions_ttl" toml:"sessions_ttl"`
+}
+
+type Log struct {
+	// Format is text or json
+	Format string `yaml:"format" toml:"format"`
+}
+
+type Tracing struct {
+	// Exporter is otlp, stdout, console or none
+	Exporter string `yaml:"exporter" toml:"exporter"`
+}
+
+type PubSub struct {
+	// Addr the TCP pub/sub hub listens on, it is off when empty
+	Addr string `yaml:"addr" toml:"addr"`
+}
+
+type WebSocket struct {
+	// AllowedOrigins are allowed to connect on top of same-origin pages, * allows any
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+// Default is the configuration before anything is loaded on top of it
+func Default() *Config {
+	return &Config{
+		OpenF1: OpenF1{
+			URL:              "https://api.openf1.org/v1",
+			MaxAttempts:      3,
+			Timeout:          15 * time.Second,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+			RateLimit:        3,
+			RateBurst:        6,
+		},
+		Server: Server{
+			Port:              8080,
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+			MaxHeaderBytes:    64 << 10,
+			TLS:               TLS{ReloadInterval: time.Minute},
+		},
+		Store:   Store{SessionsTTL: 15 * time.Minute},
+		Log:     Log{Format: "text"},
+		Tracing: Tracing{Exporter: "none"},
+	}
+}
+
+// envVars are the environment variables each setting is read from, .env uses the same names
+var envVars = map[string]func(c *Config) any{
+	"OPENF1_API_URL":             func(c *Config) any { return &c.OpenF1.URL },
+	"OPENF1_MAX_ATTEMPTS":        func(c *Config) any { return &c.OpenF1.MaxAttempts },
+	"OPENF1_TIMEOUT":             func(c *Config) any { return &c.OpenF1.Timeout },
+	"OPENF1_BREAKER_THRESHOLD":   func(c *Config) any { return &c.OpenF1.BreakerThreshold },
+	"OPENF1_BREAKER_COOLDOWN":    func(c *Config) any { return &c.OpenF1.BreakerCooldown },
+	"OPENF1_RATE_LIMIT":          func(c *Config) any { return &c.OpenF1.RateLimit },
+	"OPENF1_RATE_BURST":          func(c *Config) any { return &c.OpenF1.RateBurst },
+	"OPENF1_LIMITER_ADDR":        func(c *Config) any { return &c.OpenF1.LimiterAddr },
+	"API_ADDR":                   func(c *Config) any { return &c.Server.Addr },
+	"API_PORT":                   func(c *Config) any { return &c.Server.Port },
+	"SERVER_READ_HEADER_TIMEOUT": func(c *Config) any { return &c.Server.ReadHeaderTimeout },
+	"SERVER_READ_TIMEOUT":        func(c *Config) any { return &c.Server.ReadTimeout },
+	"SERVER_WRITE_TIMEOUT":       func(c *Config) any { return &c.Server.WriteTimeout },
+	"SERVER_IDLE_TIMEOUT":        func(c *Config) any { return &c.Server.IdleTimeout },
+	"SERVER_SHUTDOWN_TIMEOUT":    func(c *Config) any { return &c.Server.ShutdownTimeout },
+	"SERVER_MAX_HEADER_BYTES":    func(c *Config) any { return &c.Server.MaxHeaderBytes },
+	"TLS_CERT_FILE":              func(c *Config) any { return &c.Server.TLS.CertFile },
+	"TLS_KEY_FILE":               func(c *Config) any { return &c.Server.TLS.KeyFile },
+	"TLS_RELOAD_INTERVAL":        func(c *Config) any { return &c.Server.TLS.ReloadInterval },
+	"STORE_PATH":                 func(c *Config) any { return &c.Store.Path },
+	"SESSIONS_TTL":               func(c *Config) any { return &c.Store.SessionsTTL },
+	"LOG_FORMAT":                 func(c *Config) any { return &c.Log.Format },
+	"OTEL_TRACES_EXPORTER":       func(c *Config) any { return &c.Tracing.Exporter },
+	"PUBSUB_ADDR":                func(c *Config) any { return &c.PubSub.Addr },
+	"WS_ALLOWED_ORIGINS":         func(c *Config) any { return &c.WebSocket.AllowedOrigins },
+}
+
+// Load builds the configuration from the defaults, ./.env if there is one, the YAML or TOML file at path and the environment
+// path falls back to CONFIG_FILE, without either there is no config file
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	dotenv, err := godotenv.Read(".env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading .env: %w", err)
+	}
+	if err := cfg.applyEnv(func(name string) (string, bool) {
+		value, ok := dotenv[name]
+		return value, ok
+	}); err != nil {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		path = dotenv["CONFIG_FILE"]
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("environment: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// libraries configured by their own variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT, still find them in .env
+	for name, value := range dotenv {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+	return cfg, nil
+}
+
+// loadFile decodes a YAML or TOML config file over c, settings it doesn't know about are an error rather than ignored
+func (c *Config) loadFile(path string) error {
+	var decode func(data []byte) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decode = c.decodeYAML
+	case ".toml":
+		decode = c.decodeTOML
+	default:
+		return fmt.Errorf("config file %s is not YAML or TOML, expected a .yaml, .yml or .toml file", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	if err := decode(data); err != nil {
+		return fmt.Errorf("error decoding config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) decodeYAML(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// decodeTOML takes durations as strings the same as YAML, e.g. timeout = "15s"
+func (c *Config) decodeTOML(data []byte) error {
+	meta, err := toml.Decode(string(data), c)
+	if err != nil {
+		return err
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return fmt.Errorf("unknown settings %s", strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// applyEnv sets everything lookup has a value for, names are applied in order so errors come out the same each time
+func (c *Config) applyEnv(lookup func(name string) (string, bool)) error {
+	names := make([]string, 0, len(envVars))
+	for name := range envVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		value, ok := lookup(name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := set(envVars[name](c), value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func set(target any, value string) error {
+	switch target := target.(type) {
+	case *string:
+		*target = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*target = n
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*target = f
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s", value)
+		}
+		*target = d
+	case *[]string:
+		*target = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", target)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once, so a bad deploy is fixed in one go
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	u, err := url.Parse(c.OpenF1.URL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"openf1 url %q is not an absolute http(s) url", c.OpenF1.URL)
+	check(c.OpenF1.MaxAttempts > 0, "openf1 max_attempts has to be at least 1")
+	check(c.OpenF1.Timeout > 0, "openf1 timeout has to be positive")
+	check(c.OpenF1.BreakerThreshold > 0, "openf1 breaker_threshold has to be at least 1")
+	check(c.OpenF1.BreakerCooldown > 0, "openf1 breaker_cooldown has to be positive")
+	check(c.OpenF1.RateLimit >= 0, "openf1 rate_limit can't be negative")
+	check(c.OpenF1.RateBurst > 0, "openf1 rate_burst has to be at least 1")
+	if c.OpenF1.LimiterAddr != "" {
+		check(validAddr(c.OpenF1.LimiterAddr), "openf1 limiter_addr %q is not host:port", c.OpenF1.LimiterAddr)
+	}
+	resources := make([]string, 0, len(c.OpenF1.Weights))
+	for resource := range c.OpenF1.Weights {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	for _, resource := range resources {
+		check(c.OpenF1.Weights[resource] > 0, "openf1 weight for %s has to be positive", resource)
+	}
+
+	if c.Server.Addr != "" {
+		check(validAddr(c.Server.Addr), "server addr %q is not host:port", c.Server.Addr)
+	} else {
+		check(validPort(c.Server.Port), "server port %d is not between 1 and 65535", c.Server.Port)
+	}
+	check(c.Server.ReadHeaderTimeout >= 0, "server read_header_timeout can't be negative")
+	check(c.Server.ReadTimeout >= 0, "server read_timeout can't be negative")
+	check(c.Server.WriteTimeout >= 0, "server write_timeout can't be negative")
+	check(c.Server.IdleTimeout >= 0, "server idle_timeout can't be negative")
+	check(c.Server.ShutdownTimeout > 0, "server shutdown_timeout has to be positive")
+	check(c.Server.MaxHeaderBytes > 0, "server max_header_bytes has to be positive")
+	check((c.Server.TLS.CertFile == "") == (c.Server.TLS.KeyFile == ""), "tls cert_file and key_file have to be set together")
+	check(c.Server.TLS.ReloadInterval > 0, "tls reload_interval has to be positive")
+
+	check(c.Store.SessionsTTL > 0, "store sessions_ttl has to be positive")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log format %q is not text or json", c.Log.Format)
+	switch c.Tracing.Exporter {
+	case "", "none", "otlp", "stdout", "console":
+	default:
+		check(false, "tracing exporter %q is not otlp, stdout, console or none", c.Tracing.Exporter)
+	}
+	return errors.Join(errs...)
+}
+
+// ListenAddr is Addr, or every interface on Port
+func (s Server) ListenAddr() string {
+	if s.Addr != "" {
+		return s.Addr
+	}
+	return ":" + strconv.Itoa(s.Port)
+}
+
+func validPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
+
+func validAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	n, portErr := strconv.Atoi(port)
+	return err == nil && portErr == nil && validPort(n)
+}
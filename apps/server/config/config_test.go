@@ -0,0 +1,215 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"telem-api-server/config"
+)
+
+// settings are the environment variables Load reads, cleared for each test so the host's don't leak in
+var settings = []string{"CONFIG_FILE", "OPENF1_API_URL", "OPENF1_MAX_ATTEMPTS", "OPENF1_TIMEOUT", "API_PORT", "STORE_PATH", "LOG_FORMAT"}
+
+// inDir runs the test from an empty directory holding the given .env and config.yaml, an empty one isn't written
+func inDir(t *testing.T, dotenv string, yaml string) {
+	t.Helper()
+	dir := t.TempDir()
+	if dotenv != "" {
+		if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(dotenv), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if yaml != "" {
+		if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	// an empty value counts as unset, and keeps Load from copying .env into the process environment
+	for _, name := range settings {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		dotenv string
+		yaml   string
+		env    map[string]string
+		url    string
+	}{
+		{name: "defaults", url: "https://api.openf1.org/v1"},
+		{name: ".env over defaults", dotenv: "OPENF1_API_URL=http://dotenv\n", url: "http://dotenv"},
+		{
+			name:   "config file over .env",
+			dotenv: "OPENF1_API_URL=http://dotenv\nCONFIG_FILE=config.yaml\n",
+			yaml:   "openf1:\n  url: http://yaml\n",
+			url:    "http://yaml",
+		},
+		{
+			name:   "environment over the config file",
+			dotenv: "OPENF1_API_URL=http://dotenv\n",
+			yaml:   "openf1:\n  url: http://yaml\n",
+			env:    map[string]string{"CONFIG_FILE": "config.yaml", "OPENF1_API_URL": "http://env"},
+			url:    "http://env",
+		},
+		{
+			name:   "only what is set is overridden",
+			dotenv: "OPENF1_API_URL=http://dotenv\n",
+			yaml:   "openf1:\n  max_attempts: 5\n",
+			env:    map[string]string{"CONFIG_FILE": "config.yaml"},
+			url:    "http://dotenv",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inDir(t, tt.dotenv, tt.yaml)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := config.Load("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.OpenF1.URL != tt.url {
+				t.Errorf("url = %q, want %q", cfg.OpenF1.URL, tt.url)
+			}
+		})
+	}
+}
+
+func TestLoadPathOverConfigFile(t *testing.T) {
+	inDir(t, "", "openf1:\n  timeout: 3s\n")
+	t.Setenv("CONFIG_FILE", "missing.yaml")
+	cfg, err := config.Load("config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OpenF1.Timeout != 3*time.Second {
+		t.Errorf("timeout = %s, want 3s from the path given", cfg.OpenF1.Timeout)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	inDir(t, "", "")
+	toml := `
+[openf1]
+url = "http://toml"
+timeout = "3s"
+
+[openf1.weights]
+car_data = 4.0
+
+[websocket]
+allowed_origins = ["https://example.com"]
+`
+	if err := os.WriteFile("config.toml", []byte(toml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load("config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OpenF1.URL != "http://toml" || cfg.OpenF1.Timeout != 3*time.Second || cfg.OpenF1.Weights["car_data"] != 4 {
+		t.Errorf("openf1 = %+v, want the url, timeout and weight from config.toml", cfg.OpenF1)
+	}
+	if len(cfg.WebSocket.AllowedOrigins) != 1 || cfg.WebSocket.AllowedOrigins[0] != "https://example.com" {
+		t.Errorf("allowed origins = %v, want https://example.com", cfg.WebSocket.AllowedOrigins)
+	}
+	// what the file leaves out keeps its default
+	if cfg.OpenF1.MaxAttempts != config.Default().OpenF1.MaxAttempts {
+		t.Errorf("max attempts = %d, want the default", cfg.OpenF1.MaxAttempts)
+	}
+
+	if err := os.WriteFile("config.toml", []byte("[openf1]\nuri = \"http://toml\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load("config.toml"); err == nil || !strings.Contains(err.Error(), "openf1.uri") {
+		t.Errorf("err = %v, want one mentioning openf1.uri", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		dotenv string
+		yaml   string
+		env    map[string]string
+		err    string
+	}{
+		{name: "bad .env value", dotenv: "API_PORT=eighty\n", err: "API_PORT"},
+		{name: "bad environment value", env: map[string]string{"OPENF1_TIMEOUT": "soon"}, err: "OPENF1_TIMEOUT"},
+		{name: "unknown setting in the config file", yaml: "openf1:\n  uri: http://yaml\n", env: map[string]string{"CONFIG_FILE": "config.yaml"}, err: "uri"},
+		{name: "config file that isn't YAML or TOML", env: map[string]string{"CONFIG_FILE": "config.json"}, err: "not YAML or TOML"},
+		{name: "invalid once loaded", env: map[string]string{"LOG_FORMAT": "xml"}, err: "log format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inDir(t, tt.dotenv, tt.yaml)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := config.Load("")
+			if err == nil {
+				t.Fatalf("err = nil, want one mentioning %s", tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want one mentioning %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *config.Config)
+		errs   []string
+	}{
+		{"defaults", func(c *config.Config) {}, nil},
+		{"relative url", func(c *config.Config) { c.OpenF1.URL = "api.openf1.org" }, []string{"openf1 url"}},
+		{"no attempts", func(c *config.Config) { c.OpenF1.MaxAttempts = 0 }, []string{"max_attempts"}},
+		{"negative rate", func(c *config.Config) { c.OpenF1.RateLimit = -1 }, []string{"rate_limit"}},
+		{"zero weight", func(c *config.Config) { c.OpenF1.Weights = map[string]float64{"laps": 0} }, []string{"weight for laps"}},
+		{"addr without a port", func(c *config.Config) { c.Server.Addr = "localhost" }, []string{"server addr"}},
+		{"limiter addr without a port", func(c *config.Config) { c.OpenF1.LimiterAddr = "localhost" }, []string{"limiter_addr"}},
+		{"no limiter addr", func(c *config.Config) { c.OpenF1.LimiterAddr = "" }, nil},
+		{"port out of range", func(c *config.Config) { c.Server.Port = 70000 }, []string{"server port"}},
+		{"cert without a key", func(c *config.Config) { c.Server.TLS.CertFile = "cert.pem" }, []string{"cert_file and key_file"}},
+		{"unknown exporter", func(c *config.Config) { c.Tracing.Exporter = "jaeger" }, []string{"tracing exporter"}},
+		{
+			name: "every problem at once",
+			change: func(c *config.Config) {
+				c.OpenF1.Timeout = 0
+				c.Store.SessionsTTL = 0
+				c.Log.Format = "xml"
+			},
+			errs: []string{"openf1 timeout", "sessions_ttl", "log format"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.change(cfg)
+			err := cfg.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("err = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("err = nil, want %v", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %v, want one mentioning %s", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+// package for ingesting live session data from openf1 and fanning it out to subscribers
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"telem-api-server/openf1"
+	"telem-api-server/pubsub"
+)
+
+// Resources are the openf1 resources polled for a live session, in the order they are fetched each tick
+var Resources = []string{"car_data", "position", "intervals", "race_control"}
+
+// EventEnd is sent once the session is over, the feed stops after sending it
+const EventEnd = "end"
+
+// EventReset is sent first to a client resuming from an id that is no longer in the history, or that this server never sent
+// the events in between are gone, the client should fetch what it needs again and carry on from the reset's id
+const EventReset = "reset"
+
+// Event is a batch of new rows for a single resource
+// IDs increase by one for every event of a session, across feeds for it starting and stopping, so clients can resume from the last one they saw
+type Event struct {
+	ID         int64           `json:"id"`
+	SessionKey int             `json:"session_key"`
+	Type       string          `json:"type"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Feed polls openf1 for a single live session and keeps a short history of the events it has sent
+type Feed struct {
+	Client     *openf1.Client
+	SessionKey int
+	// polling stops once the session has ended
+	End time.Time
+	// new rows are also published here for in-process and local subscribers, can be nil
+	Hub *pubsub.Hub
+
+	mu          sync.Mutex
+	nextID      int64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]struct{}
+	// how far each resource has been read, per driver
+	cursors   map[string]*cursor
+	idleSince time.Time
+	cancel    context.CancelFunc
+}
+
+// Subscription receives the events of a feed until it is closed
+// Events is closed when the feed ends or the subscriber falls too far behind
+type Subscription struct {
+	Events <-chan Event
+	events chan Event
+	feed   *Feed
+}
+
+// newFeed starts numbering events at nextID, so a feed for a session that was polled before carries on from its ids
+func newFeed(client *openf1.Client, sessionKey int, end time.Time, hub *pubsub.Hub, historySize int, nextID int64, now time.Time) *Feed {
+	f := &Feed{
+		Client:      client,
+		SessionKey:  sessionKey,
+		End:         end,
+		Hub:         hub,
+		nextID:      nextID,
+		historySize: historySize,
+		subscribers: map[*Subscription]struct{}{},
+		cursors:     map[string]*cursor{},
+		idleSince:   now,
+	}
+	// start from a few seconds ago rather than the start of the session, car data alone is huge
+	for _, resource := range Resources {
+		f.cursors[resource] = &cursor{floor: now.Add(-backfillWindow), drivers: map[int]time.Time{}}
+	}
+	return f
+}
+
+// how far back a new feed starts from
+const backfillWindow = 10 * time.Second
+
+// overlap is how far back from the newest row every poll goes, so drivers that haven't been seen yet and whose
+// rows come in behind the others' are still picked up, rows already sent are left out by their driver's cursor
+const overlap = 5 * time.Second
+
+// maxLag is how far a driver's rows can arrive behind the newest of the others and still be picked up
+// a driver further behind than this, e.g. one that has retired, stops holding the others' requests back
+const maxLag = 30 * time.Second
+
+// cursor is how far a resource has been read for each driver, openf1 doesn't send every driver's rows at the same pace
+// rows that aren't about a driver, such as most race control messages, are kept under driver 0
+type cursor struct {
+	// nothing from before floor is requested
+	floor   time.Time
+	drivers map[int]time.Time
+}
+
+// since is the date to request rows after, overlap before the newest row or the oldest driver not more than maxLag behind it
+func (c *cursor) since() time.Time {
+	newest := c.floor
+	for _, date := range c.drivers {
+		if date.After(newest) {
+			newest = date
+		}
+	}
+	since := newest.Add(-overlap)
+	for _, date := range c.drivers {
+		if date.Before(since) && !date.Before(newest.Add(-maxLag)) {
+			since = date
+		}
+	}
+	if since.Before(c.floor) {
+		return c.floor
+	}
+	return since
+}
+
+// read reports whether a driver's row has been read already, rows arrive again until every driver has caught up
+func (c *cursor) read(driver int, date time.Time) bool {
+	last, ok := c.drivers[driver]
+	if !ok {
+		return !date.After(c.floor)
+	}
+	return !date.After(last)
+}
+
+func (c *cursor) advance(driver int, date time.Time) {
+	if date.After(c.drivers[driver]) {
+		c.drivers[driver] = date
+	}
+}
+
+// subscriber buffer size, a client this far behind is dropped and can resume with Last-Event-ID
+const subscriberBuffer = 256
+
+// subscribe registers a new subscriber and returns the events after lastEventID still in the history
+// the backlog starts with an EventReset when some of those events are gone, or lastEventID is one this feed never sent
+func (f *Feed) subscribe(lastEventID int64) (*Subscription, []Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{Events: events, events: events, feed: f}
+	f.subscribers[sub] = struct{}{}
+
+	if lastEventID <= 0 {
+		return sub, nil
+	}
+	// the oldest event that can still be sent again
+	oldest := f.nextID
+	if len(f.history) > 0 {
+		oldest = f.history[0].ID
+	}
+	var backlog []Event
+	if lastEventID+1 < oldest || lastEventID >= f.nextID {
+		reset, _ := json.Marshal(map[string]int64{"last_event_id": lastEventID})
+		backlog = append(backlog, Event{ID: f.nextID - 1, SessionKey: f.SessionKey, Type: EventReset, Data: reset})
+	}
+	for _, e := range f.history {
+		if e.ID > lastEventID {
+			backlog = append(backlog, e)
+		}
+	}
+	return sub, backlog
+}
+
+// lastID is the id of the last event the feed sent, 0 before the first
+func (f *Feed) lastID() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nextID - 1
+}
+
+// Close stops the subscription, it is safe to call more than once
+func (s *Subscription) Close() {
+	f := s.feed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removeLocked(s)
+}
+
+func (f *Feed) removeLocked(s *Subscription) {
+	if _, ok := f.subscribers[s]; !ok {
+		return
+	}
+	delete(f.subscribers, s)
+	close(s.events)
+	if len(f.subscribers) == 0 {
+		f.idleSince = time.Now()
+	}
+}
+
+// idle reports whether the feed has had no subscribers for longer than timeout
+func (f *Feed) idle(now time.Time, timeout time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers) == 0 && now.Sub(f.idleSince) > timeout
+}
+
+// publish adds an event to the history and sends it to every subscriber
+func (f *Feed) publish(eventType string, data json.RawMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	event := Event{ID: f.nextID, SessionKey: f.SessionKey, Type: eventType, Data: data}
+	f.nextID++
+	f.history = append(f.history, event)
+	if len(f.history) > f.historySize {
+		f.history = f.history[len(f.history)-f.historySize:]
+	}
+
+	for sub := range f.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			// the subscriber isn't keeping up, drop it rather than block everyone else
+			log.Printf("dropping slow subscriber of session %d live feed", f.SessionKey)
+			f.removeLocked(sub)
+		}
+	}
+}
+
+// closeAll ends every subscription, used once the session is over
+func (f *Feed) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subscribers {
+		f.removeLocked(sub)
+	}
+}
+
+// poll fetches the rows added to each resource since the last poll
+func (f *Feed) poll(ctx context.Context) {
+	for _, resource := range Resources {
+		if ctx.Err() != nil {
+			return
+		}
+		rows, err := f.fetch(ctx, resource)
+		if err != nil {
+			log.Printf("Error polling %s for session %d: %v", resource, f.SessionKey, err)
+			continue
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		data, err := json.Marshal(rows)
+		if err != nil {
+			log.Printf("Error encoding %s for session %d: %v", resource, f.SessionKey, err)
+			continue
+		}
+		f.publish(resource, data)
+		f.publishToHub(resource, rows)
+	}
+}
+
+// publishToHub sends each row to sessions/{key}/{resource}/{driver}, or sessions/{key}/{resource}
+// for rows that aren't about a single driver such as most race control messages
+func (f *Feed) publishToHub(resource string, rows []json.RawMessage) {
+	if f.Hub == nil {
+		return
+	}
+	base := fmt.Sprintf("sessions/%d/%s", f.SessionKey, resource)
+	for _, row := range rows {
+		var driver struct {
+			DriverNumber *int `json:"driver_number"`
+		}
+		json.Unmarshal(row, &driver)
+
+		// hub payloads are sent one per line so they have to be compact
+		var payload bytes.Buffer
+		if err := json.Compact(&payload, row); err != nil {
+			continue
+		}
+		topic := base
+		if driver.DriverNumber != nil {
+			topic = fmt.Sprintf("%s/%d", base, *driver.DriverNumber)
+		}
+		f.Hub.Publish(topic, payload.Bytes())
+	}
+}
+
+// fetch requests the rows for a resource after its cursor and moves the cursor past them
+// rows of drivers that had already been read up to their date are left out
+func (f *Feed) fetch(ctx context.Context, resource string) ([]json.RawMessage, error) {
+	f.mu.Lock()
+	since := f.cursors[resource].since()
+	f.mu.Unlock()
+
+	params := url.Values{}
+	params.Set("session_key", strconv.Itoa(f.SessionKey))
+	params.Set("date>", since.UTC().Format(time.RFC3339Nano))
+
+	var rows []json.RawMessage
+	// a live feed that waits behind other calls falls behind the session
+	ctx = openf1.WithPriority(ctx, openf1.PriorityLive)
+	if err := f.Client.Get(ctx, resource, params, &rows); err != nil {
+		if errors.Is(err, openf1.ErrNoResults) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.cursors[resource]
+	var fresh []json.RawMessage
+	var keys []rowKey
+	for _, row := range rows {
+		key, ok := parseRowKey(row)
+		if !ok || c.read(key.driver, key.date) {
+			continue
+		}
+		fresh = append(fresh, row)
+		keys = append(keys, key)
+	}
+	// the cursor only moves once every row has been looked at, rows needn't come sorted by date
+	for _, key := range keys {
+		c.advance(key.driver, key.date)
+	}
+	return fresh, nil
+}
+
+// rowKey is the driver and date of a row, which is what tells rows apart between polls
+type rowKey struct {
+	driver int
+	date   time.Time
+}
+
+func parseRowKey(row json.RawMessage) (rowKey, bool) {
+	var dated struct {
+		Date         time.Time `json:"date"`
+		DriverNumber *int      `json:"driver_number"`
+	}
+	if err := json.Unmarshal(row, &dated); err != nil {
+		return rowKey{}, false
+	}
+	key := rowKey{date: dated.Date}
+	if dated.DriverNumber != nil {
+		key.driver = *dated.DriverNumber
+	}
+	return key, true
+}
@@ -0,0 +1,247 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"telem-api-server/openf1"
+)
+
+// fakeOpenF1 serves rows per resource the way openf1 does for a live session, only those after the date> filter
+// and 404 when there are none
+type fakeOpenF1 struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	rows map[string][]openf1.CarData
+	// the date> of every request, per resource
+	since map[string][]time.Time
+}
+
+func newFakeOpenF1(t *testing.T) *fakeOpenF1 {
+	t.Helper()
+	f := &fakeOpenF1{rows: map[string][]openf1.CarData{}, since: map[string][]time.Time{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resource := strings.TrimPrefix(r.URL.Path, "/")
+		var since time.Time
+		for _, part := range strings.Split(r.URL.RawQuery, "&") {
+			if value, ok := strings.CutPrefix(part, "date>"); ok {
+				value, _ = url.QueryUnescape(value)
+				since, _ = time.Parse(time.RFC3339Nano, value)
+			}
+		}
+		f.mu.Lock()
+		f.since[resource] = append(f.since[resource], since)
+		var rows []openf1.CarData
+		for _, row := range f.rows[resource] {
+			if row.Date.After(since) {
+				rows = append(rows, row)
+			}
+		}
+		f.mu.Unlock()
+		if len(rows) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(rows)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// add makes rows available to later requests, as openf1 does when a driver's data comes in
+func (f *fakeOpenF1) add(resource string, rows ...openf1.CarData) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rows[resource] = append(f.rows[resource], rows...)
+}
+
+func (f *fakeOpenF1) requests(resource string) []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time{}, f.since[resource]...)
+}
+
+func (f *fakeOpenF1) client() *openf1.Client {
+	client := openf1.NewClient(f.URL)
+	client.Retry.MaxAttempts = 1
+	return client
+}
+
+func sample(driver int, date time.Time) openf1.CarData {
+	return openf1.CarData{SessionKey: 9158, DriverNumber: driver, Date: date, Speed: 300}
+}
+
+// decodeRows reads back the rows of an event
+func decodeRows(t *testing.T, event Event) []openf1.CarData {
+	t.Helper()
+	var rows []openf1.CarData
+	if err := json.Unmarshal(event.Data, &rows); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func next(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	return Event{}
+}
+
+func TestFeedCursorPerDriver(t *testing.T) {
+	upstream := newFakeOpenF1(t)
+	now := time.Now().UTC()
+	f := newFeed(upstream.client(), 9158, now.Add(time.Hour), nil, 100, 1, now)
+	sub, _ := f.subscribe(0)
+	defer sub.Close()
+
+	upstream.add("car_data", sample(1, now.Add(time.Second)), sample(1, now.Add(3*time.Second)))
+	f.poll(context.Background())
+	if rows := decodeRows(t, next(t, sub)); len(rows) != 2 {
+		t.Fatalf("first poll sent %d rows, want 2", len(rows))
+	}
+
+	// car 44's rows come in late, dated before car 1's newest
+	upstream.add("car_data", sample(44, now.Add(2*time.Second)), sample(1, now.Add(4*time.Second)))
+	f.poll(context.Background())
+	rows := decodeRows(t, next(t, sub))
+	if len(rows) != 2 {
+		t.Fatalf("second poll sent %d rows, want car 44's late row and car 1's new one", len(rows))
+	}
+	for _, row := range rows {
+		// car 1's rows from the first poll come back from openf1 again but aren't sent twice
+		if row.DriverNumber == 1 && !row.Date.Equal(now.Add(4*time.Second)) {
+			t.Errorf("car 1's row at %s was sent again", row.Date)
+		}
+	}
+
+	// nothing new, nothing sent
+	f.poll(context.Background())
+	select {
+	case event := <-sub.Events:
+		t.Fatalf("got %s event with nothing new", event.Type)
+	default:
+	}
+
+	// polls overlap, so car 44's late row was asked for without car 44 having been seen before
+	requests := upstream.requests("car_data")
+	if got, want := requests[1], now.Add(3*time.Second-overlap); !got.Equal(want) {
+		t.Errorf("second request was for rows after %s, want %s", got, want)
+	}
+}
+
+func TestFeedCursorLeavesLaggardsBehind(t *testing.T) {
+	upstream := newFakeOpenF1(t)
+	now := time.Now().UTC()
+	f := newFeed(upstream.client(), 9158, now.Add(time.Hour), nil, 100, 1, now)
+
+	// car 44 stops sending, e.g. it retired, and car 1 carries on
+	upstream.add("car_data", sample(44, now.Add(time.Second)), sample(1, now.Add(time.Second)))
+	f.poll(context.Background())
+	upstream.add("car_data", sample(1, now.Add(time.Second+2*maxLag)))
+	f.poll(context.Background())
+	f.poll(context.Background())
+
+	requests := upstream.requests("car_data")
+	if got, want := requests[len(requests)-1], now.Add(time.Second+2*maxLag-overlap); !got.Equal(want) {
+		t.Errorf("last request was for rows after %s, want %s as car 44 is more than maxLag behind", got, want)
+	}
+}
+
+func TestFeedResume(t *testing.T) {
+	f := newFeed(nil, 9158, time.Now().Add(time.Hour), nil, 3, 1, time.Now())
+	for i := 0; i < 5; i++ {
+		f.publish("car_data", json.RawMessage(`[]`))
+	}
+	// ids 1 to 5 were sent, 3 to 5 are still in the history
+
+	tests := []struct {
+		name        string
+		lastEventID int64
+		want        []int64
+		reset       bool
+	}{
+		{"new client", 0, nil, false},
+		{"in the history", 3, []int64{4, 5}, false},
+		{"just before the history", 2, []int64{3, 4, 5}, false},
+		{"up to date", 5, nil, false},
+		{"history gone", 1, []int64{5, 3, 4, 5}, true},
+		{"never sent", 9, []int64{5}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sub, backlog := f.subscribe(test.lastEventID)
+			defer sub.Close()
+			var ids []int64
+			for _, e := range backlog {
+				ids = append(ids, e.ID)
+			}
+			if len(ids) != len(test.want) {
+				t.Fatalf("backlog ids = %v, want %v", ids, test.want)
+			}
+			for i := range ids {
+				if ids[i] != test.want[i] {
+					t.Fatalf("backlog ids = %v, want %v", ids, test.want)
+				}
+			}
+			if reset := len(backlog) > 0 && backlog[0].Type == EventReset; reset != test.reset {
+				t.Errorf("reset = %v, want %v", reset, test.reset)
+			}
+		})
+	}
+}
+
+func TestManagerIdleTeardownKeepsIDs(t *testing.T) {
+	upstream := newFakeOpenF1(t)
+	m := NewManager(upstream.client(), nil)
+	m.PollInterval = 10 * time.Millisecond
+	m.IdleTimeout = 20 * time.Millisecond
+	defer m.Stop()
+
+	now := time.Now().UTC()
+	upstream.add("car_data", sample(1, now.Add(-time.Second)))
+	sub, _ := m.Subscribe(9158, now.Add(time.Hour), 0)
+	first := next(t, sub)
+	sub.Close()
+
+	// with nobody subscribed the feed stops after IdleTimeout
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m.mu.Lock()
+		_, running := m.feeds[9158]
+		m.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle feed wasn't stopped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// a client coming back to a new feed resumes without a reset, and ids carry on from the old feed's
+	upstream.add("car_data", sample(1, time.Now().UTC()))
+	sub, backlog := m.Subscribe(9158, now.Add(time.Hour), first.ID)
+	defer sub.Close()
+	if len(backlog) != 0 {
+		t.Errorf("backlog = %v, want nothing", backlog)
+	}
+	if event := next(t, sub); event.ID <= first.ID {
+		t.Errorf("new feed sent id %d, want more than %d", event.ID, first.ID)
+	}
+}
@@ -0,0 +1,129 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"telem-api-server/openf1"
+	"telem-api-server/pubsub"
+)
+
+// Manager runs a single Feed per live session no matter how many clients are subscribed to it
+type Manager struct {
+	// every feed polls through Client, live polling goes ahead of everything else queued on its limiter
+	Client       *openf1.Client
+	PollInterval time.Duration
+	// how long a feed keeps polling with no subscribers, so reconnecting clients can resume
+	IdleTimeout time.Duration
+	// number of events kept per feed for Last-Event-ID resume
+	HistorySize int
+	// every feed publishes its rows to the hub as well as to its own subscribers
+	Hub *pubsub.Hub
+
+	mu    sync.Mutex
+	feeds map[int]*Feed
+	// the last event id of each session whose feed has stopped, a new feed for it carries on from there
+	lastIDs map[int]int64
+}
+
+// NewManager polls through client and publishes to hub, the server shares one between the SSE and websocket handlers
+// so each live session is only polled once
+func NewManager(client *openf1.Client, hub *pubsub.Hub) *Manager {
+	return &Manager{
+		Client:       client,
+		PollInterval: 2 * time.Second,
+		IdleTimeout:  time.Minute,
+		HistorySize:  1000,
+		Hub:          hub,
+		feeds:        map[int]*Feed{},
+		lastIDs:      map[int]int64{},
+	}
+}
+
+// Subscribe joins the feed for a session, starting it if nobody else is subscribed
+// any events after lastEventID that are still in the feed's history are returned to be sent first
+// led by an EventReset when some of them are gone
+func (m *Manager) Subscribe(sessionKey int, end time.Time, lastEventID int64) (*Subscription, []Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feed, ok := m.feeds[sessionKey]
+	if !ok {
+		feed = newFeed(m.Client, sessionKey, end, m.Hub, m.HistorySize, m.lastIDs[sessionKey]+1, time.Now())
+		ctx, cancel := context.WithCancel(context.Background())
+		feed.cancel = cancel
+		m.feeds[sessionKey] = feed
+		log.Printf("starting live feed for session %d", sessionKey)
+		go m.run(ctx, feed)
+	}
+	return feed.subscribe(lastEventID)
+}
+
+// Stop ends every running feed
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, feed := range m.feeds {
+		feed.cancel()
+		feed.closeAll()
+		m.lastIDs[key] = feed.lastID()
+		delete(m.feeds, key)
+	}
+}
+
+func (m *Manager) run(ctx context.Context, feed *Feed) {
+	ticker := time.NewTicker(m.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		feed.poll(ctx)
+
+		now := time.Now()
+		if now.After(feed.End) {
+			log.Printf("session %d has ended, stopping live feed", feed.SessionKey)
+			feed.publish(EventEnd, json.RawMessage(`{}`))
+			m.remove(feed)
+			return
+		}
+		if m.removeIfIdle(feed, now) {
+			log.Printf("no subscribers left for session %d, stopping live feed", feed.SessionKey)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeIfIdle drops an idle feed, this happens under the manager lock so a new subscriber can't join it meanwhile
+func (m *Manager) removeIfIdle(feed *Feed, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !feed.idle(now, m.IdleTimeout) {
+		return false
+	}
+	m.forgetLocked(feed)
+	return true
+}
+
+func (m *Manager) remove(feed *Feed) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forgetLocked(feed)
+	feed.closeAll()
+}
+
+// forgetLocked stops feed and drops it, keeping its last event id for the next feed of the session
+func (m *Manager) forgetLocked(feed *Feed) {
+	if m.feeds[feed.SessionKey] == feed {
+		delete(m.feeds, feed.SessionKey)
+		m.lastIDs[feed.SessionKey] = feed.lastID()
+	}
+	feed.cancel()
+}
@@ -0,0 +1,121 @@
+// package for the Prometheus metrics the server exposes at /metrics
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"telem-api-server/pubsub"
+)
+
+// Registry holds every metric below along with the Go runtime and process metrics
+// it is our own rather than the global default so nothing a dependency registers ends up in /metrics
+var Registry = prometheus.NewRegistry()
+
+// request durations run from a store hit to a full export, upstream durations from a small resource to a session of car data
+var (
+	requestBuckets  = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	upstreamBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+)
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Requests served, by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time to serve a request, by route pattern, method and status.",
+		Buckets: requestBuckets,
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Requests being served, including open event streams and websockets.",
+	})
+
+	UpstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "openf1_request_duration_seconds",
+		Help:    "Time taken by openf1 requests, by resource.",
+		Buckets: upstreamBuckets,
+	}, []string{"resource"})
+
+	UpstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "openf1_request_errors_total",
+		Help: "Failed openf1 requests, by resource and reason: the status code, network or decode.",
+	}, []string{"resource", "reason"})
+
+	UpstreamRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "openf1_requests_in_flight",
+		Help: "openf1 requests waiting on a response.",
+	})
+
+	UpstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "openf1_retries_total",
+		Help: "openf1 requests tried again after a transient failure, by resource.",
+	}, []string{"resource"})
+
+	UpstreamBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "openf1_circuit_breaker_state",
+		Help: "State of the circuit breaker in front of openf1: 0 closed, 1 half-open, 2 open.",
+	})
+
+	UpstreamQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "openf1_limiter_queue_depth",
+		Help: "Calls to openf1 waiting on the rate limiter, by priority: live, normal or background.",
+	}, []string{"priority"})
+
+	// the hit ratio is rate(store_cache_lookups_total{result="hit"}) over rate(store_cache_lookups_total)
+	CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "store_cache_lookups_total",
+		Help: "Store lookups by resource and result: hit, miss, or stale when openf1 failed and stored rows were served.",
+	}, []string{"resource", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		HTTPRequestsInFlight,
+		UpstreamRequestDuration,
+		UpstreamErrors,
+		UpstreamRequestsInFlight,
+		UpstreamRetries,
+		UpstreamBreakerState,
+		UpstreamQueueDepth,
+		CacheLookups,
+	)
+}
+
+// RegisterHub exposes the hub's counters, they're read from the hub when /metrics is scraped
+func RegisterHub(hub *pubsub.Hub) {
+	Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "pubsub_subscribers",
+			Help: "Subscribers connected to the pub/sub hub.",
+		}, func() float64 { return float64(hub.Stats().Subscribers) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "pubsub_published_total",
+			Help: "Messages published to the pub/sub hub.",
+		}, func() float64 { return float64(hub.Stats().Published) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "pubsub_delivered_total",
+			Help: "Messages delivered to pub/sub subscribers.",
+		}, func() float64 { return float64(hub.Stats().Delivered) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "pubsub_dropped_total",
+			Help: "Messages dropped because a pub/sub subscriber fell behind.",
+		}, func() float64 { return float64(hub.Stats().Dropped) }),
+	)
+}
+
+// Handler serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
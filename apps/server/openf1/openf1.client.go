@@ -0,0 +1,283 @@
+// package for talking to the openf1 API
+package openf1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"telem-api-server/metrics"
+)
+
+var tracer = otel.Tracer("telem-api-server/openf1")
+
+// ErrNoResults is returned when openf1 has no data for the query, it responds with a 404 rather than an empty list
+var ErrNoResults = errors.New("no results found")
+
+// errDecode wraps responses that came back but couldn't be decoded, trying again won't help
+var errDecode = errors.New("error unmarshalling")
+
+// StatusError is returned when openf1 responds with a status other than 200 or 404
+// RetryAfter is how long openf1 asked us to wait, from its Retry-After header
+type StatusError struct {
+	Resource   string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response status code for %s: %d", e.Resource, e.StatusCode)
+}
+
+// Temporary reports whether the request may succeed if it's tried again, i.e. rate limiting or a server error
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// Call is a finished request to openf1, StatusCode is 0 when no response came back
+type Call struct {
+	Resource   string
+	StatusCode int
+	Bytes      int64
+	Duration   time.Duration
+	Err        error
+}
+
+type observersKey struct{}
+
+// WithObserver returns a context whose openf1 calls are passed to observe once they finish
+// observers added further up the context are still called
+func WithObserver(ctx context.Context, observe func(Call)) context.Context {
+	observers, _ := ctx.Value(observersKey{}).([]func(Call))
+	return context.WithValue(ctx, observersKey{}, append(observers[:len(observers):len(observers)], observe))
+}
+
+func notify(ctx context.Context, call Call) {
+	observers, _ := ctx.Value(observersKey{}).([]func(Call))
+	for _, observe := range observers {
+		observe(call)
+	}
+}
+
+// recordCall adds a finished call to the openf1 metrics, no results is an answer rather than an error
+func recordCall(call Call) {
+	metrics.UpstreamRequestDuration.WithLabelValues(call.Resource).Observe(call.Duration.Seconds())
+	if call.Err == nil || errors.Is(call.Err, ErrNoResults) {
+		return
+	}
+	reason := "network"
+	switch {
+	case call.StatusCode == http.StatusOK:
+		reason = "decode"
+	case call.StatusCode != 0:
+		reason = strconv.Itoa(call.StatusCode)
+	}
+	metrics.UpstreamErrors.WithLabelValues(call.Resource, reason).Inc()
+}
+
+// Client is the openf1 API at BaseUrl, requests are made with HTTPClient
+// transient failures are retried as Retry says, and Breaker stops calls while openf1 keeps failing
+type Client struct {
+	BaseUrl    string
+	HTTPClient *http.Client
+	Retry      RetryPolicy
+	Breaker    *Breaker
+	// Limiter is waited on before every attempt, nil doesn't limit
+	Limiter RateLimiter
+	// status is what Status reports
+	status tracker
+}
+
+// NewClient returns a Client for BaseUrl using http.DefaultClient and DefaultRetry, with a breaker of its own and no limiter
+// the server and the backfill build theirs from the configuration instead
+func NewClient(BaseUrl string) *Client {
+	return &Client{BaseUrl: BaseUrl, HTTPClient: http.DefaultClient, Retry: DefaultRetry, Breaker: NewBreaker(5, 30*time.Second)}
+}
+
+// Get fetches a resource from the openf1 API and decodes the JSON response into out
+// params are passed through as query parameters, e.g. session_key=9158
+// keys can carry a comparison operator for filtering, e.g. "date>" or "speed>="
+// ErrCircuitOpen is returned straight away while the breaker is open
+func (c *Client) Get(ctx context.Context, resource string, params url.Values, out any) error {
+	if c.BaseUrl == "" {
+		return fmt.Errorf("baseUrl is empty, unable to make request")
+	}
+	requestUrl := fmt.Sprintf("%s/%s", c.BaseUrl, resource)
+	if len(params) > 0 {
+		requestUrl += "?" + encodeParams(params)
+	}
+
+	for attempt := 1; ; attempt++ {
+		// the breaker comes first, so calls that would only get ErrCircuitOpen don't queue or spend tokens
+		if err := c.Breaker.Allow(); err != nil {
+			return fmt.Errorf("error fetching %s: %w", resource, err)
+		}
+		if err := c.wait(ctx, resource); err != nil {
+			c.Breaker.Cancel()
+			return fmt.Errorf("error fetching %s: %w", resource, err)
+		}
+		err := c.attempt(ctx, resource, requestUrl, out)
+		if err != nil && ctx.Err() != nil {
+			// a client that has gone away says nothing about openf1 either way, and isn't a reason to try again
+			c.Breaker.Cancel()
+			return err
+		}
+		c.Breaker.Record(transient(err))
+		// nor is a breaker this attempt has just opened
+		if !transient(err) || attempt >= c.Retry.MaxAttempts || c.Breaker.State() == BreakerOpen {
+			return err
+		}
+		delay, ok := c.Retry.delay(attempt, err)
+		if !ok {
+			log.Printf("not retrying %s, openf1 asked to wait longer than %s: %v", resource, c.Retry.MaxDelay, err)
+			return err
+		}
+		log.Printf("retrying %s in %s after attempt %d failed: %v", resource, delay.Round(time.Millisecond), attempt, err)
+		metrics.UpstreamRetries.WithLabelValues(resource).Inc()
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) wait(ctx context.Context, resource string) error {
+	if c.Limiter == nil {
+		return nil
+	}
+	return c.Limiter.Wait(ctx, resource)
+}
+
+// attempt is a single request to openf1, traced and counted on its own so retries show up as separate calls
+func (c *Client) attempt(ctx context.Context, resource string, requestUrl string, out any) (err error) {
+	// the per-attempt timeout below is openf1 being slow, the caller's own context ending isn't
+	caller := ctx
+	if timeout := c.Retry.timeout(resource); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracer.Start(ctx, "openf1 "+resource, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(http.MethodGet),
+			semconv.URLFull(requestUrl),
+			attribute.String("openf1.resource", resource),
+		),
+	)
+	call := Call{Resource: resource}
+	start := time.Now()
+	metrics.UpstreamRequestsInFlight.Inc()
+	defer func() {
+		metrics.UpstreamRequestsInFlight.Dec()
+		call.Duration = time.Since(start)
+		call.Err = err
+		recordCall(call)
+		// like the breaker the status leaves out calls our own callers gave up on
+		if caller.Err() == nil {
+			c.status.track(call, time.Now())
+		}
+		notify(ctx, call)
+		endSpan(span, call)
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return fmt.Errorf("error creating %s request: %w", resource, err)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	response, urlErr := c.HTTPClient.Do(request)
+	if urlErr != nil {
+		log.Printf("Error fetching %s: %v", resource, urlErr)
+		return fmt.Errorf("error fetching %s: %w", resource, urlErr)
+	}
+	// always make sure to close the response body
+	defer response.Body.Close()
+	call.StatusCode = response.StatusCode
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNoResults
+	}
+	if response.StatusCode != http.StatusOK {
+		log.Printf("API Response Status Code for %s: %d", resource, response.StatusCode)
+		return &StatusError{
+			Resource:   resource,
+			StatusCode: response.StatusCode,
+			RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	responseData, responseErr := io.ReadAll(response.Body)
+	call.Bytes = int64(len(responseData))
+	if responseErr != nil {
+		return fmt.Errorf("error reading response body: %w", responseErr)
+	}
+
+	return decode(ctx, resource, responseData, out)
+}
+
+// decode is its own span so slow decoding of a big response can be told apart from a slow response
+func decode(ctx context.Context, resource string, data []byte, out any) error {
+	_, span := tracer.Start(ctx, "decode "+resource, trace.WithAttributes(attribute.Int("openf1.response.bytes", len(data))))
+	defer span.End()
+	if err := json.Unmarshal(data, out); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "decode failed")
+		return fmt.Errorf("%w %s response data: %w", errDecode, resource, err)
+	}
+	return nil
+}
+
+// endSpan records how a call went on its span, no results is a 404 but not a failure
+func endSpan(span trace.Span, call Call) {
+	if call.StatusCode != 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(call.StatusCode))
+	}
+	span.SetAttributes(attribute.Int64("http.response.body.size", call.Bytes))
+	if call.Err != nil && !errors.Is(call.Err, ErrNoResults) {
+		span.RecordError(call.Err)
+		span.SetStatus(codes.Error, call.Err.Error())
+	}
+	span.End()
+}
+
+// encodeParams is url.Values.Encode but leaves the comparison operators on the end of keys as they are
+// openf1 expects filters like date>2023-09-16T13:03:35 rather than date%3E=2023-09-16T13:03:35
+func encodeParams(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		name := strings.TrimRight(key, "<>=")
+		operator := key[len(name):]
+		for _, value := range params[key] {
+			if operator == "" {
+				parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
+			} else {
+				// the operator replaces the = so "date>" becomes date>value and "date>=" stays date>=value
+				parts = append(parts, url.QueryEscape(name)+operator+url.QueryEscape(value))
+			}
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,222 @@
+package openf1
+
+import (
+	"container/heap"
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"telem-api-server/metrics"
+)
+
+// Priority orders calls waiting on the limiter, higher goes first
+type Priority int
+
+const (
+	// PriorityBackground is for work nobody is waiting on, such as warming the session list
+	PriorityBackground Priority = iota
+	// PriorityNormal is a client request, and what a call without a priority gets
+	PriorityNormal
+	// PriorityLive is polling a live session, which falls behind if it waits
+	PriorityLive
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBackground:
+		return "background"
+	case PriorityLive:
+		return "live"
+	}
+	return "normal"
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose openf1 calls queue at p when the limiter has run out of tokens
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// DefaultWeights are the tokens a call to each resource takes, anything else takes 1
+// whole sessions of samples are the heaviest thing openf1 serves
+var DefaultWeights = map[string]float64{
+	"car_data": 2,
+	"location": 2,
+}
+
+// Limiter is a token bucket shared by every call to openf1, so fanning out for one page can't get us rate limited
+// calls that can't go straight away queue by priority, then in the order they arrived
+type Limiter struct {
+	rate    float64
+	burst   float64
+	weights map[string]float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	queue  waiters
+	seq    uint64
+	// wake tells the dispatcher the head of the queue may have changed
+	wake       chan struct{}
+	dispatched bool
+	now        func() time.Time
+}
+
+// NewLimiter allows rate tokens a second with up to burst at once, weights override DefaultWeights
+// rate has to be positive, a client without a limiter is how calls go unlimited
+func NewLimiter(rate float64, burst float64, weights map[string]float64) *Limiter {
+	merged := maps.Clone(DefaultWeights)
+	maps.Copy(merged, weights)
+	return &Limiter{
+		rate:    rate,
+		burst:   max(burst, 1),
+		weights: merged,
+		tokens:  max(burst, 1),
+		last:    time.Now(),
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+	}
+}
+
+// cost is the weight of a call to resource, capped at the burst so a heavy call can always go eventually
+func (l *Limiter) cost(resource string) float64 {
+	cost, ok := l.weights[resource]
+	if !ok {
+		cost = 1
+	}
+	return min(cost, l.burst)
+}
+
+// Wait blocks until a call to resource can be made, or ctx is done
+// a nil limiter never waits
+func (l *Limiter) Wait(ctx context.Context, resource string) error {
+	if l == nil {
+		return nil
+	}
+	cost := l.cost(resource)
+
+	l.mu.Lock()
+	l.refill()
+	if len(l.queue) == 0 && l.tokens >= cost {
+		l.tokens -= cost
+		l.mu.Unlock()
+		return nil
+	}
+	w := &waiter{priority: priorityFrom(ctx), seq: l.seq, cost: cost, ready: make(chan struct{})}
+	l.seq++
+	heap.Push(&l.queue, w)
+	metrics.UpstreamQueueDepth.WithLabelValues(w.priority.String()).Inc()
+	if !l.dispatched {
+		l.dispatched = true
+		go l.dispatch()
+	}
+	l.mu.Unlock()
+	l.notify()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if w.index < 0 {
+			// the tokens were handed over as ctx finished, they're spent either way
+			return nil
+		}
+		heap.Remove(&l.queue, w.index)
+		metrics.UpstreamQueueDepth.WithLabelValues(w.priority.String()).Dec()
+		l.notify()
+		return ctx.Err()
+	}
+}
+
+// dispatch hands out tokens to the head of the queue as they come in, it runs until the queue is empty
+func (l *Limiter) dispatch() {
+	for {
+		l.mu.Lock()
+		l.refill()
+		for len(l.queue) > 0 && l.tokens >= l.queue[0].cost {
+			w := heap.Pop(&l.queue).(*waiter)
+			l.tokens -= w.cost
+			metrics.UpstreamQueueDepth.WithLabelValues(w.priority.String()).Dec()
+			close(w.ready)
+		}
+		if len(l.queue) == 0 {
+			l.dispatched = false
+			l.mu.Unlock()
+			return
+		}
+		wait := time.Duration((l.queue[0].cost - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-l.wake:
+		}
+	}
+}
+
+func (l *Limiter) notify() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// refill adds the tokens earned since the last refill, the caller holds the lock
+func (l *Limiter) refill() {
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+type waiter struct {
+	priority Priority
+	seq      uint64
+	cost     float64
+	ready    chan struct{}
+	// index is the waiter's place in the queue, -1 once it has been let through
+	index int
+}
+
+// waiters is a heap of the calls waiting for tokens
+type waiters []*waiter
+
+func (q waiters) Len() int { return len(q) }
+
+func (q waiters) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waiters) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waiters) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waiters) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,258 @@
+package openf1
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"telem-api-server/metrics"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// newTestLimiter returns a limiter on a clock that only moves when advance is called, starting with a full bucket
+func newTestLimiter(rate float64, burst float64, weights map[string]float64) (*Limiter, func(time.Duration)) {
+	clock := &testClock{now: time.Date(2024, 3, 2, 15, 0, 0, 0, time.UTC)}
+	l := NewLimiter(rate, burst, weights)
+	l.now = clock.Now
+	l.last = clock.Now()
+	advance := func(d time.Duration) {
+		clock.mu.Lock()
+		clock.now = clock.now.Add(d)
+		clock.mu.Unlock()
+		// the dispatcher sleeps in real time, it has to be told the clock moved
+		l.notify()
+	}
+	return l, advance
+}
+
+func (l *Limiter) queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queue)
+}
+
+// waitQueued waits for n calls to be queued, so calls started one after another queue in that order
+func waitQueued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for l.queued() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d calls queued, want %d", l.queued(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queue starts a call that sends name on done once the limiter lets it through
+func queue(t *testing.T, l *Limiter, ctx context.Context, p Priority, resource string, name string, done chan<- string) {
+	t.Helper()
+	n := l.queued()
+	go func() {
+		if err := l.Wait(WithPriority(ctx, p), resource); err != nil {
+			done <- name + ": " + err.Error()
+			return
+		}
+		done <- name
+	}()
+	waitQueued(t, l, n+1)
+}
+
+// next returns the name of the next call let through, failing if none is
+func next(t *testing.T, done <-chan string) string {
+	t.Helper()
+	select {
+	case name := <-done:
+		return name
+	case <-time.After(time.Second):
+		t.Fatal("no call let through")
+		return ""
+	}
+}
+
+// none fails if a call is let through
+func none(t *testing.T, done <-chan string) {
+	t.Helper()
+	select {
+	case name := <-done:
+		t.Fatalf("%s let through, want it still queued", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func queueDepth(t *testing.T, p Priority) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.UpstreamQueueDepth.WithLabelValues(p.String()).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestLimiterBurst(t *testing.T) {
+	l, advance := newTestLimiter(1, 2, nil)
+	ctx := context.Background()
+	for range 2 {
+		if err := l.Wait(ctx, "laps"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.queued() != 0 {
+		t.Fatal("calls within the burst were queued")
+	}
+
+	done := make(chan string, 1)
+	queue(t, l, ctx, PriorityNormal, "laps", "third", done)
+	advance(500 * time.Millisecond)
+	none(t, done)
+	advance(500 * time.Millisecond)
+	if name := next(t, done); name != "third" {
+		t.Errorf("got %s, want third", name)
+	}
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l, advance := newTestLimiter(1, 1, nil)
+	ctx := context.Background()
+	l.Wait(ctx, "laps")
+
+	done := make(chan string, 5)
+	queue(t, l, ctx, PriorityBackground, "laps", "background 1", done)
+	queue(t, l, ctx, PriorityNormal, "laps", "normal 1", done)
+	queue(t, l, ctx, PriorityBackground, "laps", "background 2", done)
+	queue(t, l, ctx, PriorityLive, "laps", "live", done)
+	queue(t, l, ctx, PriorityNormal, "laps", "normal 2", done)
+
+	// higher priorities first, then in the order they were queued
+	want := []string{"live", "normal 1", "normal 2", "background 1", "background 2"}
+	for _, name := range want {
+		advance(time.Second)
+		if got := next(t, done); got != name {
+			t.Fatalf("got %s, want %s", got, name)
+		}
+		none(t, done)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l, advance := newTestLimiter(1, 1, nil)
+	ctx := context.Background()
+	l.Wait(ctx, "laps")
+
+	done := make(chan string, 3)
+	cancelled, cancel := context.WithCancel(ctx)
+	queue(t, l, ctx, PriorityNormal, "laps", "first", done)
+	queue(t, l, cancelled, PriorityNormal, "laps", "cancelled", done)
+	queue(t, l, ctx, PriorityNormal, "laps", "last", done)
+
+	cancel()
+	if got := next(t, done); got != "cancelled: "+context.Canceled.Error() {
+		t.Fatalf("got %s, want the cancelled call to return its error", got)
+	}
+	waitQueued(t, l, 2)
+
+	// the cancelled call doesn't take a token on its way out
+	for _, name := range []string{"first", "last"} {
+		advance(time.Second)
+		if got := next(t, done); got != name {
+			t.Fatalf("got %s, want %s", got, name)
+		}
+	}
+}
+
+func TestLimiterCancelledBeforeQueueing(t *testing.T) {
+	l, _ := newTestLimiter(1, 1, nil)
+	l.Wait(context.Background(), "laps")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx, "laps"); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if l.queued() != 0 {
+		t.Errorf("%d calls left queued", l.queued())
+	}
+}
+
+func TestLimiterWeights(t *testing.T) {
+	t.Run("heavy resources take more tokens", func(t *testing.T) {
+		l, advance := newTestLimiter(1, 2, nil)
+		ctx := context.Background()
+		// car_data takes both tokens
+		l.Wait(ctx, "car_data")
+		done := make(chan string, 1)
+		queue(t, l, ctx, PriorityNormal, "car_data", "car_data", done)
+		advance(time.Second)
+		none(t, done)
+		advance(time.Second)
+		next(t, done)
+	})
+
+	t.Run("weights are capped at the burst", func(t *testing.T) {
+		l, advance := newTestLimiter(1, 3, map[string]float64{"location": 10})
+		ctx := context.Background()
+		if err := l.Wait(ctx, "location"); err != nil {
+			t.Fatal(err)
+		}
+		if l.queued() != 0 {
+			t.Fatal("a call weighted over the burst was queued with a full bucket")
+		}
+		done := make(chan string, 1)
+		queue(t, l, ctx, PriorityNormal, "location", "location", done)
+		advance(2 * time.Second)
+		none(t, done)
+		advance(time.Second)
+		next(t, done)
+	})
+}
+
+func TestLimiterQueueDepth(t *testing.T) {
+	l, advance := newTestLimiter(1, 1, nil)
+	ctx := context.Background()
+	l.Wait(ctx, "laps")
+	before := map[Priority]float64{}
+	for _, p := range []Priority{PriorityBackground, PriorityNormal, PriorityLive} {
+		before[p] = queueDepth(t, p)
+	}
+
+	done := make(chan string, 4)
+	cancelled, cancel := context.WithCancel(ctx)
+	queue(t, l, ctx, PriorityLive, "laps", "live", done)
+	queue(t, l, ctx, PriorityBackground, "laps", "background", done)
+	queue(t, l, cancelled, PriorityBackground, "laps", "cancelled", done)
+	queue(t, l, ctx, PriorityNormal, "laps", "normal", done)
+	if depth := queueDepth(t, PriorityBackground) - before[PriorityBackground]; depth != 2 {
+		t.Errorf("background queue depth = %v, want 2", depth)
+	}
+
+	cancel()
+	next(t, done)
+	for range 3 {
+		advance(time.Second)
+		next(t, done)
+	}
+	for p, depth := range before {
+		if got := queueDepth(t, p); got != depth {
+			t.Errorf("%s queue depth = %v once the queue is empty, want %v", p, got, depth)
+		}
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	if err := l.Wait(context.Background(), "car_data"); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,192 @@
+package openf1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Session is a single session from the openf1 /sessions resource, e.g. a practice, qualifying or race
+type Session struct {
+	CircuitKey       int       `json:"circuit_key"`
+	CircuitShortName string    `json:"circuit_short_name"`
+	CountryCode      string    `json:"country_code"`
+	CountryKey       int       `json:"country_key"`
+	CountryName      string    `json:"country_name"`
+	DateEnd          time.Time `json:"date_end"`
+	DateStart        time.Time `json:"date_start"`
+	GmtOffset        string    `json:"gmt_offset"`
+	Location         string    `json:"location"`
+	MeetingKey       int       `json:"meeting_key"`
+	SessionKey       int       `json:"session_key"`
+	SessionName      string    `json:"session_name"`
+	SessionType      string    `json:"session_type"`
+	Year             int       `json:"year"`
+}
+
+// GmtLocation returns the circuit's local time zone from the session's gmt offset, e.g. "-04:00:00"
+func (s Session) GmtLocation() (*time.Location, error) {
+	offset := strings.TrimPrefix(s.GmtOffset, "+")
+	sign, signStr := 1, "+"
+	if strings.HasPrefix(offset, "-") {
+		sign, signStr = -1, "-"
+		offset = offset[1:]
+	}
+	var hours, minutes, seconds int
+	if _, err := fmt.Sscanf(offset, "%d:%d:%d", &hours, &minutes, &seconds); err != nil {
+		return nil, fmt.Errorf("invalid gmt offset %q", s.GmtOffset)
+	}
+	name := fmt.Sprintf("UTC%s%02d:%02d", signStr, hours, minutes)
+	return time.FixedZone(name, sign*(hours*3600+minutes*60+seconds)), nil
+}
+
+// StartingGrid is a single entry from the openf1 /starting_grid resource
+// the session_key here is the qualifying (or sprint shootout) session the grid was set in
+type StartingGrid struct {
+	DriverNumber int      `json:"driver_number"`
+	LapDuration  *float64 `json:"lap_duration"`
+	MeetingKey   int      `json:"meeting_key"`
+	Position     int      `json:"position"`
+	SessionKey   int      `json:"session_key"`
+}
+
+// Lap is a single lap from the openf1 /laps resource
+// sector times and speeds are pointers as openf1 returns null for laps that were not completed
+type Lap struct {
+	MeetingKey   int       `json:"meeting_key"`
+	SessionKey   int       `json:"session_key"`
+	DriverNumber int       `json:"driver_number"`
+	LapNumber    int       `json:"lap_number"`
+	DateStart    time.Time `json:"date_start"`
+	DurationS1   *float64  `json:"duration_sector_1"`
+	DurationS2   *float64  `json:"duration_sector_2"`
+	DurationS3   *float64  `json:"duration_sector_3"`
+	SpeedI1      *int      `json:"i1_speed"`
+	SpeedI2      *int      `json:"i2_speed"`
+	IsPitOutLap  bool      `json:"is_pit_out_lap"`
+	LapDuration  *float64  `json:"lap_duration"`
+	SegmentsS1   []int     `json:"segments_sector_1"`
+	SegmentsS2   []int     `json:"segments_sector_2"`
+	SegmentsS3   []int     `json:"segments_sector_3"`
+	StSpeed      *int      `json:"st_speed"`
+}
+
+// RaceControl is a message from the openf1 /race_control resource
+// e.g. flags, track limits, deleted lap times and session status changes
+type RaceControl struct {
+	Category        string    `json:"category"`
+	Date            time.Time `json:"date"`
+	DriverNumber    *int      `json:"driver_number"`
+	Flag            *string   `json:"flag"`
+	LapNumber       *int      `json:"lap_number"`
+	MeetingKey      int       `json:"meeting_key"`
+	Message         string    `json:"message"`
+	QualifyingPhase *int      `json:"qualifying_phase"`
+	Scope           *string   `json:"scope"`
+	Sector          *int      `json:"sector"`
+	SessionKey      int       `json:"session_key"`
+}
+
+// SessionResult is a driver's final classification from the openf1 /session_result resource
+// position is null for drivers that were not classified
+type SessionResult struct {
+	DNF          bool     `json:"dnf"`
+	DNS          bool     `json:"dns"`
+	DSQ          bool     `json:"dsq"`
+	DriverNumber int      `json:"driver_number"`
+	Duration     *float64 `json:"duration"`
+	MeetingKey   int      `json:"meeting_key"`
+	NumberOfLaps int      `json:"number_of_laps"`
+	Position     *int     `json:"position"`
+	SessionKey   int      `json:"session_key"`
+}
+
+// Driver is a driver entry from the openf1 /drivers resource, drivers are listed per session
+type Driver struct {
+	BroadcastName string `json:"broadcast_name"`
+	CountryCode   string `json:"country_code"`
+	DriverNumber  int    `json:"driver_number"`
+	FirstName     string `json:"first_name"`
+	FullName      string `json:"full_name"`
+	HeadshotUrl   string `json:"headshot_url"`
+	LastName      string `json:"last_name"`
+	MeetingKey    int    `json:"meeting_key"`
+	NameAcronym   string `json:"name_acronym"`
+	SessionKey    int    `json:"session_key"`
+	TeamColour    string `json:"team_colour"`
+	TeamName      string `json:"team_name"`
+}
+
+// Meeting is a grand prix or testing weekend from the openf1 /meetings resource
+type Meeting struct {
+	CircuitKey          int    `json:"circuit_key"`
+	CircuitShortName    string `json:"circuit_short_name"`
+	CountryCode         string `json:"country_code"`
+	CountryKey          int    `json:"country_key"`
+	CountryName         string `json:"country_name"`
+	DateStart           string `json:"date_start"`
+	GmtOffset           string `json:"gmt_offset"`
+	Location            string `json:"location"`
+	MeetingKey          int    `json:"meeting_key"`
+	MeetingName         string `json:"meeting_name"`
+	MeetingOfficialName string `json:"meeting_official_name"`
+	Year                int    `json:"year"`
+}
+
+// CarData is a telemetry sample from the openf1 /car_data resource, sampled at around 3.7Hz
+type CarData struct {
+	Brake        int       `json:"brake"`
+	Date         time.Time `json:"date"`
+	DriverNumber int       `json:"driver_number"`
+	DRS          int       `json:"drs"`
+	MeetingKey   int       `json:"meeting_key"`
+	NGear        int       `json:"n_gear"`
+	RPM          int       `json:"rpm"`
+	SessionKey   int       `json:"session_key"`
+	Speed        int       `json:"speed"`
+	Throttle     int       `json:"throttle"`
+}
+
+// Position is a change in a driver's running position from the openf1 /position resource
+type Position struct {
+	Date         time.Time `json:"date"`
+	DriverNumber int       `json:"driver_number"`
+	MeetingKey   int       `json:"meeting_key"`
+	Position     int       `json:"position"`
+	SessionKey   int       `json:"session_key"`
+}
+
+// Location is a car's position on the circuit in metres, sampled at about 3.7Hz
+type Location struct {
+	Date         time.Time `json:"date"`
+	DriverNumber int       `json:"driver_number"`
+	MeetingKey   int       `json:"meeting_key"`
+	SessionKey   int       `json:"session_key"`
+	X            int       `json:"x"`
+	Y            int       `json:"y"`
+	Z            int       `json:"z"`
+}
+
+// Interval is a driver's gap from the openf1 /intervals resource, only available during races
+// gaps are usually seconds but can be a string such as "+1 LAP" so they are kept as raw JSON
+type Interval struct {
+	Date         time.Time       `json:"date"`
+	DriverNumber int             `json:"driver_number"`
+	GapToLeader  json.RawMessage `json:"gap_to_leader"`
+	Interval     json.RawMessage `json:"interval"`
+	MeetingKey   int             `json:"meeting_key"`
+	SessionKey   int             `json:"session_key"`
+}
+
+// Stint is a period a driver spent on one set of tyres, from the openf1 /stints resource
+type Stint struct {
+	Compound       string `json:"compound"`
+	DriverNumber   int    `json:"driver_number"`
+	LapEnd         int    `json:"lap_end"`
+	LapStart       int    `json:"lap_start"`
+	MeetingKey     int    `json:"meeting_key"`
+	SessionKey     int    `json:"session_key"`
+	StintNumber    int    `json:"stint_number"`
+	TyreAgeAtStart int    `json:"tyre_age_at_start"`
+}
@@ -0,0 +1,59 @@
+package openf1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// RateLimiter is what a Client waits on before every attempt
+// a *Limiter for calls from this process, a *RemoteLimiter to queue on the bucket of another
+type RateLimiter interface {
+	Wait(ctx context.Context, resource string) error
+}
+
+// LimiterHandler lets other processes queue on l, a request returns 204 once l lets a call to its resource through
+// they queue behind everything of the server's own, a backfill is never more urgent than a client waiting on a page
+// a caller that goes away leaves the queue without taking any tokens
+// there is no authentication, anyone who can reach it can hold up the server's calls, so only serve it on loopback
+func LimiterHandler(l *Limiter) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /wait", func(w http.ResponseWriter, r *http.Request) {
+		resource := r.URL.Query().Get("resource")
+		if resource == "" {
+			http.Error(w, "resource is required", http.StatusBadRequest)
+			return
+		}
+		if err := l.Wait(WithPriority(r.Context(), PriorityBackground), resource); err != nil {
+			// nobody is left to read the response
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// RemoteLimiter waits on the limiter another process serves with LimiterHandler, so both share one bucket
+type RemoteLimiter struct {
+	// URL is where LimiterHandler is served, e.g. http://localhost:7071
+	URL        string
+	HTTPClient *http.Client
+}
+
+func (l *RemoteLimiter) Wait(ctx context.Context, resource string) error {
+	waitUrl := fmt.Sprintf("%s/wait?resource=%s", l.URL, url.QueryEscape(resource))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, waitUrl, nil)
+	if err != nil {
+		return err
+	}
+	response, err := l.HTTPClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error waiting on the shared limiter: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("error waiting on the shared limiter: %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package openf1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newRemote serves l the way the server does and returns a limiter waiting on it from "another process"
+func newRemote(t *testing.T, l *Limiter) *RemoteLimiter {
+	t.Helper()
+	srv := httptest.NewServer(LimiterHandler(l))
+	t.Cleanup(srv.Close)
+	return &RemoteLimiter{URL: srv.URL, HTTPClient: srv.Client()}
+}
+
+func TestRemoteLimiterSharesTheBucket(t *testing.T) {
+	l, advance := newTestLimiter(1, 1, nil)
+	remote := newRemote(t, l)
+	ctx := context.Background()
+	if err := remote.Wait(ctx, "laps"); err != nil {
+		t.Fatal(err)
+	}
+	if l.queued() != 0 {
+		t.Fatal("a remote call was queued with a full bucket")
+	}
+
+	done := make(chan string, 3)
+	go func() {
+		if err := remote.Wait(ctx, "laps"); err != nil {
+			done <- "backfill: " + err.Error()
+			return
+		}
+		done <- "backfill"
+	}()
+	waitQueued(t, l, 1)
+	queue(t, l, ctx, PriorityNormal, "laps", "request", done)
+	queue(t, l, ctx, PriorityLive, "laps", "live", done)
+
+	// the backfill queued first but goes after everything of the server's
+	for _, name := range []string{"live", "request", "backfill"} {
+		advance(time.Second)
+		if got := next(t, done); got != name {
+			t.Fatalf("got %s, want %s", got, name)
+		}
+	}
+}
+
+func TestRemoteLimiterWeights(t *testing.T) {
+	l, advance := newTestLimiter(1, 2, nil)
+	remote := newRemote(t, l)
+	ctx := context.Background()
+	// car_data takes the whole bucket for the remote caller as it would for the server's
+	if err := remote.Wait(ctx, "car_data"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan string, 1)
+	queue(t, l, ctx, PriorityNormal, "laps", "request", done)
+	none(t, done)
+	advance(time.Second)
+	next(t, done)
+}
+
+func TestRemoteLimiterCancel(t *testing.T) {
+	l, _ := newTestLimiter(1, 1, nil)
+	remote := newRemote(t, l)
+	l.Wait(context.Background(), "laps")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- remote.Wait(ctx, "laps") }()
+	waitQueued(t, l, 1)
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	// the server sees the caller go and takes it out of the queue
+	waitQueued(t, l, 0)
+}
+
+func TestRemoteLimiterErrors(t *testing.T) {
+	t.Run("no server", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+		remote := &RemoteLimiter{URL: srv.URL, HTTPClient: http.DefaultClient}
+		if err := remote.Wait(context.Background(), "laps"); err == nil {
+			t.Error("err = nil with no server to wait on")
+		}
+	})
+
+	t.Run("not a limiter", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(srv.Close)
+		remote := &RemoteLimiter{URL: srv.URL, HTTPClient: srv.Client()}
+		if err := remote.Wait(context.Background(), "laps"); err == nil {
+			t.Error("err = nil for a 404")
+		}
+	})
+
+	t.Run("no resource", func(t *testing.T) {
+		l, _ := newTestLimiter(1, 1, nil)
+		srv := httptest.NewServer(LimiterHandler(l))
+		t.Cleanup(srv.Close)
+		response, err := srv.Client().Post(srv.URL+"/wait", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", response.StatusCode)
+		}
+	})
+}
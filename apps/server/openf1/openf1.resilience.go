@@ -0,0 +1,215 @@
+package openf1
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"telem-api-server/metrics"
+)
+
+// ErrCircuitOpen is returned without calling openf1 while the breaker is open
+var ErrCircuitOpen = errors.New("openf1 circuit breaker is open")
+
+// RetryPolicy is how a Client retries requests that failed with a transient error
+type RetryPolicy struct {
+	// MaxAttempts includes the first try, 1 turns retrying off
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Timeout is how long a single attempt may take, Timeouts overrides it for resources that are slow to respond
+	Timeout  time.Duration
+	Timeouts map[string]time.Duration
+}
+
+// DefaultRetry is the policy clients from NewClient start with
+var DefaultRetry = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   250 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+	Timeout:     15 * time.Second,
+	// a whole session of samples can take openf1 a while to put together
+	Timeouts: map[string]time.Duration{
+		"car_data": time.Minute,
+		"location": time.Minute,
+		"position": 30 * time.Second,
+	},
+}
+
+func (p RetryPolicy) timeout(resource string) time.Duration {
+	if timeout, ok := p.Timeouts[resource]; ok {
+		return timeout
+	}
+	return p.Timeout
+}
+
+// delay is how long to wait before the next attempt, the Retry-After openf1 sent or exponential backoff with full jitter
+// attempt is the number of attempts made so far, ok is false when openf1 asked for a longer wait than MaxDelay
+// retrying before then would only spend more of the rate limit, the error is returned for the breaker and stale data instead
+func (p RetryPolicy) delay(attempt int, err error) (d time.Duration, ok bool) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > p.MaxDelay {
+			return 0, false
+		}
+		return statusErr.RetryAfter, true
+	}
+	backoff := p.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	return rand.N(backoff + 1), true
+}
+
+// transient reports whether err is openf1 or the network failing rather than the request being wrong
+// these are retried and count against the breaker, no results and other 4xx responses are answers
+func transient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	if err == nil || errors.Is(err, ErrNoResults) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, errDecode) {
+		return false
+	}
+	// anything else is a failure to get a response at all, including an attempt timing out
+	return true
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// Breaker stops calls to openf1 after Threshold transient failures in a row, so requests fail fast and stored data is served
+// once Cooldown has passed a single trial call is let through, its result closes the breaker or opens it again
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+	now      func() time.Time
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown, state: BreakerClosed, now: time.Now}
+}
+
+// Allow returns ErrCircuitOpen while calls are being held back, a nil breaker allows everything
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.Cooldown {
+			return ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+		b.trial = true
+		return nil
+	case BreakerHalfOpen:
+		// only the trial call goes through until it has come back
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+		return nil
+	}
+	return nil
+}
+
+// Record counts the result of a call that Allow let through
+func (b *Breaker) Record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		// calls let through before it opened don't change anything
+		return
+	}
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		b.setState(BreakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		b.openedAt = b.now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// Cancel gives back a call Allow let through that ended without an answer, e.g. because the client went away
+// the failure count and state are left alone, a half-open breaker lets the next call through as its trial instead
+func (b *Breaker) Cancel() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+func (b *Breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState changes the state and logs the transitions, the caller holds the lock
+func (b *Breaker) setState(state BreakerState) {
+	if state == b.state {
+		return
+	}
+	log.Printf("openf1 circuit breaker is now %s, %d failures in a row", state, b.failures)
+	b.state = state
+	metrics.UpstreamBreakerState.Set(breakerGauge[state])
+}
+
+var breakerGauge = map[BreakerState]float64{BreakerClosed: 0, BreakerHalfOpen: 1, BreakerOpen: 2}
@@ -0,0 +1,71 @@
+package openf1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 10 * time.Second}
+	limited := func(retryAfter time.Duration) error {
+		return &StatusError{Resource: "laps", StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter}
+	}
+
+	tests := []struct {
+		name    string
+		attempt int
+		err     error
+		max     time.Duration
+		ok      bool
+	}{
+		{"backoff", 1, errors.New("connection reset"), 100 * time.Millisecond, true},
+		{"backoff doubles", 3, errors.New("connection reset"), 400 * time.Millisecond, true},
+		{"backoff is capped", 20, errors.New("connection reset"), 10 * time.Second, true},
+		{"retry after", 1, limited(5 * time.Second), 5 * time.Second, true},
+		{"retry after at the limit", 1, limited(10 * time.Second), 10 * time.Second, true},
+		// waiting less than openf1 asked would only spend more of the rate limit
+		{"retry after past the limit", 1, limited(time.Minute), 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, ok := policy.delay(test.attempt, test.err)
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if d < 0 || d > test.max {
+				t.Errorf("delay = %s, want at most %s", d, test.max)
+			}
+		})
+	}
+
+	// Retry-After is honoured exactly rather than jittered
+	if d, _ := policy.delay(1, limited(5*time.Second)); d != 5*time.Second {
+		t.Errorf("delay = %s, want the 5s openf1 asked for", d)
+	}
+}
+
+func TestLongRetryAfterIsNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+	client := &Client{BaseUrl: srv.URL, HTTPClient: srv.Client(), Retry: DefaultRetry, Breaker: NewBreaker(5, time.Minute)}
+
+	var out []Lap
+	err := client.Get(context.Background(), "laps", nil, &out)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter != time.Minute {
+		t.Fatalf("err = %v, want the 429 with its Retry-After", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("openf1 called %d times, want 1", n)
+	}
+}
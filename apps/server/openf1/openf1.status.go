@@ -0,0 +1,95 @@
+package openf1
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// StatusWindow is how far back the error rate in Status looks
+const StatusWindow = 5 * time.Minute
+
+// UpstreamStatus is how openf1 has been answering lately, for telling our outages apart from theirs
+type UpstreamStatus struct {
+	LastSuccess *time.Time `json:"last_success"`
+	LastError   *time.Time `json:"last_error"`
+	// LastErrorMessage is the error of the last failed call
+	LastErrorMessage string  `json:"last_error_message,omitempty"`
+	Window           string  `json:"window"`
+	Calls            int     `json:"calls"`
+	Errors           int     `json:"errors"`
+	ErrorRate        float64 `json:"error_rate"`
+	// Breaker is the state of the circuit breaker in front of openf1: closed, open or half-open
+	Breaker BreakerState `json:"breaker"`
+}
+
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+// tracker keeps the outcome of every call within StatusWindow, each Client has its own
+type tracker struct {
+	mu          sync.Mutex
+	outcomes    []outcome
+	lastSuccess time.Time
+	lastError   time.Time
+	lastErr     error
+}
+
+// track records a finished call, no results is an answer rather than a failure
+func (t *tracker) track(call Call, now time.Time) {
+	failed := call.Err != nil && !errors.Is(call.Err, ErrNoResults)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	t.outcomes = append(t.outcomes, outcome{at: now, failed: failed})
+	if failed {
+		t.lastError = now
+		t.lastErr = call.Err
+	} else {
+		t.lastSuccess = now
+	}
+}
+
+// prune drops the outcomes that have fallen out of the window, they are appended in order so they're all at the front
+func (t *tracker) prune(now time.Time) {
+	cutoff := now.Add(-StatusWindow)
+	i := 0
+	for i < len(t.outcomes) && t.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	t.outcomes = append(t.outcomes[:0], t.outcomes[i:]...)
+}
+
+func (t *tracker) status(now time.Time, breaker *Breaker) UpstreamStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+
+	s := UpstreamStatus{Window: StatusWindow.String(), Calls: len(t.outcomes), Breaker: breaker.State()}
+	for _, o := range t.outcomes {
+		if o.failed {
+			s.Errors++
+		}
+	}
+	if s.Calls > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Calls)
+	}
+	if !t.lastSuccess.IsZero() {
+		lastSuccess := t.lastSuccess
+		s.LastSuccess = &lastSuccess
+	}
+	if !t.lastError.IsZero() {
+		lastError := t.lastError
+		s.LastError = &lastError
+		s.LastErrorMessage = t.lastErr.Error()
+	}
+	return s
+}
+
+// Status reports the last successful call c made to openf1 and the error rate of its calls within StatusWindow
+// along with the state of its breaker, calls its own callers gave up on aren't counted
+func (c *Client) Status() UpstreamStatus {
+	return c.status.status(time.Now(), c.Breaker)
+}
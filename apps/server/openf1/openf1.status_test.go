@@ -0,0 +1,94 @@
+package openf1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newStatusClient is a client for srv that tries once, so every Get is a single call in the status
+func newStatusClient(srv *httptest.Server) *Client {
+	retry := DefaultRetry
+	retry.MaxAttempts = 1
+	return &Client{BaseUrl: srv.URL, HTTPClient: srv.Client(), Retry: retry, Breaker: NewBreaker(5, time.Minute)}
+}
+
+func TestStatusCountsOpenF1Failures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/laps":
+			w.Write([]byte("[]"))
+		case "/drivers":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "down", http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client := newStatusClient(srv)
+
+	var out []Lap
+	client.Get(context.Background(), "laps", nil, &out)
+	// no results is openf1 answering
+	client.Get(context.Background(), "drivers", nil, &out)
+	client.Get(context.Background(), "sessions", nil, &out)
+
+	status := client.Status()
+	if status.Calls != 3 || status.Errors != 1 {
+		t.Fatalf("calls = %d, errors = %d, want 3 and 1", status.Calls, status.Errors)
+	}
+	if status.LastSuccess == nil || status.LastError == nil || status.LastErrorMessage == "" {
+		t.Errorf("status = %+v, want a last success and a last error", status)
+	}
+	if status.Breaker != BreakerClosed {
+		t.Errorf("breaker = %s, want closed", status.Breaker)
+	}
+}
+
+func TestStatusSkipsCancelledCalls(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	client := newStatusClient(srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		var out []Lap
+		errs <- client.Get(ctx, "laps", nil, &out)
+	}()
+	<-started
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	// our caller going away says nothing about openf1
+	if status := client.Status(); status.Calls != 0 || status.LastError != nil {
+		t.Errorf("status = %+v, want no calls", status)
+	}
+}
+
+func TestStatusCountsAttemptTimeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	client := newStatusClient(srv)
+	client.Retry.Timeout = 10 * time.Millisecond
+
+	var out []Lap
+	if err := client.Get(context.Background(), "laps", nil, &out); err == nil {
+		t.Fatal("err = nil for an attempt that timed out")
+	}
+	// openf1 not answering in time is its failure, not our caller's
+	if status := client.Status(); status.Calls != 1 || status.Errors != 1 {
+		t.Errorf("calls = %d, errors = %d, want 1 and 1", status.Calls, status.Errors)
+	}
+}
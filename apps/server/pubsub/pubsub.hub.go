@@ -0,0 +1,260 @@
+// package for an in-process pub/sub hub with MQTT style topics
+// topics are levels separated by "/", e.g. sessions/9158/car_data/1
+// filters can use + to match a single level and # as the last level to match everything below it
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Next once the subscriber has been closed
+var ErrClosed = errors.New("subscriber closed")
+
+// DefaultBufferSize is the number of messages a subscriber holds before the oldest are dropped
+const DefaultBufferSize = 1024
+
+type Message struct {
+	Topic   string
+	Payload []byte
+	Time    time.Time
+}
+
+// Stats are counters across the hub since it was created
+type Stats struct {
+	Subscribers int    `json:"subscribers"`
+	Published   uint64 `json:"published"`
+	Delivered   uint64 `json:"delivered"`
+	Dropped     uint64 `json:"dropped"`
+}
+
+// Hub fans out published messages to every subscriber with a matching filter
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscriber]struct{}
+
+	published atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// NewHub returns an empty hub, the server builds one for the live feeds and handlers to publish to
+func NewHub() *Hub {
+	return &Hub{subscribers: map[*Subscriber]struct{}{}}
+}
+
+// Subscriber has a bounded buffer of messages, when it is full the oldest message is dropped
+// so a slow subscriber never holds up publishers or other subscribers
+type Subscriber struct {
+	hub *Hub
+
+	mu      sync.Mutex
+	filters map[string]struct{}
+	buffer  []Message
+	size    int
+	dropped uint64
+	closed  bool
+	// signalled whenever a message is added or the subscriber is closed
+	notify chan struct{}
+}
+
+// Subscribe creates a subscriber with the given filters, more can be added later
+func (h *Hub) Subscribe(bufferSize int, filters ...string) (*Subscriber, error) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	s := &Subscriber{
+		hub:     h,
+		filters: map[string]struct{}{},
+		size:    bufferSize,
+		notify:  make(chan struct{}, 1),
+	}
+	for _, filter := range filters {
+		if err := s.AddFilter(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = struct{}{}
+	return s, nil
+}
+
+// Publish sends a message to every matching subscriber, it never blocks on slow subscribers
+func (h *Hub) Publish(topic string, payload []byte) {
+	h.published.Add(1)
+	message := Message{Topic: topic, Payload: payload, Time: time.Now()}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscribers {
+		if s.matches(topic) {
+			s.push(message)
+		}
+	}
+}
+
+// Subscribed reports whether any subscriber would get a message on topic, so publishers can skip encoding it
+func (h *Hub) Subscribed(topic string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscribers {
+		if s.matches(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	subscribers := len(h.subscribers)
+	h.mu.RUnlock()
+	return Stats{
+		Subscribers: subscribers,
+		Published:   h.published.Load(),
+		Delivered:   h.delivered.Load(),
+		Dropped:     h.dropped.Load(),
+	}
+}
+
+// AddFilter subscribes to another topic filter
+func (s *Subscriber) AddFilter(filter string) error {
+	if err := ValidateFilter(filter); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters[filter] = struct{}{}
+	return nil
+}
+
+// RemoveFilter stops matching a filter, messages already buffered are still delivered
+func (s *Subscriber) RemoveFilter(filter string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.filters[filter]
+	delete(s.filters, filter)
+	return ok
+}
+
+// Dropped is the number of messages this subscriber lost because its buffer was full
+func (s *Subscriber) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *Subscriber) matches(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	for filter := range s.filters {
+		if Match(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscriber) push(message Message) {
+	s.mu.Lock()
+	if len(s.buffer) >= s.size {
+		// drop the oldest message to make room
+		s.buffer = s.buffer[1:]
+		s.dropped++
+		s.hub.dropped.Add(1)
+	}
+	s.buffer = append(s.buffer, message)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Next blocks until there is a message, the context is done or the subscriber is closed
+func (s *Subscriber) Next(ctx context.Context) (Message, error) {
+	for {
+		s.mu.Lock()
+		if len(s.buffer) > 0 {
+			message := s.buffer[0]
+			s.buffer = s.buffer[1:]
+			s.mu.Unlock()
+			s.hub.delivered.Add(1)
+			return message, nil
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return Message{}, ErrClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-s.notify:
+		}
+	}
+}
+
+// Close removes the subscriber from the hub, any waiting Next returns ErrClosed
+func (s *Subscriber) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subscribers, s)
+	s.hub.mu.Unlock()
+
+	s.mu.Lock()
+	s.closed = true
+	s.buffer = nil
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ValidateFilter checks the wildcards are used as whole levels and # only comes last
+func ValidateFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("empty topic filter")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return fmt.Errorf("invalid topic filter %q, # must be the last level on its own", filter)
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return fmt.Errorf("invalid topic filter %q, + must be a level on its own", filter)
+		}
+	}
+	return nil
+}
+
+// Match reports whether a topic matches a filter
+// as with MQTT, sessions/# also matches sessions itself
+func Match(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,120 @@
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"telem-api-server/pubsub"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"sessions/9158", "sessions/9158", true},
+		{"sessions/9158", "sessions/9159", false},
+		{"sessions/9158", "sessions/9158/laps", false},
+		{"sessions/9158/laps", "sessions/9158", false},
+		{"sessions/+", "sessions/9158", true},
+		{"sessions/+", "sessions/9158/laps", false},
+		// + is exactly one level, even an empty one, but never none
+		{"sessions/+", "sessions", false},
+		{"sessions/+", "sessions/", true},
+		{"sessions/+/car_data/+", "sessions/9158/car_data/1", true},
+		{"sessions/+/car_data/+", "sessions/9158/laps/1", false},
+		{"+/+", "sessions/9158", true},
+		{"#", "sessions/9158/car_data/1", true},
+		{"sessions/#", "sessions/9158/car_data/1", true},
+		{"sessions/#", "sessions/9158", true},
+		// # also matches the level it's under
+		{"sessions/#", "sessions", true},
+		{"sessions/#", "meetings/1219", false},
+		{"sessions/+/#", "sessions/9158", true},
+		{"sessions/+/#", "sessions", false},
+		{"sessions/9158/#", "sessions/91580/laps", false},
+	}
+	for _, test := range tests {
+		if got := pubsub.Match(test.filter, test.topic); got != test.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", test.filter, test.topic, got, test.want)
+		}
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{"sessions/9158", true},
+		{"sessions/+/laps", true},
+		{"sessions/#", true},
+		{"#", true},
+		{"+", true},
+		{"+/+/#", true},
+		{"", false},
+		{"sessions/#/laps", false},
+		{"sessions#", false},
+		{"sessions/9158#", false},
+		{"sessions/+9158", false},
+		{"sessions/9158+/laps", false},
+		{"#/#", false},
+	}
+	for _, test := range tests {
+		err := pubsub.ValidateFilter(test.filter)
+		if (err == nil) != test.valid {
+			t.Errorf("ValidateFilter(%q) = %v, want valid %v", test.filter, err, test.valid)
+		}
+	}
+
+	hub := pubsub.NewHub()
+	if _, err := hub.Subscribe(1, "sessions/#/laps"); err == nil {
+		t.Error("subscribed with an invalid filter")
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	hub := pubsub.NewHub()
+	sub, err := hub.Subscribe(2, "sessions/+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	other, err := hub.Subscribe(10, "sessions/+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	for _, topic := range []string{"sessions/1", "sessions/2", "sessions/3", "sessions/4"} {
+		hub.Publish(topic, nil)
+	}
+	// a message nobody is subscribed to isn't dropped, it was never buffered
+	hub.Publish("meetings/1", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, want := range []string{"sessions/3", "sessions/4"} {
+		message, err := sub.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.Topic != want {
+			t.Errorf("got %s, want %s", message.Topic, want)
+		}
+	}
+	if got := sub.Dropped(); got != 2 {
+		t.Errorf("subscriber dropped %d, want 2", got)
+	}
+	// a slow subscriber doesn't cost the others anything
+	if got := other.Dropped(); got != 0 {
+		t.Errorf("other subscriber dropped %d, want 0", got)
+	}
+
+	stats := hub.Stats()
+	if stats.Dropped != 2 || stats.Published != 5 || stats.Delivered != 2 || stats.Subscribers != 2 {
+		t.Errorf("stats = %+v, want 2 dropped, 5 published, 2 delivered and 2 subscribers", stats)
+	}
+}
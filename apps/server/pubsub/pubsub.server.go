@@ -0,0 +1,186 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Serve exposes the hub over a line based TCP protocol so local tools can subscribe without HTTP
+//
+// commands, one per line:
+//
+//	SUB <filter>    subscribe to a topic filter, e.g. SUB sessions/+/car_data/1
+//	UNSUB <filter>  stop matching a filter
+//	STATS           hub counters plus the messages this connection has dropped
+//	PING            replies PONG
+//	QUIT            closes the connection
+//
+// messages are sent as "MSG <topic> <payload>", the payload is single line JSON
+func Serve(listener net.Listener, hub *Hub) error {
+	return (&Server{Hub: hub}).Serve(listener)
+}
+
+// ListenAndServe listens on addr and serves the hub, addr should usually be a loopback address
+func ListenAndServe(addr string, hub *Hub) error {
+	return (&Server{Hub: hub}).ListenAndServe(addr)
+}
+
+// ErrServerClosed is returned by Server.Serve once Close has been called
+var ErrServerClosed = errors.New("pub/sub server closed")
+
+// Server serves Hub the way Serve does, and keeps track of its listeners and connections so Close can end them
+type Server struct {
+	Hub *Hub
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// Serve accepts connections on listener until it fails or the server is closed
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = map[net.Listener]struct{}{}
+		s.conns = map[net.Conn]struct{}{}
+	}
+	s.listeners[listener] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return ErrServerClosed
+		}
+		if err != nil {
+			delete(s.listeners, listener)
+			s.mu.Unlock()
+			return err
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			handleConn(conn, s.Hub)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// ListenAndServe listens on addr and serves the hub
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("pub/sub hub listening on %s", listener.Addr())
+	return s.Serve(listener)
+}
+
+// Close stops accepting connections and drops the open ones, their subscribers leave the hub as they end
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	for listener := range s.listeners {
+		err = errors.Join(err, listener.Close())
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+func handleConn(conn net.Conn, hub *Hub) {
+	defer conn.Close()
+	log.Printf("pub/sub client connected from %s", conn.RemoteAddr())
+
+	sub, err := hub.Subscribe(DefaultBufferSize)
+	if err != nil {
+		return
+	}
+	defer sub.Close()
+
+	// writes come from both the reader (replies) and the message loop
+	var writeMu sync.Mutex
+	writeLine := func(line string) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := io.WriteString(conn, line+"\n")
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			message, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			if err := writeLine(fmt.Sprintf("MSG %s %s", message.Topic, message.Payload)); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		arg = strings.TrimSpace(arg)
+
+		var reply string
+		switch strings.ToUpper(command) {
+		case "":
+			continue
+		case "SUB":
+			if err := sub.AddFilter(arg); err != nil {
+				reply = "ERR " + err.Error()
+			} else {
+				reply = "OK SUB " + arg
+			}
+		case "UNSUB":
+			if sub.RemoveFilter(arg) {
+				reply = "OK UNSUB " + arg
+			} else {
+				reply = "ERR not subscribed to " + arg
+			}
+		case "STATS":
+			stats := hub.Stats()
+			reply = fmt.Sprintf("STATS subscribers=%d published=%d delivered=%d dropped=%d client_dropped=%d",
+				stats.Subscribers, stats.Published, stats.Delivered, stats.Dropped, sub.Dropped())
+		case "PING":
+			reply = "PONG"
+		case "QUIT":
+			writeLine("BYE")
+			return
+		default:
+			reply = "ERR unknown command " + command
+		}
+		if err := writeLine(reply); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package pubsub_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"telem-api-server/pubsub"
+)
+
+func TestServerClose(t *testing.T) {
+	hub := pubsub.NewHub()
+	srv := &pubsub.Server{Hub: hub}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("PING\n"))
+	if line, err := r.ReadString('\n'); err != nil || line != "PONG\n" {
+		t.Fatalf("PING got %q, %v", line, err)
+	}
+
+	srv.Close()
+	select {
+	case err := <-served:
+		if !errors.Is(err, pubsub.ErrServerClosed) {
+			t.Errorf("Serve returned %v, want ErrServerClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve didn't return after Close")
+	}
+	// the open connection is dropped rather than left for the client to end
+	if _, err := r.ReadString('\n'); !errors.Is(err, io.EOF) {
+		t.Errorf("read after Close = %v, want EOF", err)
+	}
+	if _, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second); err == nil {
+		t.Error("listener still accepting after Close")
+	}
+
+	// its subscriber leaves the hub with it
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.Stats().Subscribers != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("hub has %d subscribers after Close, want 0", hub.Stats().Subscribers)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
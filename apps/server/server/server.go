@@ -0,0 +1,109 @@
+// package for running the HTTP server with timeouts, TLS and a graceful shutdown
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Config is how the server listens, the zero value of a timeout leaves it off
+type Config struct {
+	// Addr is host:port, ":8080" listens on every interface
+	Addr string
+	// the timeouts are per request, long lived streams clear the write deadline for themselves
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// ShutdownTimeout is how long in-flight requests get to finish once shutdown starts
+	ShutdownTimeout time.Duration
+	// TLS is served when both files are set, they're reloaded when they change so certificates can be renewed in place
+	TLSCertFile string
+	TLSKeyFile  string
+	// how often the certificate files are checked for changes
+	CertReloadInterval time.Duration
+}
+
+// Server is an http.Server that shuts down gracefully when its context is done
+type Server struct {
+	cfg  Config
+	http *http.Server
+}
+
+func New(cfg Config, handler http.Handler) *Server {
+	return &Server{
+		cfg: cfg,
+		http: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           handler,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+			ErrorLog:          log.Default(),
+		},
+	}
+}
+
+// OnShutdown is called as soon as shutdown starts, for ending event streams and websockets
+// the server only waits for requests to finish, so anything that would otherwise run forever has to be told to stop here
+func (s *Server) OnShutdown(f func()) {
+	s.http.RegisterOnShutdown(f)
+}
+
+// Run serves until ctx is done, then stops accepting connections and waits up to ShutdownTimeout for requests in flight
+// connections still open after that are closed, Run only returns once the server has stopped
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if s.cfg.TLSCertFile != "" {
+		certs, err := newCertReloader(s.cfg.TLSCertFile, s.cfg.TLSKeyFile, s.cfg.CertReloadInterval)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		s.http.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if s.http.TLSConfig != nil {
+			log.Printf("Server is running at https://%s", listener.Addr())
+			// the certificate comes from GetCertificate, ServeTLS also sets up HTTP/2
+			served <- s.http.ServeTLS(listener, "", "")
+			return
+		}
+		log.Printf("Server is running at http://%s", listener.Addr())
+		served <- s.http.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down, waiting up to %s for requests in flight", s.cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(shutdownCtx); err != nil {
+		log.Printf("requests still in flight after %s, closing their connections: %v", s.cfg.ShutdownTimeout, err)
+		s.http.Close()
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves the certificate in certFile and keyFile, loading it again when either file changes
+// the files are checked at most once an interval during handshakes, so renewing them needs no restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	modified    time.Time
+	lastChecked time.Time
+}
+
+func newCertReloader(certFile string, keyFile string, interval time.Duration) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	modified, err := c.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modified, time.Now()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastChecked) < c.interval {
+		return c.cert, nil
+	}
+	c.lastChecked = now
+	modified, err := c.modTime()
+	if err != nil || !modified.After(c.modified) {
+		// a file being replaced can briefly be missing, the current certificate is kept until both are back
+		return c.cert, nil
+	}
+	if err := c.load(modified, now); err != nil {
+		// e.g. the certificate has been written but not the key yet, it is tried again next interval
+		log.Printf("Error reloading TLS certificate, keeping the current one: %v", err)
+	}
+	return c.cert, nil
+}
+
+// load replaces the certificate, the caller holds the lock or is the constructor
+func (c *certReloader) load(modified time.Time, now time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+	if c.cert != nil {
+		log.Printf("reloaded TLS certificate from %s", c.certFile)
+	}
+	c.cert = &cert
+	c.modified = modified
+	c.lastChecked = now
+	return nil
+}
+
+// modTime is the later modification time of the certificate and key files
+func (c *certReloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error reading TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
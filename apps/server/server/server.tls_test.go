@@ -0,0 +1,144 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"telem-api-server/server"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 with serial to certFile and its key to keyFile
+// both files are dated modified, so a rewrite is seen as a change however quickly it comes
+func writeCert(t *testing.T, certFile string, keyFile string, serial int64, modified time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serial is the serial number of the certificate a new connection to addr is served
+func serial(t *testing.T, addr string) int64 {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, 1, start)
+
+	addr := freeAddr(t)
+	// checked on every handshake
+	srv := server.New(server.Config{Addr: addr, TLSCertFile: certFile, TLSKeyFile: keyFile, ShutdownTimeout: time.Second}, http.NotFoundHandler())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := run(t, ctx, srv, addr)
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	if got := serial(t, addr); got != 1 {
+		t.Fatalf("serial = %d, want 1", got)
+	}
+
+	// renewed in place
+	writeCert(t, certFile, keyFile, 2, start.Add(time.Minute))
+	if got := serial(t, addr); got != 2 {
+		t.Errorf("serial = %d after renewing, want 2", got)
+	}
+
+	// a key that doesn't go with the certificate, e.g. half way through a renewal, keeps the current one
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyFile, start.Add(2*time.Minute), start.Add(2*time.Minute))
+	if got := serial(t, addr); got != 2 {
+		t.Errorf("serial = %d with a broken key, want 2 kept", got)
+	}
+	// as does a file that has gone missing while being replaced
+	os.Remove(certFile)
+	if got := serial(t, addr); got != 2 {
+		t.Errorf("serial = %d with no certificate file, want 2 kept", got)
+	}
+
+	writeCert(t, certFile, keyFile, 3, start.Add(3*time.Minute))
+	if got := serial(t, addr); got != 3 {
+		t.Errorf("serial = %d once both files are back, want 3", got)
+	}
+}
+
+func TestCertificateReloadInterval(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, 1, start)
+
+	addr := freeAddr(t)
+	srv := server.New(server.Config{Addr: addr, TLSCertFile: certFile, TLSKeyFile: keyFile, CertReloadInterval: time.Hour, ShutdownTimeout: time.Second}, http.NotFoundHandler())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := run(t, ctx, srv, addr)
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// the files aren't looked at again within the interval
+	writeCert(t, certFile, keyFile, 2, start.Add(time.Minute))
+	if got := serial(t, addr); got != 1 {
+		t.Errorf("serial = %d within the reload interval, want 1", got)
+	}
+}
+
+func TestCertificateMissing(t *testing.T) {
+	dir := t.TempDir()
+	srv := server.New(server.Config{Addr: freeAddr(t), TLSCertFile: filepath.Join(dir, "cert.pem"), TLSKeyFile: filepath.Join(dir, "key.pem")}, http.NotFoundHandler())
+	if err := srv.Run(context.Background()); err == nil {
+		t.Error("Run without the certificate files returned nil")
+	}
+}
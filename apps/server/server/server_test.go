@@ -0,0 +1,159 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"telem-api-server/server"
+)
+
+// freeAddr is a loopback address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// run starts srv and returns a channel with Run's result, once the server answers
+func run(t *testing.T, ctx context.Context, srv *server.Server, addr string) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return done
+		}
+	}
+	t.Fatal("server didn't start")
+	return nil
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := server.New(server.Config{Addr: addr, ShutdownTimeout: 5 * time.Second}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("done"))
+	}))
+	hooked := make(chan struct{})
+	srv.OnShutdown(func() { close(hooked) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := run(t, ctx, srv, addr)
+
+	type result struct {
+		body string
+		err  error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		response, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		slow <- result{body: string(body), err: err}
+	}()
+	<-started
+	cancel()
+
+	// the hooks are told as soon as shutdown starts, while the request is still in flight
+	select {
+	case <-hooked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown hook wasn't called")
+	}
+	// no new connections are taken
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("still accepting connections after shutdown started")
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the request in flight finishes, then Run returns
+	close(release)
+	if r := <-slow; r.err != nil || r.body != "done" {
+		t.Errorf("request in flight got %q, %v, want it to finish", r.body, r.err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run = %v, want nil after a graceful shutdown", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run didn't return")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	addr := freeAddr(t)
+	started := make(chan struct{})
+	srv := server.New(server.Config{Addr: addr, ShutdownTimeout: 50 * time.Millisecond}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		// a handler that never finishes by itself
+		<-r.Context().Done()
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := run(t, ctx, srv, addr)
+
+	failed := make(chan error, 1)
+	go func() {
+		response, err := http.Get("http://" + addr + "/stuck")
+		if err == nil {
+			response.Body.Close()
+		}
+		failed <- err
+	}()
+	<-started
+	cancel()
+
+	// its connection is closed once the timeout is up
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run didn't return after the shutdown timeout")
+	}
+	if err := <-failed; err == nil {
+		t.Error("stuck request got a response, want its connection closed")
+	}
+}
+
+func TestRunListenError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	srv := server.New(server.Config{Addr: listener.Addr().String()}, http.NotFoundHandler())
+	if err := srv.Run(context.Background()); err == nil {
+		t.Error("Run on an address in use returned nil")
+	}
+}
@@ -0,0 +1,107 @@
+-- timestamps are stored as UTC text in a fixed width format so they sort correctly
+
+CREATE TABLE sessions (
+    session_key        INTEGER PRIMARY KEY,
+    meeting_key        INTEGER NOT NULL,
+    circuit_key        INTEGER NOT NULL,
+    circuit_short_name TEXT NOT NULL,
+    country_code       TEXT NOT NULL,
+    country_key        INTEGER NOT NULL,
+    country_name       TEXT NOT NULL,
+    date_start         TEXT,
+    date_end           TEXT,
+    gmt_offset         TEXT NOT NULL,
+    location           TEXT NOT NULL,
+    session_name       TEXT NOT NULL,
+    session_type       TEXT NOT NULL,
+    year               INTEGER NOT NULL
+);
+CREATE INDEX sessions_year ON sessions (year);
+CREATE INDEX sessions_meeting_key ON sessions (meeting_key);
+
+CREATE TABLE meetings (
+    meeting_key           INTEGER PRIMARY KEY,
+    circuit_key           INTEGER NOT NULL,
+    circuit_short_name    TEXT NOT NULL,
+    country_code          TEXT NOT NULL,
+    country_key           INTEGER NOT NULL,
+    country_name          TEXT NOT NULL,
+    date_start            TEXT NOT NULL,
+    gmt_offset            TEXT NOT NULL,
+    location              TEXT NOT NULL,
+    meeting_name          TEXT NOT NULL,
+    meeting_official_name TEXT NOT NULL,
+    year                  INTEGER NOT NULL
+);
+CREATE INDEX meetings_year ON meetings (year);
+
+CREATE TABLE drivers (
+    session_key    INTEGER NOT NULL,
+    driver_number  INTEGER NOT NULL,
+    meeting_key    INTEGER NOT NULL,
+    broadcast_name TEXT NOT NULL,
+    country_code   TEXT NOT NULL,
+    first_name     TEXT NOT NULL,
+    full_name      TEXT NOT NULL,
+    headshot_url   TEXT NOT NULL,
+    last_name      TEXT NOT NULL,
+    name_acronym   TEXT NOT NULL,
+    team_colour    TEXT NOT NULL,
+    team_name      TEXT NOT NULL,
+    PRIMARY KEY (session_key, driver_number)
+);
+
+-- sector times and speeds are null for laps openf1 couldn't time, segments are JSON arrays
+CREATE TABLE laps (
+    session_key       INTEGER NOT NULL,
+    driver_number     INTEGER NOT NULL,
+    lap_number        INTEGER NOT NULL,
+    meeting_key       INTEGER NOT NULL,
+    date_start        TEXT,
+    duration_sector_1 REAL,
+    duration_sector_2 REAL,
+    duration_sector_3 REAL,
+    i1_speed          INTEGER,
+    i2_speed          INTEGER,
+    is_pit_out_lap    INTEGER NOT NULL,
+    lap_duration      REAL,
+    segments_sector_1 TEXT,
+    segments_sector_2 TEXT,
+    segments_sector_3 TEXT,
+    st_speed          INTEGER,
+    PRIMARY KEY (session_key, driver_number, lap_number)
+);
+
+CREATE TABLE stints (
+    session_key       INTEGER NOT NULL,
+    driver_number     INTEGER NOT NULL,
+    stint_number      INTEGER NOT NULL,
+    meeting_key       INTEGER NOT NULL,
+    compound          TEXT NOT NULL,
+    lap_start         INTEGER NOT NULL,
+    lap_end           INTEGER NOT NULL,
+    tyre_age_at_start INTEGER NOT NULL,
+    PRIMARY KEY (session_key, driver_number, stint_number)
+);
+
+CREATE TABLE car_data (
+    session_key   INTEGER NOT NULL,
+    driver_number INTEGER NOT NULL,
+    date          TEXT NOT NULL,
+    meeting_key   INTEGER NOT NULL,
+    brake         INTEGER NOT NULL,
+    drs           INTEGER NOT NULL,
+    n_gear        INTEGER NOT NULL,
+    rpm           INTEGER NOT NULL,
+    speed         INTEGER NOT NULL,
+    throttle      INTEGER NOT NULL,
+    PRIMARY KEY (session_key, driver_number, date)
+) WITHOUT ROWID;
+
+CREATE TABLE fetches (
+    resource   TEXT NOT NULL,
+    scope      TEXT NOT NULL,
+    fetched_at TEXT NOT NULL,
+    complete   INTEGER NOT NULL,
+    PRIMARY KEY (resource, scope)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE location (
+    session_key   INTEGER NOT NULL,
+    driver_number INTEGER NOT NULL,
+    date          TEXT NOT NULL,
+    meeting_key   INTEGER NOT NULL,
+    x             INTEGER NOT NULL,
+    y             INTEGER NOT NULL,
+    z             INTEGER NOT NULL,
+    PRIMARY KEY (session_key, driver_number, date)
+) WITHOUT ROWID;
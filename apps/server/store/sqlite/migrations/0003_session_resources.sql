@@ -0,0 +1,57 @@
+CREATE TABLE session_results (
+    session_key    INTEGER NOT NULL,
+    driver_number  INTEGER NOT NULL,
+    meeting_key    INTEGER NOT NULL,
+    position       INTEGER,
+    number_of_laps INTEGER NOT NULL,
+    duration       REAL,
+    dnf            INTEGER NOT NULL,
+    dns            INTEGER NOT NULL,
+    dsq            INTEGER NOT NULL,
+    PRIMARY KEY (session_key, driver_number)
+);
+
+CREATE TABLE starting_grid (
+    session_key   INTEGER NOT NULL,
+    driver_number INTEGER NOT NULL,
+    meeting_key   INTEGER NOT NULL,
+    position      INTEGER NOT NULL,
+    lap_duration  REAL,
+    PRIMARY KEY (session_key, driver_number)
+);
+
+-- messages have no id of their own, the same message can't be sent twice at the same moment
+CREATE TABLE race_control (
+    session_key      INTEGER NOT NULL,
+    date             TEXT NOT NULL,
+    category         TEXT NOT NULL,
+    message          TEXT NOT NULL,
+    meeting_key      INTEGER NOT NULL,
+    driver_number    INTEGER,
+    flag             TEXT,
+    lap_number       INTEGER,
+    qualifying_phase INTEGER,
+    scope            TEXT,
+    sector           INTEGER,
+    PRIMARY KEY (session_key, date, category, message)
+);
+
+CREATE TABLE position (
+    session_key   INTEGER NOT NULL,
+    driver_number INTEGER NOT NULL,
+    date          TEXT NOT NULL,
+    meeting_key   INTEGER NOT NULL,
+    position      INTEGER NOT NULL,
+    PRIMARY KEY (session_key, driver_number, date)
+) WITHOUT ROWID;
+
+-- gaps are seconds or a string such as "+1 LAP", they are kept as the JSON openf1 sent
+CREATE TABLE intervals (
+    session_key   INTEGER NOT NULL,
+    driver_number INTEGER NOT NULL,
+    date          TEXT NOT NULL,
+    meeting_key   INTEGER NOT NULL,
+    gap_to_leader TEXT,
+    interval      TEXT,
+    PRIMARY KEY (session_key, driver_number, date)
+) WITHOUT ROWID;
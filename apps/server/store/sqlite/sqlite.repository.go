@@ -0,0 +1,374 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// Sessions
+const sessionColumns = `session_key, meeting_key, circuit_key, circuit_short_name, country_code, country_key,
+	country_name, date_start, date_end, gmt_offset, location, session_name, session_type, year`
+
+func scanSession(row interface{ Scan(...any) error }) (openf1.Session, error) {
+	var s openf1.Session
+	var dateStart, dateEnd sql.NullString
+	err := row.Scan(&s.SessionKey, &s.MeetingKey, &s.CircuitKey, &s.CircuitShortName, &s.CountryCode, &s.CountryKey,
+		&s.CountryName, &dateStart, &dateEnd, &s.GmtOffset, &s.Location, &s.SessionName, &s.SessionType, &s.Year)
+	if err != nil {
+		return s, err
+	}
+	if s.DateStart, err = parseNullTime(dateStart); err != nil {
+		return s, err
+	}
+	s.DateEnd, err = parseNullTime(dateEnd)
+	return s, err
+}
+
+func (s *Store) ListSessions(ctx context.Context) ([]openf1.Session, error) {
+	return listAll(ctx, s.db, `SELECT `+sessionColumns+` FROM sessions ORDER BY date_start, session_key`,
+		func(rows *sql.Rows) (openf1.Session, error) { return scanSession(rows) })
+}
+
+func (s *Store) GetSession(ctx context.Context, sessionKey int) (openf1.Session, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE session_key = ?`, sessionKey)
+	session, err := scanSession(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, store.ErrNotFound
+	}
+	return session, err
+}
+
+func (s *Store) SaveSessions(ctx context.Context, sessions []openf1.Session) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO sessions (`+sessionColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, sessions,
+		func(session openf1.Session) []any {
+			return []any{session.SessionKey, session.MeetingKey, session.CircuitKey, session.CircuitShortName,
+				session.CountryCode, session.CountryKey, session.CountryName, nullTime(session.DateStart),
+				nullTime(session.DateEnd), session.GmtOffset, session.Location, session.SessionName,
+				session.SessionType, session.Year}
+		})
+}
+
+// Meetings
+const meetingColumns = `meeting_key, circuit_key, circuit_short_name, country_code, country_key, country_name,
+	date_start, gmt_offset, location, meeting_name, meeting_official_name, year`
+
+func (s *Store) ListMeetings(ctx context.Context, year int) ([]openf1.Meeting, error) {
+	return listAll(ctx, s.db, `SELECT `+meetingColumns+` FROM meetings WHERE year = ? ORDER BY date_start`,
+		func(rows *sql.Rows) (openf1.Meeting, error) {
+			var m openf1.Meeting
+			err := rows.Scan(&m.MeetingKey, &m.CircuitKey, &m.CircuitShortName, &m.CountryCode, &m.CountryKey,
+				&m.CountryName, &m.DateStart, &m.GmtOffset, &m.Location, &m.MeetingName, &m.MeetingOfficialName, &m.Year)
+			return m, err
+		}, year)
+}
+
+func (s *Store) SaveMeetings(ctx context.Context, meetings []openf1.Meeting) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO meetings (`+meetingColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, meetings,
+		func(m openf1.Meeting) []any {
+			return []any{m.MeetingKey, m.CircuitKey, m.CircuitShortName, m.CountryCode, m.CountryKey, m.CountryName,
+				m.DateStart, m.GmtOffset, m.Location, m.MeetingName, m.MeetingOfficialName, m.Year}
+		})
+}
+
+// Drivers
+const driverColumns = `session_key, driver_number, meeting_key, broadcast_name, country_code, first_name,
+	full_name, headshot_url, last_name, name_acronym, team_colour, team_name`
+
+func (s *Store) ListDrivers(ctx context.Context, sessionKey int) ([]openf1.Driver, error) {
+	return listAll(ctx, s.db, `SELECT `+driverColumns+` FROM drivers WHERE session_key = ? ORDER BY driver_number`,
+		func(rows *sql.Rows) (openf1.Driver, error) {
+			var d openf1.Driver
+			err := rows.Scan(&d.SessionKey, &d.DriverNumber, &d.MeetingKey, &d.BroadcastName, &d.CountryCode,
+				&d.FirstName, &d.FullName, &d.HeadshotUrl, &d.LastName, &d.NameAcronym, &d.TeamColour, &d.TeamName)
+			return d, err
+		}, sessionKey)
+}
+
+func (s *Store) SaveDrivers(ctx context.Context, drivers []openf1.Driver) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO drivers (`+driverColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, drivers,
+		func(d openf1.Driver) []any {
+			return []any{d.SessionKey, d.DriverNumber, d.MeetingKey, d.BroadcastName, d.CountryCode, d.FirstName,
+				d.FullName, d.HeadshotUrl, d.LastName, d.NameAcronym, d.TeamColour, d.TeamName}
+		})
+}
+
+// Laps
+const lapColumns = `session_key, driver_number, lap_number, meeting_key, date_start, duration_sector_1,
+	duration_sector_2, duration_sector_3, i1_speed, i2_speed, is_pit_out_lap, lap_duration, segments_sector_1,
+	segments_sector_2, segments_sector_3, st_speed`
+
+func (s *Store) ListLaps(ctx context.Context, sessionKey int) ([]openf1.Lap, error) {
+	return listAll(ctx, s.db, `SELECT `+lapColumns+` FROM laps WHERE session_key = ? ORDER BY driver_number, lap_number`,
+		func(rows *sql.Rows) (openf1.Lap, error) {
+			var l openf1.Lap
+			var dateStart, segments1, segments2, segments3 sql.NullString
+			err := rows.Scan(&l.SessionKey, &l.DriverNumber, &l.LapNumber, &l.MeetingKey, &dateStart, &l.DurationS1,
+				&l.DurationS2, &l.DurationS3, &l.SpeedI1, &l.SpeedI2, &l.IsPitOutLap, &l.LapDuration, &segments1,
+				&segments2, &segments3, &l.StSpeed)
+			if err != nil {
+				return l, err
+			}
+			if l.DateStart, err = parseNullTime(dateStart); err != nil {
+				return l, err
+			}
+			for _, segments := range []struct {
+				value sql.NullString
+				dest  *[]int
+			}{{segments1, &l.SegmentsS1}, {segments2, &l.SegmentsS2}, {segments3, &l.SegmentsS3}} {
+				if segments.value.Valid {
+					if err := json.Unmarshal([]byte(segments.value.String), segments.dest); err != nil {
+						return l, err
+					}
+				}
+			}
+			return l, nil
+		}, sessionKey)
+}
+
+func (s *Store) SaveLaps(ctx context.Context, laps []openf1.Lap) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO laps (`+lapColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, laps,
+		func(l openf1.Lap) []any {
+			return []any{l.SessionKey, l.DriverNumber, l.LapNumber, l.MeetingKey, nullTime(l.DateStart), l.DurationS1,
+				l.DurationS2, l.DurationS3, l.SpeedI1, l.SpeedI2, l.IsPitOutLap, l.LapDuration,
+				segmentsJSON(l.SegmentsS1), segmentsJSON(l.SegmentsS2), segmentsJSON(l.SegmentsS3), l.StSpeed}
+		})
+}
+
+// segmentsJSON stores mini sector segments as a JSON array, NULL when openf1 didn't send any
+func segmentsJSON(segments []int) sql.NullString {
+	if segments == nil {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(segments)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// Stints
+const stintColumns = `session_key, driver_number, stint_number, meeting_key, compound, lap_start, lap_end, tyre_age_at_start`
+
+func (s *Store) ListStints(ctx context.Context, sessionKey int) ([]openf1.Stint, error) {
+	return listAll(ctx, s.db, `SELECT `+stintColumns+` FROM stints WHERE session_key = ? ORDER BY driver_number, stint_number`,
+		func(rows *sql.Rows) (openf1.Stint, error) {
+			var st openf1.Stint
+			err := rows.Scan(&st.SessionKey, &st.DriverNumber, &st.StintNumber, &st.MeetingKey, &st.Compound,
+				&st.LapStart, &st.LapEnd, &st.TyreAgeAtStart)
+			return st, err
+		}, sessionKey)
+}
+
+func (s *Store) SaveStints(ctx context.Context, stints []openf1.Stint) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO stints (`+stintColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, stints,
+		func(st openf1.Stint) []any {
+			return []any{st.SessionKey, st.DriverNumber, st.StintNumber, st.MeetingKey, st.Compound, st.LapStart,
+				st.LapEnd, st.TyreAgeAtStart}
+		})
+}
+
+// Car data
+const carDataColumns = `session_key, driver_number, date, meeting_key, brake, drs, n_gear, rpm, speed, throttle`
+
+func (s *Store) ListCarData(ctx context.Context, sessionKey int, driverNumber int) ([]openf1.CarData, error) {
+	scan := func(rows *sql.Rows) (openf1.CarData, error) {
+		var c openf1.CarData
+		var date string
+		err := rows.Scan(&c.SessionKey, &c.DriverNumber, &date, &c.MeetingKey, &c.Brake, &c.DRS, &c.NGear, &c.RPM,
+			&c.Speed, &c.Throttle)
+		if err != nil {
+			return c, err
+		}
+		c.Date, err = parseTime(date)
+		return c, err
+	}
+	if driverNumber == 0 {
+		return listAll(ctx, s.db, `SELECT `+carDataColumns+` FROM car_data WHERE session_key = ? ORDER BY date, driver_number`,
+			scan, sessionKey)
+	}
+	return listAll(ctx, s.db, `SELECT `+carDataColumns+` FROM car_data WHERE session_key = ? AND driver_number = ? ORDER BY date`,
+		scan, sessionKey, driverNumber)
+}
+
+func (s *Store) SaveCarData(ctx context.Context, samples []openf1.CarData) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO car_data (`+carDataColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, samples,
+		func(c openf1.CarData) []any {
+			return []any{c.SessionKey, c.DriverNumber, formatTime(c.Date), c.MeetingKey, c.Brake, c.DRS, c.NGear, c.RPM,
+				c.Speed, c.Throttle}
+		})
+}
+
+// Location
+const locationColumns = `session_key, driver_number, date, meeting_key, x, y, z`
+
+func (s *Store) ListLocation(ctx context.Context, sessionKey int, driverNumber int) ([]openf1.Location, error) {
+	scan := func(rows *sql.Rows) (openf1.Location, error) {
+		var l openf1.Location
+		var date string
+		err := rows.Scan(&l.SessionKey, &l.DriverNumber, &date, &l.MeetingKey, &l.X, &l.Y, &l.Z)
+		if err != nil {
+			return l, err
+		}
+		l.Date, err = parseTime(date)
+		return l, err
+	}
+	if driverNumber == 0 {
+		return listAll(ctx, s.db, `SELECT `+locationColumns+` FROM location WHERE session_key = ? ORDER BY date, driver_number`,
+			scan, sessionKey)
+	}
+	return listAll(ctx, s.db, `SELECT `+locationColumns+` FROM location WHERE session_key = ? AND driver_number = ? ORDER BY date`,
+		scan, sessionKey, driverNumber)
+}
+
+func (s *Store) SaveLocation(ctx context.Context, samples []openf1.Location) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO location (`+locationColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`, samples,
+		func(l openf1.Location) []any {
+			return []any{l.SessionKey, l.DriverNumber, formatTime(l.Date), l.MeetingKey, l.X, l.Y, l.Z}
+		})
+}
+
+// Session results
+const sessionResultColumns = `session_key, driver_number, meeting_key, position, number_of_laps, duration, dnf, dns, dsq`
+
+func (s *Store) ListSessionResults(ctx context.Context, sessionKey int) ([]openf1.SessionResult, error) {
+	// unclassified drivers have no position and go last
+	return listAll(ctx, s.db, `SELECT `+sessionResultColumns+` FROM session_results WHERE session_key = ?
+		ORDER BY position IS NULL, position, driver_number`,
+		func(rows *sql.Rows) (openf1.SessionResult, error) {
+			var r openf1.SessionResult
+			err := rows.Scan(&r.SessionKey, &r.DriverNumber, &r.MeetingKey, &r.Position, &r.NumberOfLaps, &r.Duration,
+				&r.DNF, &r.DNS, &r.DSQ)
+			return r, err
+		}, sessionKey)
+}
+
+func (s *Store) SaveSessionResults(ctx context.Context, results []openf1.SessionResult) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO session_results (`+sessionResultColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, results,
+		func(r openf1.SessionResult) []any {
+			return []any{r.SessionKey, r.DriverNumber, r.MeetingKey, r.Position, r.NumberOfLaps, r.Duration, r.DNF, r.DNS,
+				r.DSQ}
+		})
+}
+
+// Starting grid
+const startingGridColumns = `session_key, driver_number, meeting_key, position, lap_duration`
+
+func (s *Store) ListStartingGrid(ctx context.Context, sessionKey int) ([]openf1.StartingGrid, error) {
+	return listAll(ctx, s.db, `SELECT `+startingGridColumns+` FROM starting_grid WHERE session_key = ? ORDER BY position`,
+		func(rows *sql.Rows) (openf1.StartingGrid, error) {
+			var g openf1.StartingGrid
+			err := rows.Scan(&g.SessionKey, &g.DriverNumber, &g.MeetingKey, &g.Position, &g.LapDuration)
+			return g, err
+		}, sessionKey)
+}
+
+func (s *Store) SaveStartingGrid(ctx context.Context, grid []openf1.StartingGrid) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO starting_grid (`+startingGridColumns+`) VALUES (?, ?, ?, ?, ?)`, grid,
+		func(g openf1.StartingGrid) []any {
+			return []any{g.SessionKey, g.DriverNumber, g.MeetingKey, g.Position, g.LapDuration}
+		})
+}
+
+// Race control
+const raceControlColumns = `session_key, date, category, message, meeting_key, driver_number, flag, lap_number,
+	qualifying_phase, scope, sector`
+
+func (s *Store) ListRaceControl(ctx context.Context, sessionKey int) ([]openf1.RaceControl, error) {
+	return listAll(ctx, s.db, `SELECT `+raceControlColumns+` FROM race_control WHERE session_key = ? ORDER BY date`,
+		func(rows *sql.Rows) (openf1.RaceControl, error) {
+			var m openf1.RaceControl
+			var date string
+			err := rows.Scan(&m.SessionKey, &date, &m.Category, &m.Message, &m.MeetingKey, &m.DriverNumber, &m.Flag,
+				&m.LapNumber, &m.QualifyingPhase, &m.Scope, &m.Sector)
+			if err != nil {
+				return m, err
+			}
+			m.Date, err = parseTime(date)
+			return m, err
+		}, sessionKey)
+}
+
+func (s *Store) SaveRaceControl(ctx context.Context, messages []openf1.RaceControl) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO race_control (`+raceControlColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, messages,
+		func(m openf1.RaceControl) []any {
+			return []any{m.SessionKey, formatTime(m.Date), m.Category, m.Message, m.MeetingKey, m.DriverNumber, m.Flag,
+				m.LapNumber, m.QualifyingPhase, m.Scope, m.Sector}
+		})
+}
+
+// Position
+const positionColumns = `session_key, driver_number, date, meeting_key, position`
+
+func (s *Store) ListPositions(ctx context.Context, sessionKey int) ([]openf1.Position, error) {
+	return listAll(ctx, s.db, `SELECT `+positionColumns+` FROM position WHERE session_key = ? ORDER BY date, driver_number`,
+		func(rows *sql.Rows) (openf1.Position, error) {
+			var p openf1.Position
+			var date string
+			err := rows.Scan(&p.SessionKey, &p.DriverNumber, &date, &p.MeetingKey, &p.Position)
+			if err != nil {
+				return p, err
+			}
+			p.Date, err = parseTime(date)
+			return p, err
+		}, sessionKey)
+}
+
+func (s *Store) SavePositions(ctx context.Context, positions []openf1.Position) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO position (`+positionColumns+`) VALUES (?, ?, ?, ?, ?)`, positions,
+		func(p openf1.Position) []any {
+			return []any{p.SessionKey, p.DriverNumber, formatTime(p.Date), p.MeetingKey, p.Position}
+		})
+}
+
+// Intervals
+const intervalColumns = `session_key, driver_number, date, meeting_key, gap_to_leader, interval`
+
+func (s *Store) ListIntervals(ctx context.Context, sessionKey int) ([]openf1.Interval, error) {
+	return listAll(ctx, s.db, `SELECT `+intervalColumns+` FROM intervals WHERE session_key = ? ORDER BY date, driver_number`,
+		func(rows *sql.Rows) (openf1.Interval, error) {
+			var i openf1.Interval
+			var date string
+			var gapToLeader, interval sql.NullString
+			err := rows.Scan(&i.SessionKey, &i.DriverNumber, &date, &i.MeetingKey, &gapToLeader, &interval)
+			if err != nil {
+				return i, err
+			}
+			i.GapToLeader, i.Interval = rawJSON(gapToLeader), rawJSON(interval)
+			i.Date, err = parseTime(date)
+			return i, err
+		}, sessionKey)
+}
+
+func (s *Store) SaveIntervals(ctx context.Context, intervals []openf1.Interval) error {
+	return saveAll(ctx, s.db, `INSERT OR REPLACE INTO intervals (`+intervalColumns+`) VALUES (?, ?, ?, ?, ?, ?)`, intervals,
+		func(i openf1.Interval) []any {
+			return []any{i.SessionKey, i.DriverNumber, formatTime(i.Date), i.MeetingKey, nullJSON(i.GapToLeader),
+				nullJSON(i.Interval)}
+		})
+}
+
+// nullJSON stores raw JSON as text, NULL when openf1 left the field out
+func nullJSON(raw json.RawMessage) sql.NullString {
+	if len(raw) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(raw), Valid: true}
+}
+
+func rawJSON(value sql.NullString) json.RawMessage {
+	if !value.Valid {
+		return nil
+	}
+	return json.RawMessage(value.String)
+}
@@ -0,0 +1,219 @@
+// package for the SQLite implementation of the store
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// registers the pure Go "sqlite" driver, no cgo needed
+	_ "modernc.org/sqlite"
+
+	"telem-api-server/store"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// timestamps are stored in UTC with a fixed number of fractional digits so they sort as text
+const timeFormat = "2006-01-02T15:04:05.000000Z"
+
+type Store struct {
+	db *sql.DB
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Open opens (or creates) the database at path and applies any migrations it hasn't had yet
+func Open(path string) (*Store, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening store: %w", err)
+	}
+	s := &Store{db: db}
+	if err := s.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies the embedded migrations in order, each in its own transaction
+// migrations are named {version}_{description}.sql and the applied versions are kept in schema_migrations
+func (s *Store) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+
+	applied := map[int]bool{}
+	rows, err := s.db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	type migration struct {
+		version int
+		name    string
+	}
+	var pending []migration
+	for _, name := range files {
+		base := strings.TrimPrefix(name, "migrations/")
+		versionStr, _, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return fmt.Errorf("invalid migration name %s", name)
+		}
+		if !applied[version] {
+			pending = append(pending, migration{version: version, name: name})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].version < pending[j].version
+	})
+
+	for _, m := range pending {
+		script, err := migrations.ReadFile(m.name)
+		if err != nil {
+			return err
+		}
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error applying migration %s: %w", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+			m.version, formatTime(time.Now())); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("applied store migration %s", m.name)
+	}
+	return nil
+}
+
+// GetFetch returns store.ErrNotFound if the resource has never been fetched for the scope
+func (s *Store) GetFetch(ctx context.Context, resource string, scope string) (store.Fetch, error) {
+	fetch := store.Fetch{Resource: resource, Scope: scope}
+	var fetchedAt string
+	err := s.db.QueryRowContext(ctx, `SELECT fetched_at, complete FROM fetches WHERE resource = ? AND scope = ?`,
+		resource, scope).Scan(&fetchedAt, &fetch.Complete)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fetch, store.ErrNotFound
+	}
+	if err != nil {
+		return fetch, err
+	}
+	fetch.FetchedAt, err = parseTime(fetchedAt)
+	return fetch, err
+}
+
+func (s *Store) SaveFetch(ctx context.Context, fetch store.Fetch) error {
+	_, err := s.db.ExecContext(ctx, `INSERT OR REPLACE INTO fetches (resource, scope, fetched_at, complete) VALUES (?, ?, ?, ?)`,
+		fetch.Resource, fetch.Scope, formatTime(fetch.FetchedAt), fetch.Complete)
+	return err
+}
+
+// saveAll upserts rows in a single transaction with a prepared statement, which matters for car data
+func saveAll[T any](ctx context.Context, db *sql.DB, query string, rows []T, args func(T) []any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, args(row)...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// listAll runs a query and scans every row
+func listAll[T any](ctx context.Context, db *sql.DB, query string, scan func(*sql.Rows) (T, error), args ...any) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []T
+	for rows.Next() {
+		row, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, row)
+	}
+	return results, rows.Err()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// nullTime stores zero times as NULL, openf1 sends null for dates it doesn't know
+func nullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatTime(t), Valid: true}
+}
+
+func parseTime(value string) (time.Time, error) {
+	return time.Parse(timeFormat, value)
+}
+
+func parseNullTime(value sql.NullString) (time.Time, error) {
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+	return parseTime(value.String)
+}
@@ -0,0 +1,199 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+// openTemp opens a store in a file of its own that is removed with the test
+func openTemp(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "f1.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestMigrationsAppliedOnce(t *testing.T) {
+	s, path := openTemp(t)
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := func(s *Store) map[int]string {
+		t.Helper()
+		rows, err := s.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		versions := map[int]string{}
+		for rows.Next() {
+			var version int
+			var at string
+			if err := rows.Scan(&version, &at); err != nil {
+				t.Fatal(err)
+			}
+			versions[version] = at
+		}
+		return versions
+	}
+	first := applied(s)
+	if len(first) != len(files) {
+		t.Fatalf("%d migrations applied, want %d", len(first), len(files))
+	}
+	if err := s.SaveSessions(context.Background(), []openf1.Session{{SessionKey: 9158}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// reopening finds every migration applied and leaves the data alone
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if again := applied(reopened); !reflect.DeepEqual(again, first) {
+		t.Errorf("migrations after reopening = %v, want %v", again, first)
+	}
+	if _, err := reopened.GetSession(context.Background(), 9158); err != nil {
+		t.Errorf("session saved before reopening: %v", err)
+	}
+}
+
+func TestLapRoundTrip(t *testing.T) {
+	s, _ := openTemp(t)
+	ctx := context.Background()
+	complete := openf1.Lap{
+		MeetingKey: 1219, SessionKey: 9158, DriverNumber: 1, LapNumber: 2,
+		DateStart:  time.Date(2023, 9, 17, 12, 3, 4, 123000000, time.UTC),
+		DurationS1: ptr(33.1), DurationS2: ptr(40.25), DurationS3: ptr(23.8),
+		SpeedI1: ptr(281), SpeedI2: ptr(254), LapDuration: ptr(97.15), StSpeed: ptr(301),
+		SegmentsS1: []int{2049, 2049, 2051}, SegmentsS2: []int{2048}, SegmentsS3: []int{},
+	}
+	// an out lap openf1 sent without times, speeds, segments or a start
+	incomplete := openf1.Lap{MeetingKey: 1219, SessionKey: 9158, DriverNumber: 1, LapNumber: 1, IsPitOutLap: true}
+	if err := s.SaveLaps(ctx, []openf1.Lap{complete, incomplete}); err != nil {
+		t.Fatal(err)
+	}
+
+	laps, err := s.ListLaps(ctx, 9158)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(laps) != 2 {
+		t.Fatalf("got %d laps, want 2", len(laps))
+	}
+	if !reflect.DeepEqual(laps[0], incomplete) {
+		t.Errorf("lap 1 = %+v, want %+v", laps[0], incomplete)
+	}
+	if laps[0].LapDuration != nil || laps[0].SpeedI1 != nil || laps[0].SegmentsS1 != nil {
+		t.Errorf("lap 1 has values openf1 didn't send: %+v", laps[0])
+	}
+	if !reflect.DeepEqual(laps[1], complete) {
+		t.Errorf("lap 2 = %+v, want %+v", laps[1], complete)
+	}
+	// an empty sector is not the same as one openf1 didn't send
+	if laps[1].SegmentsS3 == nil {
+		t.Error("empty segments came back as nil")
+	}
+}
+
+func TestSessionZeroDatesStoredAsNull(t *testing.T) {
+	s, _ := openTemp(t)
+	ctx := context.Background()
+	session := openf1.Session{
+		SessionKey: 9158, MeetingKey: 1219, CircuitShortName: "Singapore", SessionName: "Race", SessionType: "Race",
+		DateStart: time.Date(2023, 9, 17, 12, 0, 0, 0, time.UTC), GmtOffset: "08:00:00", Year: 2023,
+	}
+	if err := s.SaveSessions(ctx, []openf1.Session{session}); err != nil {
+		t.Fatal(err)
+	}
+
+	var dateStart, dateEnd *string
+	err := s.db.QueryRow(`SELECT date_start, date_end FROM sessions WHERE session_key = ?`, 9158).Scan(&dateStart, &dateEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dateStart == nil || dateEnd != nil {
+		t.Errorf("date_start = %v, date_end = %v, want a start and a NULL end", dateStart, dateEnd)
+	}
+
+	got, err := s.GetSession(ctx, 9158)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, session) {
+		t.Errorf("session = %+v, want %+v", got, session)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	s, _ := openTemp(t)
+	ctx := context.Background()
+	if _, err := s.GetSession(ctx, 9158); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetSession err = %v, want store.ErrNotFound", err)
+	}
+	if _, err := s.GetFetch(ctx, "laps", "session_key=9158"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetFetch err = %v, want store.ErrNotFound", err)
+	}
+
+	fetch := store.Fetch{Resource: "laps", Scope: "session_key=9158", FetchedAt: time.Date(2023, 9, 17, 15, 0, 0, 0, time.UTC), Complete: true}
+	if err := s.SaveFetch(ctx, fetch); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetFetch(ctx, "laps", "session_key=9158")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fetch) {
+		t.Errorf("fetch = %+v, want %+v", got, fetch)
+	}
+}
+
+func TestSessionResultsOrder(t *testing.T) {
+	s, _ := openTemp(t)
+	ctx := context.Background()
+	results := []openf1.SessionResult{
+		{SessionKey: 9158, DriverNumber: 63, DNF: true},
+		{SessionKey: 9158, DriverNumber: 55, Position: ptr(1), Duration: ptr(5612.3), NumberOfLaps: 62},
+		{SessionKey: 9158, DriverNumber: 18, DNS: true},
+		{SessionKey: 9158, DriverNumber: 4, Position: ptr(2), Duration: ptr(5612.8), NumberOfLaps: 62},
+	}
+	if err := s.SaveSessionResults(ctx, results); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.ListSessionResults(ctx, 9158)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// classified drivers by position, then the unclassified by car number
+	want := []int{55, 4, 18, 63}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got), len(want))
+	}
+	for i, driver := range want {
+		if got[i].DriverNumber != driver {
+			t.Errorf("result %d = car %d, want car %d", i, got[i].DriverNumber, driver)
+		}
+	}
+	if got[2].Position != nil || !got[2].DNS || got[3].Position != nil || !got[3].DNF {
+		t.Errorf("unclassified results = %+v %+v, want no positions", got[2], got[3])
+	}
+}
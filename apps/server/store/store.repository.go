@@ -0,0 +1,109 @@
+// package for persisting openf1 data so it only has to be fetched once
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"telem-api-server/openf1"
+)
+
+// ErrNotFound is returned when a single record is asked for and isn't stored
+var ErrNotFound = errors.New("not found")
+
+type SessionRepository interface {
+	ListSessions(ctx context.Context) ([]openf1.Session, error)
+	GetSession(ctx context.Context, sessionKey int) (openf1.Session, error)
+	SaveSessions(ctx context.Context, sessions []openf1.Session) error
+}
+
+type MeetingRepository interface {
+	ListMeetings(ctx context.Context, year int) ([]openf1.Meeting, error)
+	SaveMeetings(ctx context.Context, meetings []openf1.Meeting) error
+}
+
+type DriverRepository interface {
+	ListDrivers(ctx context.Context, sessionKey int) ([]openf1.Driver, error)
+	SaveDrivers(ctx context.Context, drivers []openf1.Driver) error
+}
+
+type LapRepository interface {
+	ListLaps(ctx context.Context, sessionKey int) ([]openf1.Lap, error)
+	SaveLaps(ctx context.Context, laps []openf1.Lap) error
+}
+
+type StintRepository interface {
+	ListStints(ctx context.Context, sessionKey int) ([]openf1.Stint, error)
+	SaveStints(ctx context.Context, stints []openf1.Stint) error
+}
+
+type CarDataRepository interface {
+	// driverNumber 0 lists every driver
+	ListCarData(ctx context.Context, sessionKey int, driverNumber int) ([]openf1.CarData, error)
+	SaveCarData(ctx context.Context, samples []openf1.CarData) error
+}
+
+type LocationRepository interface {
+	// driverNumber 0 lists every driver
+	ListLocation(ctx context.Context, sessionKey int, driverNumber int) ([]openf1.Location, error)
+	SaveLocation(ctx context.Context, samples []openf1.Location) error
+}
+
+type SessionResultRepository interface {
+	ListSessionResults(ctx context.Context, sessionKey int) ([]openf1.SessionResult, error)
+	SaveSessionResults(ctx context.Context, results []openf1.SessionResult) error
+}
+
+type StartingGridRepository interface {
+	ListStartingGrid(ctx context.Context, sessionKey int) ([]openf1.StartingGrid, error)
+	SaveStartingGrid(ctx context.Context, grid []openf1.StartingGrid) error
+}
+
+type RaceControlRepository interface {
+	ListRaceControl(ctx context.Context, sessionKey int) ([]openf1.RaceControl, error)
+	SaveRaceControl(ctx context.Context, messages []openf1.RaceControl) error
+}
+
+type PositionRepository interface {
+	ListPositions(ctx context.Context, sessionKey int) ([]openf1.Position, error)
+	SavePositions(ctx context.Context, positions []openf1.Position) error
+}
+
+type IntervalRepository interface {
+	ListIntervals(ctx context.Context, sessionKey int) ([]openf1.Interval, error)
+	SaveIntervals(ctx context.Context, intervals []openf1.Interval) error
+}
+
+// Fetch records that a resource has been fetched from openf1 for a scope, e.g. laps for session_key=9158
+// Complete means the data won't change any more and never has to be fetched again
+type Fetch struct {
+	Resource  string
+	Scope     string
+	FetchedAt time.Time
+	Complete  bool
+}
+
+type FetchLog interface {
+	GetFetch(ctx context.Context, resource string, scope string) (Fetch, error)
+	SaveFetch(ctx context.Context, fetch Fetch) error
+}
+
+// Store is everything the server persists
+type Store interface {
+	SessionRepository
+	MeetingRepository
+	DriverRepository
+	LapRepository
+	StintRepository
+	CarDataRepository
+	LocationRepository
+	SessionResultRepository
+	StartingGridRepository
+	RaceControlRepository
+	PositionRepository
+	IntervalRepository
+	FetchLog
+	Ping(ctx context.Context) error
+	Close() error
+}
@@ -0,0 +1,399 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"telem-api-server/metrics"
+	"telem-api-server/openf1"
+)
+
+var tracer = otel.Tracer("telem-api-server/store")
+
+// openf1 can keep processing a session for a while after it ends, data is only treated as final after this
+const settleTime = time.Hour
+
+// Source reads openf1 through Store, the session and meeting lists until they are TTL old and the rest for good once a session is final
+// a nil Store sends every read but the session list to Client, the server and the backfill each build one and hand it to everything that reads
+type Source struct {
+	Client Client
+	Store  Store
+	// SessionCache keeps the session list, StoredSessions(Store) when built by NewSource or a MemoryList without a Store
+	SessionCache ListCache[openf1.Session]
+	Clock        Clock
+	TTL          time.Duration
+}
+
+// NewSource reads through client, keeping what it fetches in s when there is one
+// without a store the session list is still kept in memory, every route looks sessions up and openf1 being down shouldn't fail them all
+func NewSource(client Client, s Store, ttl time.Duration) *Source {
+	source := &Source{Client: client, Store: s, Clock: SystemClock{}, TTL: ttl, SessionCache: &MemoryList[openf1.Session]{}}
+	if s != nil {
+		source.SessionCache = StoredSessions(s)
+	}
+	return source
+}
+
+// Sessions returns every session, from the cache while it is fresh and from openf1 otherwise
+// if openf1 can't be reached the cached sessions are served however old they are
+func (s *Source) Sessions(ctx context.Context) ([]openf1.Session, error) {
+	fetch := func(ctx context.Context) ([]openf1.Session, error) {
+		var sessions []openf1.Session
+		err := s.Client.Get(ctx, "sessions", nil, &sessions)
+		return sessions, err
+	}
+	if s.SessionCache == nil {
+		return fetch(ctx)
+	}
+	return readThroughList(ctx, "sessions", "", fetch, s.SessionCache, s.Clock, s.TTL)
+}
+
+// Meetings returns the meetings of a season
+func (s *Source) Meetings(ctx context.Context, year int) ([]openf1.Meeting, error) {
+	params := url.Values{}
+	params.Set("year", strconv.Itoa(year))
+	fetch := func(ctx context.Context) ([]openf1.Meeting, error) {
+		var meetings []openf1.Meeting
+		err := s.Client.Get(ctx, "meetings", params, &meetings)
+		return meetings, err
+	}
+	if s.Store == nil {
+		return fetch(ctx)
+	}
+	cache := storedList[openf1.Meeting]{
+		store:    s.Store,
+		resource: "meetings",
+		scope:    params.Encode(),
+		list: func(ctx context.Context) ([]openf1.Meeting, error) {
+			return s.Store.ListMeetings(ctx, year)
+		},
+		save: s.Store.SaveMeetings,
+	}
+	return readThroughList(ctx, "meetings", cache.scope, fetch, cache, s.Clock, s.TTL)
+}
+
+func (s *Source) Drivers(ctx context.Context, sessionKey int) ([]openf1.Driver, error) {
+	if s.Store == nil {
+		return fetchBySession[openf1.Driver](ctx, s.Client, "drivers", sessionKey, nil)
+	}
+	return readThroughSession(ctx, s, "drivers", sessionKey, nil, s.Store.ListDrivers, s.Store.SaveDrivers)
+}
+
+func (s *Source) Laps(ctx context.Context, sessionKey int) ([]openf1.Lap, error) {
+	if s.Store == nil {
+		return fetchBySession[openf1.Lap](ctx, s.Client, "laps", sessionKey, nil)
+	}
+	return readThroughSession(ctx, s, "laps", sessionKey, nil, s.Store.ListLaps, s.Store.SaveLaps)
+}
+
+func (s *Source) Stints(ctx context.Context, sessionKey int) ([]openf1.Stint, error) {
+	if s.Store == nil {
+		return fetchBySession[openf1.Stint](ctx, s.Client, "stints", sessionKey, nil)
+	}
+	return readThroughSession(ctx, s, "stints", sessionKey, nil, s.Store.ListStints, s.Store.SaveStints)
+}
+
+// CarData returns the telemetry for one driver in a session, car data is too big to fetch a whole session at once
+func (s *Source) CarData(ctx context.Context, sessionKey int, driverNumber int) ([]openf1.CarData, error) {
+	params := url.Values{}
+	params.Set("driver_number", strconv.Itoa(driverNumber))
+	if s.Store == nil {
+		return fetchBySession[openf1.CarData](ctx, s.Client, "car_data", sessionKey, params)
+	}
+	list := func(ctx context.Context, sessionKey int) ([]openf1.CarData, error) {
+		return s.Store.ListCarData(ctx, sessionKey, driverNumber)
+	}
+	return readThroughSession(ctx, s, "car_data", sessionKey, params, list, s.Store.SaveCarData)
+}
+
+// Location returns where one driver's car was through a session, sampled like car data and fetched per driver for the same reason
+func (s *Source) Location(ctx context.Context, sessionKey int, driverNumber int) ([]openf1.Location, error) {
+	params := url.Values{}
+	params.Set("driver_number", strconv.Itoa(driverNumber))
+	if s.Store == nil {
+		return fetchBySession[openf1.Location](ctx, s.Client, "location", sessionKey, params)
+	}
+	list := func(ctx context.Context, sessionKey int) ([]openf1.Location, error) {
+		return s.Store.ListLocation(ctx, sessionKey, driverNumber)
+	}
+	return readThroughSession(ctx, s, "location", sessionKey, params, list, s.Store.SaveLocation)
+}
+
+func (s *Source) SessionResults(ctx context.Context, sessionKey int) ([]openf1.SessionResult, error) {
+	if s.Store == nil {
+		return fetchBySession[openf1.SessionResult](ctx, s.Client, "session_result", sessionKey, nil)
+	}
+	return readThroughSession(ctx, s, "session_result", sessionKey, nil, s.Store.ListSessionResults, s.Store.SaveSessionResults)
+}
+
+// StartingGrid returns the grid set in a qualifying or sprint shootout session
+func (s *Source) StartingGrid(ctx context.Context, sessionKey int) ([]openf1.StartingGrid, error) {
+	if s.Store == nil {
+		return fetchBySession[openf1.StartingGrid](ctx, s.Client, "starting_grid", sessionKey, nil)
+	}
+	return readThroughSession(ctx, s, "starting_grid", sessionKey, nil, s.Store.ListStartingGrid, s.Store.SaveStartingGrid)
+}
+
+func (s *Source) RaceControl(ctx context.Context, sessionKey int) ([]openf1.RaceControl, error) {
+	if s.Store == nil {
+		return fetchBySession[openf1.RaceControl](ctx, s.Client, "race_control", sessionKey, nil)
+	}
+	return readThroughSession(ctx, s, "race_control", sessionKey, nil, s.Store.ListRaceControl, s.Store.SaveRaceControl)
+}
+
+// Positions returns every change in running position through a session, small enough to fetch for all drivers at once
+func (s *Source) Positions(ctx context.Context, sessionKey int) ([]openf1.Position, error) {
+	if s.Store == nil {
+		return fetchBySession[openf1.Position](ctx, s.Client, "position", sessionKey, nil)
+	}
+	return readThroughSession(ctx, s, "position", sessionKey, nil, s.Store.ListPositions, s.Store.SavePositions)
+}
+
+// Intervals returns the gaps between drivers through a race, openf1 has none for other sessions
+func (s *Source) Intervals(ctx context.Context, sessionKey int) ([]openf1.Interval, error) {
+	if s.Store == nil {
+		return fetchBySession[openf1.Interval](ctx, s.Client, "intervals", sessionKey, nil)
+	}
+	return readThroughSession(ctx, s, "intervals", sessionKey, nil, s.Store.ListIntervals, s.Store.SaveIntervals)
+}
+
+// Complete reports whether a resource has already been fetched for a finished session and will be served from the store
+func (s *Source) Complete(ctx context.Context, resource string, sessionKey int, params url.Values) bool {
+	if s.Store == nil {
+		return false
+	}
+	fetch, err := s.Store.GetFetch(ctx, resource, sessionScope(sessionKey, params))
+	return err == nil && fetch.Complete
+}
+
+func fetchBySession[T any](ctx context.Context, client Client, resource string, sessionKey int, params url.Values) ([]T, error) {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("session_key", strconv.Itoa(sessionKey))
+	var rows []T
+	err := client.Get(ctx, resource, query, &rows)
+	return rows, err
+}
+
+// Client is the part of the openf1 client lookups fetch through, *openf1.Client in production
+type Client interface {
+	Get(ctx context.Context, resource string, params url.Values, out any) error
+}
+
+// Clock tells lookups the time, so tests can decide when a cached list goes stale
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// ListCache keeps a list between lookups, fetchedAt is zero when it hasn't been fetched yet
+type ListCache[T any] interface {
+	Load(ctx context.Context) (rows []T, fetchedAt time.Time, err error)
+	Save(ctx context.Context, rows []T, fetchedAt time.Time) error
+}
+
+// MemoryList keeps a list in memory for as long as the process runs, the zero value is empty
+type MemoryList[T any] struct {
+	mu        sync.Mutex
+	rows      []T
+	fetchedAt time.Time
+}
+
+func (c *MemoryList[T]) Load(ctx context.Context) ([]T, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rows, c.fetchedAt, nil
+}
+
+func (c *MemoryList[T]) Save(ctx context.Context, rows []T, fetchedAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rows, c.fetchedAt = rows, fetchedAt
+	return nil
+}
+
+// storedList keeps a list in a Store, its age comes from the fetch log the per session resources use
+type storedList[T any] struct {
+	store    Store
+	resource string
+	scope    string
+	list     func(ctx context.Context) ([]T, error)
+	save     func(ctx context.Context, rows []T) error
+}
+
+// StoredSessions caches the session list in s
+func StoredSessions(s Store) ListCache[openf1.Session] {
+	return storedList[openf1.Session]{store: s, resource: "sessions", list: s.ListSessions, save: s.SaveSessions}
+}
+
+// Load returns what is stored even if it was never fetched, a backfill can leave rows without a fetch log entry
+func (c storedList[T]) Load(ctx context.Context) ([]T, time.Time, error) {
+	var fetchedAt time.Time
+	fetch, err := c.store.GetFetch(ctx, c.resource, c.scope)
+	switch {
+	case err == nil:
+		fetchedAt = fetch.FetchedAt
+	case !errors.Is(err, ErrNotFound):
+		return nil, time.Time{}, err
+	}
+	rows, err := c.list(ctx)
+	return rows, fetchedAt, err
+}
+
+func (c storedList[T]) Save(ctx context.Context, rows []T, fetchedAt time.Time) error {
+	if err := c.save(ctx, rows); err != nil {
+		return err
+	}
+	return c.store.SaveFetch(ctx, Fetch{Resource: c.resource, Scope: c.scope, FetchedAt: fetchedAt})
+}
+
+// readThroughList serves lists that grow over time (sessions, meetings) from cache until they are ttl old
+func readThroughList[T any](
+	ctx context.Context,
+	resource string,
+	scope string,
+	fetch func(ctx context.Context) ([]T, error),
+	cache ListCache[T],
+	clock Clock,
+	ttl time.Duration,
+) ([]T, error) {
+	ctx, span := tracer.Start(ctx, "store "+resource, trace.WithAttributes(attribute.String("store.scope", scope)))
+	defer span.End()
+
+	cached, fetchedAt, err := cache.Load(ctx)
+	if err != nil {
+		// a broken cache only costs a trip to openf1
+		log.Printf("Error reading stored %s: %v", resource, err)
+		cached = nil
+	} else if !fetchedAt.IsZero() && clock.Now().Sub(fetchedAt) < ttl {
+		recordLookup(span, resource, "hit")
+		return cached, nil
+	}
+
+	rows, err := fetch(ctx)
+	if err != nil {
+		// openf1 being down shouldn't take us down with it if we have the data already
+		if len(cached) > 0 {
+			log.Printf("serving stored %s, openf1 fetch failed: %v", resource, err)
+			recordLookup(span, resource, "stale")
+			MarkStale(ctx, resource)
+			return cached, nil
+		}
+		recordLookup(span, resource, "miss")
+		return nil, err
+	}
+	recordLookup(span, resource, "miss")
+	// what has been fetched is kept even if the client that asked for it has gone
+	if err := cache.Save(context.WithoutCancel(ctx), rows, clock.Now()); err != nil {
+		log.Printf("Error saving %s: %v", resource, err)
+	}
+	return rows, nil
+}
+
+// readThroughSession serves a per session resource from the store once it has been fetched for a finished session
+// openf1.ErrNoResults is returned when there is nothing for the session, the same as fetching it directly
+func readThroughSession[T any](
+	ctx context.Context,
+	s *Source,
+	resource string,
+	sessionKey int,
+	params url.Values,
+	list func(ctx context.Context, sessionKey int) ([]T, error),
+	save func(ctx context.Context, rows []T) error,
+) ([]T, error) {
+	scope := sessionScope(sessionKey, params)
+	ctx, span := tracer.Start(ctx, "store "+resource, trace.WithAttributes(attribute.String("store.scope", scope)))
+	defer span.End()
+
+	if previous, err := s.Store.GetFetch(ctx, resource, scope); err == nil && previous.Complete {
+		recordLookup(span, resource, "hit")
+		rows, err := list(ctx, sessionKey)
+		if err == nil && len(rows) == 0 {
+			return nil, openf1.ErrNoResults
+		}
+		return rows, err
+	}
+
+	rows, err := fetchBySession[T](ctx, s.Client, resource, sessionKey, params)
+	if err != nil && !errors.Is(err, openf1.ErrNoResults) {
+		if stored, storeErr := list(ctx, sessionKey); storeErr == nil && len(stored) > 0 {
+			log.Printf("serving stored %s for session %d, openf1 fetch failed: %v", resource, sessionKey, err)
+			recordLookup(span, resource, "stale")
+			MarkStale(ctx, resource)
+			return stored, nil
+		}
+		recordLookup(span, resource, "miss")
+		return nil, err
+	}
+	recordLookup(span, resource, "miss")
+	// what has been fetched is kept even if the client that asked for it has gone
+	ctx = context.WithoutCancel(ctx)
+
+	if len(rows) > 0 {
+		if saveErr := save(ctx, rows); saveErr != nil {
+			log.Printf("Error saving %s for session %d: %v", resource, sessionKey, saveErr)
+			return rows, err
+		}
+	}
+	fetch := Fetch{Resource: resource, Scope: scope, FetchedAt: s.Clock.Now(), Complete: isFinal(ctx, s.Store, s.Clock, sessionKey)}
+	if saveErr := s.Store.SaveFetch(ctx, fetch); saveErr != nil {
+		log.Printf("Error recording %s fetch for session %d: %v", resource, sessionKey, saveErr)
+	}
+	return rows, err
+}
+
+type staleObserversKey struct{}
+
+// WithStaleObserver returns a context whose lookups call observe when they serve stored rows because openf1 failed
+func WithStaleObserver(ctx context.Context, observe func(resource string)) context.Context {
+	observers, _ := ctx.Value(staleObserversKey{}).([]func(string))
+	return context.WithValue(ctx, staleObserversKey{}, append(observers[:len(observers):len(observers)], observe))
+}
+
+// MarkStale tells the observers on ctx that resource was served from storage in place of openf1
+// for caches kept outside the store, which still want the response flagged
+func MarkStale(ctx context.Context, resource string) {
+	observers, _ := ctx.Value(staleObserversKey{}).([]func(string))
+	for _, observe := range observers {
+		observe(resource)
+	}
+}
+
+// recordLookup counts a lookup as a hit, a miss or stale and notes it on the lookup's span
+func recordLookup(span trace.Span, resource string, result string) {
+	metrics.CacheLookups.WithLabelValues(resource, result).Inc()
+	span.SetAttributes(attribute.String("cache.result", result))
+}
+
+func sessionScope(sessionKey int, params url.Values) string {
+	scope := fmt.Sprintf("session_key=%d", sessionKey)
+	if len(params) > 0 {
+		scope += "&" + params.Encode()
+	}
+	return scope
+}
+
+// isFinal reports whether a session finished long enough ago that its data won't change
+func isFinal(ctx context.Context, st Store, clock Clock, sessionKey int) bool {
+	s, err := st.GetSession(ctx, sessionKey)
+	if err != nil || s.DateEnd.IsZero() {
+		return false
+	}
+	return clock.Now().Sub(s.DateEnd) > settleTime
+}
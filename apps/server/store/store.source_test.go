@@ -0,0 +1,58 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"telem-api-server/openf1"
+	"telem-api-server/store"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestSessionsCachedWithoutStore(t *testing.T) {
+	var calls atomic.Int32
+	var down atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode([]openf1.Session{{SessionKey: 9158}})
+	}))
+	t.Cleanup(upstream.Close)
+	client := openf1.NewClient(upstream.URL)
+	client.Retry.MaxAttempts = 1
+
+	source := store.NewSource(client, nil, time.Minute)
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	source.Clock = clock
+	ctx := context.Background()
+
+	for range 2 {
+		if sessions, err := source.Sessions(ctx); err != nil || len(sessions) != 1 {
+			t.Fatalf("sessions = %v, err = %v, want session 9158", sessions, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("openf1 called %d times within the ttl, want 1", n)
+	}
+
+	// once the list is old it's fetched again, and still served if openf1 is down
+	clock.now = clock.now.Add(2 * time.Minute)
+	down.Store(true)
+	if sessions, err := source.Sessions(ctx); err != nil || len(sessions) != 1 {
+		t.Fatalf("sessions = %v, err = %v, want the stale list", sessions, err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("openf1 called %d times, want 2", n)
+	}
+}